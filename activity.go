@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 最近动态：供看板等外部程序轮询最近的群聊消息与文件变更，无需保持 WebSocket
+
+const (
+	recentMessagesCap = 100 // 内存中保留的最近群聊消息条数
+	maxActivityItems  = 50  // 单次请求每类最多返回条数
+)
+
+type recentMessage struct {
+	Message
+	At time.Time `json:"at"`
+}
+
+var (
+	recentMessages   []recentMessage
+	recentMessagesMu sync.Mutex
+)
+
+// rememberMessage 记录经 broadcast 发出的消息（私聊不经过这里），只保留 -history-categories 中的分类
+func rememberMessage(msg WSMessage) {
+	category := msg.category()
+	if msg.Type == "message" && category == catChat {
+		countMessageStat(msg.Data.From)
+	}
+	if !delivers(destHistory, category) {
+		return
+	}
+	recentMessagesMu.Lock()
+	defer recentMessagesMu.Unlock()
+	switch msg.Type {
+	case "message":
+		recentMessages = append(recentMessages, recentMessage{Message: msg.Data, At: time.Now()})
+		if over := len(recentMessages) - recentMessagesCap; over > 0 {
+			recentMessages = append([]recentMessage(nil), recentMessages[over:]...)
+		}
+	case "edit":
+		if i := findRecentMessage(msg.Data.ID); i >= 0 {
+			m := &recentMessages[i].Message
+			if msg.Data.Deleted {
+				tombstone(m)
+				break
+			}
+			m.Text = msg.Data.Text
+			m.Deleted = false
+			if msg.Data.Attachments != nil {
+				m.Attachments = msg.Data.Attachments
+			}
+			if msg.Data.Translations != nil {
+				m.Translations = msg.Data.Translations
+			}
+		}
+	}
+}
+
+type ActivityItem struct {
+	Kind    string    `json:"kind"` // message / file
+	Time    time.Time `json:"time"`
+	Message *Message  `json:"message,omitempty"`
+	Op      string    `json:"op,omitempty"` // 文件事件：add / delete
+	File    *FileInfo `json:"file,omitempty"`
+}
+
+func activityCount(r *http.Request, key string, def int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil || n < 0 {
+		n = def
+	}
+	if n > maxActivityItems {
+		n = maxActivityItems
+	}
+	return n
+}
+
+// activityHandler GET /api/activity?messages=10&files=5，按时间倒序合并，支持 ETag。
+// 接口不需要身份，只列出默认房间与不属于任何房间的消息，其他房间的消息只发给其成员（见 rooms.go）
+func activityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	nMsg := activityCount(r, "messages", 10)
+	nFile := activityCount(r, "files", 5)
+
+	items := make([]ActivityItem, 0, nMsg+nFile)
+	recentMessagesMu.Lock()
+	for i, n := len(recentMessages)-1, 0; i >= 0 && n < nMsg; i-- {
+		m := recentMessages[i]
+		if m.Room != "" && m.Room != defaultRoom {
+			continue
+		}
+		items = append(items, ActivityItem{Kind: "message", Time: m.At, Message: &m.Message})
+		n++
+	}
+	recentMessagesMu.Unlock()
+
+	journalMu.Lock()
+	for i := max(0, len(journal)-nFile); i < len(journal); i++ {
+		c := journal[i]
+		items = append(items, ActivityItem{Kind: "file", Time: c.Time, Op: c.Op, File: &c.File})
+	}
+	journalMu.Unlock()
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Time.After(items[j].Time) })
+
+	data, _ := json.Marshal(map[string]interface{}{"items": items})
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
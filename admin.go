@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 管理员令牌：未设置时没有人具备管理员权限
+var adminToken = flag.String("admin-token", "", "管理员令牌，通过 Authorization: Bearer <token> 或 X-Admin-Token 头提供")
+
+// isAdmin 校验请求是否携带了正确的管理员令牌
+func isAdmin(r *http.Request) bool {
+	if *adminToken == "" {
+		return false
+	}
+	got := r.Header.Get("X-Admin-Token")
+	if auth := r.Header.Get("Authorization"); got == "" && strings.HasPrefix(auth, "Bearer ") {
+		got = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(*adminToken)) == 1
+}
+
+type ConnInfo struct {
+	UserID      string    `json:"userId"`
+	Device      string    `json:"device"`
+	Nick        string    `json:"nick,omitempty"` // 见 nicks.go
+	Color       string    `json:"color"`
+	Status      string    `json:"status"` // active / away，见 presence.go
+	Proto       int       `json:"proto"`  // 协商出的协议版本，见 protocol.go
+	Rooms       []string  `json:"rooms"`  // 所在的房间，见 rooms.go
+	UserAgent   string    `json:"userAgent,omitempty"`
+	RemoteAddr  string    `json:"remoteAddr,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Connections int       `json:"connections,omitempty"` // 该用户的连接数，仅按用户合并的列表中有，见 tabs.go
+	// 收发统计，见 /api/connections；/api/users 中仅管理员可见
+	MessagesIn  int64      `json:"messagesIn,omitempty"`
+	MessagesOut int64      `json:"messagesOut,omitempty"`
+	BytesIn     int64      `json:"bytesIn,omitempty"`
+	BytesOut    int64      `json:"bytesOut,omitempty"`
+	Queued      *int       `json:"queued,omitempty"`     // 发送队列中待写出的帧数
+	LastActive  *time.Time `json:"lastActive,omitempty"` // 最近一次收到消息帧的时间
+	Client      string     `json:"client,omitempty"`     // hello 中声明的客户端名称，见 hello.go
+	ClientCaps  *[]string  `json:"clientCaps,omitempty"` // hello 中采纳的能力，未发 hello 时省略
+	// 以下仅管理员可见：该用户今日累计流量
+	UserBytesIn  int64     `json:"userBytesIn,omitempty"`
+	UserBytesOut int64     `json:"userBytesOut,omitempty"`
+	Caps         *[]string `json:"caps,omitempty"` // 能渲染的富消息，其余收纯文本；空列表表示纯文本客户端
+	// 当前这次写已阻塞的毫秒数，以及该用户被写看门狗强制断开的次数，见 watchdog.go
+	WriteBlockedMs int64 `json:"writeBlockedMs,omitempty"`
+	ForcedCloses   int64 `json:"forcedCloses,omitempty"`
+	Dropped        int64 `json:"dropped,omitempty"` // 本连接因发送队列满丢弃的帧，见 writer.go
+	// 压缩统计，未协商压缩时省略，见 compression.go
+	Compression *CompressionInfo `json:"compression,omitempty"`
+}
+
+// connSnapshot 列出当前连接；full 为 true 时包含原始 UA、来源地址等仅管理员可见的信息，
+// stats 为 true 时包含收发统计（full 时总是包含）
+func connSnapshot(full, stats bool) []ConnInfo {
+	now := time.Now()
+	conns := clients.Conns()
+	list := make([]ConnInfo, 0, len(conns))
+	for _, c := range conns {
+		info := ConnInfo{UserID: c.userID, Nick: nickOf(c.userID), Device: c.device, Color: colorOf(c.userID), Status: c.status(), Proto: c.proto, Rooms: c.roomList(), ConnectedAt: c.connectedAt}
+		if full || stats {
+			// 只读原子计数与队列长度，不影响读写协程
+			info.MessagesIn, info.MessagesOut = c.framesIn.Load(), c.framesOut.Load()
+			info.BytesIn, info.BytesOut = c.bytesIn.Load(), c.bytesOut.Load()
+			queued := c.queue.len()
+			lastActive := time.Unix(0, c.lastActive.Load())
+			info.Queued, info.LastActive = &queued, &lastActive
+			if name, caps, ok := c.helloCaps(); ok {
+				info.Client, info.ClientCaps = name, &caps
+			}
+		}
+		if full {
+			info.UserAgent = c.userAgent
+			info.RemoteAddr = c.remoteAddr
+			caps := c.capList()
+			info.Caps = &caps
+			info.WriteBlockedMs = c.writeBlocked(now).Milliseconds()
+			info.Dropped = c.dropped.Load()
+			info.Compression = c.compressionInfo()
+		}
+		list = append(list, info)
+	}
+	if full {
+		for i := range list {
+			b := userBandwidthFor(list[i].UserID)
+			list[i].UserBytesIn, list[i].UserBytesOut = b.in.Load(), b.out.Load()
+			list[i].ForcedCloses, _ = userForcedCloses.Get(list[i].UserID)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].UserID != list[j].UserID {
+			return list[i].UserID < list[j].UserID
+		}
+		return list[i].ConnectedAt.Before(list[j].ConnectedAt)
+	})
+	return list
+}
+
+// distinctUsers 把 connSnapshot 的结果按用户合并：任一连接活跃即为 active，房间取并集，
+// 其余字段取最早的连接
+func distinctUsers(conns []ConnInfo) []ConnInfo {
+	var list []ConnInfo
+	for _, c := range conns {
+		if n := len(list); n > 0 && list[n-1].UserID == c.UserID {
+			u := &list[n-1]
+			u.Connections++
+			if c.Status == statusActive {
+				u.Status = statusActive
+			}
+			for _, room := range c.Rooms {
+				if !slices.Contains(u.Rooms, room) {
+					u.Rooms = append(u.Rooms, room)
+				}
+			}
+			sort.Strings(u.Rooms)
+			continue
+		}
+		c.Connections = 1
+		list = append(list, c)
+	}
+	return list
+}
+
+// usersHandler GET /api/users：在线用户及其设备类型，同一用户的多个连接只列一次
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(distinctUsers(connSnapshot(isAdmin(r), false)))
+}
+
+// connectionsHandler GET /api/connections：每个连接的收发统计，排查单个用户消息慢的问题；
+// 来源地址与 UA 仅管理员可见
+func connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(connSnapshot(isAdmin(r), true))
+}
+
+// adminConnectionsHandler GET /api/admin/connections：连接明细，需管理员令牌
+func adminConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		errAdminRequired(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(connSnapshot(true, true))
+}
+
+// adminUpgradeHandler POST /api/admin/upgrade，用磁盘上的新可执行文件平滑替换当前进程
+func adminUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	if !isAdmin(r) {
+		errAdminRequired(w, r)
+		return
+	}
+	if err := startUpgrade(); err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, "upgrade_unavailable", err.Error(), nil)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
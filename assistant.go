@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 可选的 LLM 助手：消息中包含触发词时，把最近的聊天上下文发给 OpenAI 兼容接口，流式回复
+
+var (
+	assistantEndpoint = flag.String("assistant-endpoint", "", "OpenAI 兼容的 chat/completions 地址，留空则不启用助手")
+	assistantModel    = flag.String("assistant-model", "", "助手使用的模型名")
+	assistantTrigger  = flag.String("assistant-trigger", "@bot", "触发助手回复的关键词")
+	assistantName     = flag.String("assistant-name", "bot", "助手在聊天中的名称")
+	assistantTimeout  = flag.Duration("assistant-timeout", 60*time.Second, "单次回复的最长时间")
+	assistantContext  = flag.Int("assistant-context", 10, "随请求附带的最近消息条数")
+)
+
+// 流式回复时最多每隔这么久推送一次 edit，避免刷屏
+const assistantEditInterval = 300 * time.Millisecond
+
+var (
+	assistantBusy    = make(map[string]bool) // 正在回答的房间
+	assistantHistory []Message
+	assistantMu      sync.Mutex
+)
+
+func newMessageID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// assistantObserve 记录群聊消息作为上下文，命中触发词时异步生成回复
+func assistantObserve(m Message) {
+	if *assistantEndpoint == "" || m.From == "system" {
+		return
+	}
+
+	assistantMu.Lock()
+	assistantHistory = append(assistantHistory, m)
+	if over := len(assistantHistory) - *assistantContext; over > 0 {
+		assistantHistory = append([]Message(nil), assistantHistory[over:]...)
+	}
+	// 只把同一房间的消息作为上下文，回复也发到该房间
+	var history []Message
+	for _, h := range assistantHistory {
+		if h.Room == m.Room {
+			history = append(history, h)
+		}
+	}
+	if m.From == *assistantName || !strings.Contains(m.Text, *assistantTrigger) {
+		assistantMu.Unlock()
+		return
+	}
+	// 每个房间同一时间只处理一个请求
+	busy := assistantBusy[m.Room]
+	assistantBusy[m.Room] = true
+	assistantMu.Unlock()
+	if busy {
+		broadcastBot(m.Room, newMessageID(), "⏳ 正在回答上一个问题，请稍后再问")
+		return
+	}
+	go func() {
+		defer func() {
+			assistantMu.Lock()
+			delete(assistantBusy, m.Room)
+			assistantMu.Unlock()
+		}()
+		assistantReply(m.Room, history)
+	}()
+}
+
+func broadcastBot(room, id, text string) {
+	broadcast(WSMessage{Type: "message", Data: Message{ID: id, Text: text, From: *assistantName, Time: time.Now().Format("15:04:05"), Room: room}})
+}
+
+func broadcastEdit(room, id, text string) {
+	broadcast(WSMessage{Type: "edit", Data: Message{ID: id, Text: text, From: *assistantName, Time: time.Now().Format("15:04:05"), Room: room}})
+}
+
+func assistantReply(room string, history []Message) {
+	id := newMessageID()
+	broadcastBot(room, id, "…")
+
+	ctx, cancel := context.WithTimeout(context.Background(), *assistantTimeout)
+	defer cancel()
+
+	var text strings.Builder
+	last := time.Now()
+	err := streamCompletion(ctx, history, func(chunk string) {
+		text.WriteString(chunk)
+		if time.Since(last) >= assistantEditInterval {
+			last = time.Now()
+			broadcastEdit(room, id, text.String())
+		}
+	})
+
+	reply := text.String()
+	switch {
+	case err != nil && reply == "":
+		log.Printf("助手请求失败: %v", err)
+		reply = "😥 抱歉，我暂时无法回答，请稍后再试"
+	case err != nil:
+		log.Printf("助手回复中断: %v", err)
+		reply += "…（回复中断）"
+	}
+	broadcastEdit(room, id, reply)
+
+	assistantMu.Lock()
+	assistantHistory = append(assistantHistory, Message{From: *assistantName, Text: reply, Room: room})
+	assistantMu.Unlock()
+}
+
+// streamCompletion 以 SSE 流式请求 chat/completions，每收到一段内容回调一次
+func streamCompletion(ctx context.Context, history []Message, onChunk func(string)) error {
+	msgs := []map[string]string{{
+		"role":    "system",
+		"content": fmt.Sprintf("你是聊天室里的助手 %s。以下是最近的群聊记录，格式为“发送者: 内容”，请简洁地回答最后一个向你提问的人。", *assistantName),
+	}}
+	for _, m := range history {
+		role := "user"
+		if m.From == *assistantName {
+			role = "assistant"
+		}
+		msgs = append(msgs, map[string]string{"role": role, "content": m.From + ": " + m.Text})
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    *assistantModel,
+		"stream":   true,
+		"messages": msgs,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *assistantEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := os.Getenv("GOCHAT_ASSISTANT_API_KEY"); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("assistant endpoint returned %s", resp.Status)
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return nil
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				onChunk(c.Delta.Content)
+			}
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,60 @@
+package main
+
+// 消息附件：群聊消息可引用已上传的文件（savedName），发送时校验文件存在，
+// 广播与 /api/activity 中附带完整的 FileInfo 以及原始文件名和 SHA-256。
+// 文件删除后，最近消息中引用它的附件改为墓碑（removed），并以 edit 通知在线客户端
+
+const maxAttachments = 10 // 每条消息最多附件数
+
+type Attachment struct {
+	SavedName string    `json:"savedName"`
+	Name      string    `json:"name"` // 原始文件名，文件删除后仍保留
+	SHA256    string    `json:"sha256,omitempty"`
+	File      *FileInfo `json:"file,omitempty"` // 文件删除后为空
+	Removed   bool      `json:"removed,omitempty"`
+}
+
+// resolveAttachments 把 savedName 展开为附件；只接受文件索引中的文件，
+// 名字来自客户端，不退回到磁盘查找。有文件不存在时 ok 为 false 并返回其 savedName（可能为空字符串）
+func resolveAttachments(names []string) (list []Attachment, missing string, ok bool) {
+	if len(names) == 0 {
+		return nil, "", true
+	}
+	list = make([]Attachment, 0, len(names))
+	for _, name := range names {
+		filesMu.RLock()
+		info, ok := fileList[name]
+		filesMu.RUnlock()
+		if !ok || isHiddenName(name) {
+			return nil, name, false
+		}
+		list = append(list, Attachment{SavedName: info.SavedName, Name: info.Name, SHA256: info.SHA256, File: &info})
+	}
+	return list, "", true
+}
+
+// tombstoneAttachments 文件删除后，把最近消息中引用它的附件标为已删除并广播 edit；
+// 最近消息由 rememberMessage 按 edit 更新
+func tombstoneAttachments(savedName string) {
+	var edited []Message
+	recentMessagesMu.Lock()
+	for _, m := range recentMessages {
+		changed := false
+		list := make([]Attachment, len(m.Attachments))
+		for i, a := range m.Attachments {
+			if a.SavedName == savedName && !a.Removed {
+				a.File, a.Removed, changed = nil, true, true
+			}
+			list[i] = a
+		}
+		if changed {
+			msg := m.Message
+			msg.Attachments = list
+			edited = append(edited, msg)
+		}
+	}
+	recentMessagesMu.Unlock()
+	for _, m := range edited {
+		broadcast(WSMessage{Type: "edit", Data: m})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 流量统计：按连接、按用户（userId）累计 WebSocket 帧与 HTTP 上传/下载字节。
+// 用户计数按天清零（-bandwidth-reset-hour），超过 -per-user-bandwidth-cap 后只能收发文字。
+// WebSocket 帧先记在连接自己的原子计数上，每 bandwidthFlushInterval 以及连接结束时并入用户计数，
+// 收发每一帧都不争用全局锁；读取某个用户的计数前先并入其连接尚未计入的部分。
+// 计数只保存在内存中，重启后清零
+
+var (
+	perUserBandwidthCap ByteSize
+	bandwidthResetHour  = flag.Int("bandwidth-reset-hour", 0, "每日用户流量计数清零的整点（本地时间 0-23）")
+)
+
+func init() {
+	flag.Var(&perUserBandwidthCap, "per-user-bandwidth-cap", "每个用户每天的流量上限（如 500M），超出后拒绝上传与文件中继，只能收发文字；0 表示不限")
+}
+
+type bandwidth struct {
+	in  atomic.Int64
+	out atomic.Int64
+}
+
+func (b *bandwidth) total() int64 { return b.in.Load() + b.out.Load() }
+
+const bandwidthFlushInterval = time.Second
+
+var (
+	// userId -> 当前周期内流量；超出容量时淘汰最久未活动的用户，其计数随之归零
+	userBandwidth   = newExpiringMap[string, *bandwidth]("bandwidth", 0, registryMaxEntries)
+	bandwidthPeriod time.Time // 当前周期起点
+	bandwidthMu     sync.Mutex
+
+	wsBytesIn, wsBytesOut atomic.Int64 // 全部 WebSocket 连接累计，供 /metrics
+)
+
+// periodStart 返回 now 所在计数周期的起点
+func periodStart(now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), *bandwidthResetHour, 0, 0, 0, now.Location())
+	if now.Before(t) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// rollBandwidthPeriod 跨过清零时刻时整体重置，调用方需持有 bandwidthMu
+func rollBandwidthPeriod() {
+	if p := periodStart(time.Now()); !p.Equal(bandwidthPeriod) {
+		userBandwidth.Clear()
+		bandwidthPeriod = p
+	}
+}
+
+// userBandwidthFor 取用户当前周期的计数，含其连接尚未并入的流量
+func userBandwidthFor(userID string) *bandwidth {
+	for _, c := range clients.ByID(userID) {
+		c.flushBandwidth()
+	}
+	return bandwidthEntry(userID)
+}
+
+// bandwidthEntry 取用户当前周期的计数，不存在时创建
+func bandwidthEntry(userID string) *bandwidth {
+	bandwidthMu.Lock()
+	defer bandwidthMu.Unlock()
+	rollBandwidthPeriod()
+	return userBandwidth.Update(userID, func(b *bandwidth, ok bool) *bandwidth {
+		if !ok {
+			b = &bandwidth{}
+		}
+		return b
+	})
+}
+
+func countBandwidth(userID string, in, out int64) {
+	if userID == "" {
+		return
+	}
+	b := bandwidthEntry(userID)
+	b.in.Add(in)
+	b.out.Add(out)
+}
+
+// overBandwidthCap 用户今日流量是否已达上限；匿名请求无法计量，不受限制
+func overBandwidthCap(userID string) bool {
+	if perUserBandwidthCap.Load() == 0 || userID == "" {
+		return false
+	}
+	return userBandwidthFor(userID).total() >= perUserBandwidthCap.Load()
+}
+
+func errBandwidthCap(w http.ResponseWriter, r *http.Request) {
+	l := localeFor(r)
+	reset := periodStart(time.Now()).AddDate(0, 0, 1)
+	msg := fmt.Sprintf("Daily bandwidth cap of %s exceeded, only text messages are allowed until it resets in %s", l.size(perUserBandwidthCap.Load()), l.duration(time.Until(reset)))
+	writeError(w, r, http.StatusTooManyRequests, "bandwidth_cap_exceeded", msg, map[string]interface{}{
+		"capBytes": perUserBandwidthCap.Load(),
+		"resetsAt": reset.UTC(),
+	})
+}
+
+// sent 记录成功写出的一帧，由写协程调用
+func (c *client) sent(n int) {
+	c.framesOut.Add(1)
+	c.bytesOut.Add(int64(n))
+	c.unflushedOut.Add(int64(n))
+	wsBytesOut.Add(int64(n))
+}
+
+// received 记录从连接读到的一帧
+func (c *client) received(n int) {
+	c.framesIn.Add(1)
+	c.bytesIn.Add(int64(n))
+	c.unflushedIn.Add(int64(n))
+	wsBytesIn.Add(int64(n))
+}
+
+// flushBandwidth 把连接尚未计入的流量并入其用户的计数，可由任意协程调用
+func (c *client) flushBandwidth() {
+	in, out := c.unflushedIn.Swap(0), c.unflushedOut.Swap(0)
+	if in != 0 || out != 0 {
+		countBandwidth(c.userID, in, out)
+	}
+}
+
+// flushAllBandwidth 并入所有在线连接尚未计入的流量
+func flushAllBandwidth() {
+	for _, c := range clients.Conns() {
+		c.flushBandwidth()
+	}
+}
+
+func startBandwidthFlusher() {
+	go func() {
+		for range time.Tick(bandwidthFlushInterval) {
+			flushAllBandwidth()
+		}
+	}()
+}
+
+// transferIdentity HTTP 传输的计量身份：有效的 X-Upload-Token 头或 token 查询参数对应的用户
+func transferIdentity(r *http.Request) string {
+	token := r.Header.Get("X-Upload-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return ""
+	}
+	t, ok := uploadTokens.Get(token)
+	if !ok {
+		return ""
+	}
+	return t.userID
+}
+
+type userBandwidthStat struct {
+	UserID   string `json:"userId"`
+	BytesIn  int64  `json:"bytesIn"`
+	BytesOut int64  `json:"bytesOut"`
+	Capped   bool   `json:"capped,omitempty"`
+}
+
+// bandwidthSnapshot 当前周期各用户流量，按总量降序
+func bandwidthSnapshot() (time.Time, []userBandwidthStat) {
+	flushAllBandwidth()
+	bandwidthMu.Lock()
+	rollBandwidthPeriod()
+	period := bandwidthPeriod
+	list := make([]userBandwidthStat, 0, userBandwidth.Len())
+	userBandwidth.Range(func(u string, b *bandwidth) {
+		s := userBandwidthStat{UserID: u, BytesIn: b.in.Load(), BytesOut: b.out.Load()}
+		s.Capped = perUserBandwidthCap.Load() > 0 && s.BytesIn+s.BytesOut >= perUserBandwidthCap.Load()
+		list = append(list, s)
+	})
+	bandwidthMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		ti, tj := list[i].BytesIn+list[i].BytesOut, list[j].BytesIn+list[j].BytesOut
+		if ti != tj {
+			return ti > tj
+		}
+		return list[i].UserID < list[j].UserID
+	})
+	return period, list
+}
+
+// setQuotaHeaders 在上传响应中给出本次请求实际适用的限制，成功与失败都带上。
+// 流量配额只对能识别身份的请求生效；Remaining 已扣除本次请求体
+func setQuotaHeaders(w http.ResponseWriter, r *http.Request, userID string) map[string]interface{} {
+	h := w.Header()
+	h.Set("X-Max-File-Size", strconv.FormatInt(maxSize.Load(), 10))
+	if perUserBandwidthCap.Load() == 0 || userID == "" {
+		return nil
+	}
+	limit := perUserBandwidthCap.Load()
+	remaining := max(0, limit-userBandwidthFor(userID).total()-max(0, r.ContentLength))
+	reset := periodStart(time.Now()).AddDate(0, 0, 1)
+	h.Set("X-Quota-Limit", strconv.FormatInt(limit, 10))
+	h.Set("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+	h.Set("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+	return map[string]interface{}{"limit": limit, "remaining": remaining, "reset": reset.Unix()}
+}
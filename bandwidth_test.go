@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// 收发帧只记在连接上，读取用户计数或连接结束时才并入
+func TestBandwidthFlush(t *testing.T) {
+	c := &client{conn: &websocket.Conn{}}
+	clients.Add(c, "bw")
+	defer clients.Remove(c.conn)
+
+	bandwidthMu.Lock()
+	userBandwidth.Delete("bw")
+	bandwidthMu.Unlock()
+
+	c.sent(100)
+	c.received(40)
+	bandwidthMu.Lock()
+	_, counted := userBandwidth.Get("bw")
+	bandwidthMu.Unlock()
+	if counted {
+		t.Fatal("frames were counted into the shared table before a flush")
+	}
+
+	b := userBandwidthFor("bw")
+	if b.in.Load() != 40 || b.out.Load() != 100 {
+		t.Fatalf("in = %d, out = %d, want 40, 100", b.in.Load(), b.out.Load())
+	}
+
+	clients.Remove(c.conn)
+	c.sent(10)
+	c.flushBandwidth() // 连接结束时
+	if got := bandwidthEntry("bw").out.Load(); got != 110 {
+		t.Fatalf("out after close = %d, want 110", got)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// bench 子命令：建立大量 WebSocket 连接，等待服务端重启/升级时断开，
+// 再按服务端下发的提示与退避策略重连，用于验证重连风暴下服务端能否扛住
+
+const benchMaxAttempts = 10
+
+func runBench(args []string) int {
+	set := flag.NewFlagSet("bench", flag.ExitOnError)
+	server := set.String("server", "http://127.0.0.1:3027", "服务端地址")
+	n := set.Int("clients", 100, "并发连接数")
+	wait := set.Duration("wait", 2*time.Minute, "等待服务端断开并完成重连的最长时间")
+	set.Parse(args)
+	if *n <= 0 {
+		fmt.Fprintln(os.Stderr, "❌ -clients 必须大于 0")
+		return 2
+	}
+	wsURL := strings.Replace(strings.TrimRight(*server, "/"), "http", "ws", 1) + "/ws?proto=2"
+
+	var (
+		mu        sync.Mutex
+		delays    []time.Duration // 从断开到重连成功
+		failed    int
+		connected sync.WaitGroup
+		done      sync.WaitGroup
+	)
+	connected.Add(*n)
+	done.Add(*n)
+	for i := 0; i < *n; i++ {
+		go func() {
+			defer done.Done()
+			d, err := benchClient(wsURL, &connected)
+			mu.Lock()
+			if err != nil {
+				failed++
+			} else {
+				delays = append(delays, d)
+			}
+			mu.Unlock()
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+	connected.Wait()
+	fmt.Printf("🔌 已建立 %d 个连接，等待服务端断开（重启或 SIGUSR2 升级）...\n", *n)
+
+	ch := make(chan struct{})
+	go func() { done.Wait(); close(ch) }()
+	select {
+	case <-ch:
+	case <-time.After(*wait):
+		fmt.Println("⏱️  等待超时")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	fmt.Printf("✅ 重连成功 %d，失败 %d，未完成 %d\n", len(delays), failed, *n-len(delays)-failed)
+	if len(delays) > 0 {
+		fmt.Printf("   重连耗时 p50=%v p90=%v max=%v\n",
+			delays[len(delays)/2].Round(time.Millisecond), delays[len(delays)*9/10].Round(time.Millisecond), delays[len(delays)-1].Round(time.Millisecond))
+	}
+	if failed > 0 || len(delays) < *n {
+		return 1
+	}
+	return 0
+}
+
+// benchClient 连接后等待被服务端关闭，再重连一次；返回从断开到重连成功的耗时
+func benchClient(wsURL string, connected *sync.WaitGroup) (time.Duration, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		connected.Done()
+		return 0, err
+	}
+	policy := reconnectBackoff
+	var init struct {
+		Reconnect *reconnectPolicy `json:"reconnect"`
+	}
+	if conn.ReadJSON(&init) == nil && init.Reconnect != nil {
+		policy = *init.Reconnect
+	}
+	connected.Done()
+
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	conn.Close()
+	closed := time.Now()
+
+	closeErr := err
+	for attempt := 0; ; attempt++ {
+		time.Sleep(reconnectDelay(closeErr, policy, attempt))
+		c, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err == nil {
+			c.Close()
+			return time.Since(closed), nil
+		}
+		if attempt >= benchMaxAttempts {
+			return 0, err
+		}
+	}
+}
+
+// reconnectDelay 首次重连优先采用关闭帧中的 reconnectAfterMs，其余按退避策略计算
+func reconnectDelay(closeErr error, p reconnectPolicy, attempt int) time.Duration {
+	var ce *websocket.CloseError
+	if attempt == 0 && errors.As(closeErr, &ce) {
+		var hint closeHint
+		if json.Unmarshal([]byte(ce.Text), &hint) == nil && hint.ReconnectAfterMs > 0 {
+			return time.Duration(hint.ReconnectAfterMs) * time.Millisecond
+		}
+	}
+	d := min(float64(p.MaxMs), float64(p.BaseMs)*float64(int(1)<<min(attempt, 16)))
+	d *= 1 - p.Jitter*rand.Float64()
+	return time.Duration(d) * time.Millisecond
+}
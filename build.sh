@@ -4,16 +4,16 @@
 mkdir -p dist
 
 # Windows
-GOOS=windows GOARCH=amd64 CGO_ENABLED=0 go build -o dist/go-chat.exe main.go
+GOOS=windows GOARCH=amd64 CGO_ENABLED=0 go build -o dist/go-chat.exe .
 
 # macOS Intel
-GOOS=darwin GOARCH=amd64 CGO_ENABLED=0 go build -o dist/go-chat-mac-intel main.go
+GOOS=darwin GOARCH=amd64 CGO_ENABLED=0 go build -o dist/go-chat-mac-intel .
 
 # macOS Apple Silicon
-GOOS=darwin GOARCH=arm64 CGO_ENABLED=0 go build -o dist/go-chat-mac-arm main.go
+GOOS=darwin GOARCH=arm64 CGO_ENABLED=0 go build -o dist/go-chat-mac-arm .
 
 # Linux
-GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o dist/go-chat-linux main.go
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o dist/go-chat-linux .
 
 # 复制资源
 # cp -r public dist/
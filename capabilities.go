@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// 服务端能力与限制，GET /api/capabilities，同一对象也随 init 下发。
+// 每次都由当前生效的配置现算，客户端据此提前拒绝，不会与服务端实际校验不一致
+
+type Capabilities struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Features        []string `json:"features"`
+	// 服务端接受的客户端消息类型，见 wstypes.go
+	MessageTypes     []messageTypeInfo `json:"messageTypes"`
+	MaxUploadSize    int64             `json:"maxUploadSize"`
+	AllowedTypes     []string          `json:"allowedTypes"`     // MIME 通配，目前不限类型
+	RequireExtension bool              `json:"requireExtension"` // 上传文件名必须带扩展名
+	MaxMessageLength int               `json:"maxMessageLength"` // 0 表示不限
+	MaxCommentLength int               `json:"maxCommentLength"`
+	UploadTokenAuth  bool              `json:"uploadTokenRequired"` // 上传必须携带 WebSocket 下发的令牌
+	Rooms            bool              `json:"rooms"`
+	ResumableUploads bool              `json:"resumableUploads"`
+	FileOffers       bool              `json:"fileOffers"`  // 定向发送文件握手，超出流量上限时为 false
+	DeleteGrace      int               `json:"deleteGrace"` // 删除自己的消息后可撤销的秒数，见 deletes.go
+	// 流量上限，未设置时省略；Remaining 仅在能识别身份时给出
+	BandwidthCap       int64  `json:"bandwidthCap,omitempty"`
+	BandwidthRemaining *int64 `json:"bandwidthRemaining,omitempty"`
+}
+
+// capabilitiesFor 生成某个用户视角下的能力描述，userID 为空表示匿名
+func capabilitiesFor(userID string) Capabilities {
+	c := Capabilities{
+		ProtocolVersion:  protocolVersion,
+		Features:         protocolFeatures(),
+		MessageTypes:     messageTypes(),
+		MaxUploadSize:    maxSize.Load(),
+		AllowedTypes:     []string{"*/*"},
+		RequireExtension: true,
+		MaxMessageLength: maxMessageLen,
+		MaxCommentLength: maxCommentLen,
+		UploadTokenAuth:  !*allowAnonymousUploads,
+		FileOffers:       !overBandwidthCap(userID),
+		DeleteGrace:      int(deleteGrace.Seconds()),
+	}
+	if perUserBandwidthCap.Load() > 0 {
+		c.BandwidthCap = perUserBandwidthCap.Load()
+		if userID != "" {
+			left := max(0, c.BandwidthCap-userBandwidthFor(userID).total())
+			c.BandwidthRemaining = &left
+		}
+	}
+	return c
+}
+
+// capabilitiesHandler GET /api/capabilities，带 X-Upload-Token 时包含该用户的剩余流量
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(capabilitiesFor(transferIdentity(r)))
+}
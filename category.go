@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 消息分类：每条广播带 category，各去向按分类过滤。
+// 在线 WebSocket 默认收到全部分类；最近消息缓冲（/api/activity 轮询的数据来源）默认只保留聊天。
+// 新增分类只需加常量并在 category() 中归类，新增去向只需在 destCategories 中登记
+
+const (
+	catChat       = "chat"
+	catPresence   = "presence" // 上下线、在线列表
+	catSystem     = "system"   // 表情更新等其他系统提示
+	catFile       = "file"     // 文件评论等文件事件
+	catModeration = "moderation"
+)
+
+var allCategories = []string{catChat, catPresence, catSystem, catFile, catModeration}
+
+// categorySet 逗号分隔的分类列表，all 表示全部，none 表示不要
+type categorySet map[string]bool
+
+func (s *categorySet) String() string {
+	if len(*s) == len(allCategories) {
+		return "all"
+	}
+	if len(*s) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(*s))
+	for c := range *s {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (s *categorySet) Set(value string) error {
+	set := make(categorySet)
+	for _, c := range strings.Split(value, ",") {
+		switch c = strings.TrimSpace(strings.ToLower(c)); c {
+		case "", "none":
+		case "all":
+			for _, a := range allCategories {
+				set[a] = true
+			}
+		default:
+			if !isCategory(c) {
+				return fmt.Errorf("unknown category %q (want %s, all or none)", c, strings.Join(allCategories, ", "))
+			}
+			set[c] = true
+		}
+	}
+	*s = set
+	return nil
+}
+
+func isCategory(c string) bool {
+	for _, a := range allCategories {
+		if a == c {
+			return true
+		}
+	}
+	return false
+}
+
+type destination int
+
+const (
+	destWebSocket destination = iota // 在线的 WebSocket 连接
+	destHistory                      // 最近消息缓冲，供 /api/activity 轮询
+)
+
+var (
+	wsCategories      = categorySet{catChat: true, catPresence: true, catSystem: true, catFile: true, catModeration: true}
+	historyCategories = categorySet{catChat: true}
+
+	destCategories = map[destination]*categorySet{
+		destWebSocket: &wsCategories,
+		destHistory:   &historyCategories,
+	}
+)
+
+func init() {
+	flag.Var(&wsCategories, "ws-categories", "推送给在线 WebSocket 客户端的消息分类（chat,presence,system,file,moderation 或 all/none）")
+	flag.Var(&historyCategories, "history-categories", "保留到最近消息（/api/activity）的消息分类，默认只有 chat")
+}
+
+// delivers 判断某分类的消息是否发往该去向
+func delivers(dest destination, category string) bool {
+	set := destCategories[dest]
+	return set != nil && (*set)[category]
+}
+
+// category 未显式标注时按消息类型归类
+func (m WSMessage) category() string {
+	if m.Category != "" {
+		return m.Category
+	}
+	switch m.Type {
+	case "message", "edit", "private":
+		if m.Data.From == "system" {
+			return catSystem
+		}
+		return catChat
+	case "users":
+		return catPresence
+	case "file_comment":
+		return catFile
+	}
+	return catSystem
+}
@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+// 默认：在线连接收到全部分类，最近消息只保留聊天；未登记的去向什么都不收
+func TestDelivers(t *testing.T) {
+	tests := []struct {
+		dest     destination
+		category string
+		want     bool
+	}{
+		{destWebSocket, catChat, true},
+		{destWebSocket, catPresence, true},
+		{destWebSocket, catSystem, true},
+		{destWebSocket, catFile, true},
+		{destWebSocket, catModeration, true},
+		{destHistory, catChat, true},
+		{destHistory, catPresence, false},
+		{destHistory, catSystem, false},
+		{destHistory, catFile, false},
+		{destHistory, catModeration, false},
+		{destination(99), catChat, false},
+	}
+	for _, tt := range tests {
+		if got := delivers(tt.dest, tt.category); got != tt.want {
+			t.Errorf("delivers(%d, %q) = %v, want %v", tt.dest, tt.category, got, tt.want)
+		}
+	}
+}
+
+// 经命令行配置的分类列表；解析到局部变量，不改动 hub 正在读取的全局设置
+func TestCategorySet(t *testing.T) {
+	tests := []struct {
+		flag     string
+		category string
+		want     bool
+	}{
+		{"chat,file", catFile, true},
+		{"chat,file", catPresence, false},
+		{"all", catModeration, true},
+		{"none", catChat, false},
+		{"chat", catPresence, false},
+		{" Chat , PRESENCE ", catPresence, true},
+	}
+	for _, tt := range tests {
+		var set categorySet
+		if err := set.Set(tt.flag); err != nil {
+			t.Fatalf("Set(%q): %v", tt.flag, err)
+		}
+		if got := set[tt.category]; got != tt.want {
+			t.Errorf("%q contains %q = %v, want %v", tt.flag, tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestCategorySetRejectsUnknown(t *testing.T) {
+	var s categorySet
+	if err := s.Set("chat,gossip"); err == nil {
+		t.Fatal("Set accepted an unknown category")
+	}
+}
+
+func TestMessageCategory(t *testing.T) {
+	chat := func(typ, from, explicit string) WSMessage {
+		m := WSMessage{Type: typ, Category: explicit}
+		m.Data.From = from
+		return m
+	}
+	tests := []struct {
+		msg  WSMessage
+		want string
+	}{
+		{chat("message", "alice", ""), catChat},
+		{chat("edit", "alice", ""), catChat},
+		{chat("private", "alice", ""), catChat},
+		{chat("message", "system", ""), catSystem},
+		{chat("users", "system", ""), catPresence},
+		{chat("file_comment", "alice", ""), catFile},
+		{chat("emoji", "system", ""), catSystem},
+		{chat("message", "system", catPresence), catPresence},
+		{chat("edit", "admin", catModeration), catModeration},
+	}
+	for _, tt := range tests {
+		if got := tt.msg.category(); got != tt.want {
+			t.Errorf("%s from %q (category %q) = %q, want %q", tt.msg.Type, tt.msg.Data.From, tt.msg.Category, got, tt.want)
+		}
+	}
+}
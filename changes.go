@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 变更日志：记录文件索引的增删，供镜像/同步客户端增量拉取
+var journalSize = flag.Int("journal-size", 1000, "文件变更日志保留条数（超出后旧游标需全量同步）")
+
+const (
+	ChangeAdd    = "add"
+	ChangeDelete = "delete"
+)
+
+type FileChange struct {
+	Seq  int64     `json:"seq"`
+	Op   string    `json:"op"`
+	Time time.Time `json:"time"`
+	File FileInfo  `json:"file"`
+}
+
+var (
+	journal   []FileChange
+	journalMu sync.Mutex
+	// 日志与序号随文件索引持久化，重启后由 restoreJournal 接着原序号递增，此前发出的游标照常可用。
+	// 只有没有可恢复的日志（首次启动或索引丢失）时才以启动时刻为基数，此前发出的游标都早于
+	// journalFloorSeq，判为过期而转入全量同步；索引回退到 .bak 时，客户端手里大于当前序号的游标同样判为过期
+	journalSeq = startTime.UnixMilli()
+	// 早于该序号/时间的变更已不在日志中
+	journalFloorSeq  = journalSeq
+	journalFloorTime = startTime
+)
+
+// journalState 随文件索引持久化，使游标在重启后依然有效
+type journalState struct {
+	Entries   []FileChange `json:"entries"`
+	Seq       int64        `json:"seq"`
+	FloorSeq  int64        `json:"floorSeq"`
+	FloorTime time.Time    `json:"floorTime"`
+}
+
+func snapshotJournal() journalState {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	return journalState{
+		Entries:   append([]FileChange(nil), journal...),
+		Seq:       journalSeq,
+		FloorSeq:  journalFloorSeq,
+		FloorTime: journalFloorTime,
+	}
+}
+
+func restoreJournal(st journalState) {
+	if st.Seq == 0 {
+		return
+	}
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	journal, journalSeq = st.Entries, st.Seq
+	journalFloorSeq, journalFloorTime = st.FloorSeq, st.FloorTime
+}
+
+// recordChange 追加一条变更，超出上限时丢弃最旧的记录
+func recordChange(op string, info FileInfo) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	journalSeq++
+	journal = append(journal, FileChange{Seq: journalSeq, Op: op, Time: time.Now(), File: info})
+	if over := len(journal) - *journalSize; over > 0 {
+		last := journal[over-1]
+		journalFloorSeq, journalFloorTime = last.Seq, last.Time
+		journal = append([]FileChange(nil), journal[over:]...)
+	}
+}
+
+// changesSince 返回游标之后的变更；ok 为 false 表示游标已过期，需要全量同步
+func changesSince(since string) (changes []FileChange, next int64, ok bool) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	next = journalSeq
+	// 省略 since 时只返回当前游标，供客户端全量同步后作为起点
+	if since == "" {
+		return nil, next, true
+	}
+	if seq, err := strconv.ParseInt(since, 10, 64); err == nil {
+		if seq < journalFloorSeq || seq > journalSeq {
+			return nil, next, false
+		}
+		for _, c := range journal {
+			if c.Seq > seq {
+				changes = append(changes, c)
+			}
+		}
+		return changes, next, true
+	}
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil || t.Before(journalFloorTime) {
+		return nil, next, false
+	}
+	for _, c := range journal {
+		if c.Time.After(t) {
+			changes = append(changes, c)
+		}
+	}
+	return changes, next, true
+}
+
+// fileChangesHandler GET /api/files/changes?since=<RFC3339 或 seq>
+func fileChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed(w, r)
+		return
+	}
+
+	changes, next, ok := changesSince(r.URL.Query().Get("since"))
+	if !ok {
+		writeError(w, r, http.StatusGone, "cursor_expired", "Cursor too old or invalid, do a full sync via /api/files/all", map[string]interface{}{"fullSync": "/api/files/all"})
+		return
+	}
+	if changes == nil {
+		changes = []FileChange{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changes":    changes,
+		"nextCursor": strconv.FormatInt(next, 10),
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// 启动自检：校验所有配置项引用的路径、地址与凭据。
+// -check-config 只输出检查表并以退出码表示结果，不监听端口；正常启动时致命项直接退出，其余仅告警
+
+var checkConfig = flag.Bool("check-config", false, "只校验配置并输出检查结果，不启动服务；有失败项时退出码非 0")
+
+type checkResult struct {
+	name   string
+	ok     bool
+	fatal  bool // 失败时无法正常服务
+	detail string
+}
+
+// runChecks 执行全部检查；会创建上传目录并做一次试写
+func runChecks() []checkResult {
+	var results []checkResult
+	add := func(name string, fatal bool, err error, okDetail string) {
+		r := checkResult{name: name, ok: err == nil, fatal: fatal, detail: okDetail}
+		if err != nil {
+			r.detail = err.Error()
+		}
+		results = append(results, r)
+	}
+
+	if *port <= 0 || *port > 65535 {
+		add("端口", true, fmt.Errorf("invalid -port %d", *port), "")
+	} else {
+		add("端口", true, nil, fmt.Sprint(*port))
+	}
+	add("上传目录", true, checkWritableDir(*uploadDir), *uploadDir+" 可写")
+	add("文件索引", false, checkIndexFile(), "可读取")
+	add("对外地址", true, initPublicURL(), "-public-url / -trusted-proxies 有效")
+	if validFilenameStrategy(*filenameStrategy) {
+		add("文件命名", true, nil, *filenameStrategy)
+	} else {
+		add("文件命名", true, fmt.Errorf("unknown -filename-strategy %q", *filenameStrategy), "")
+	}
+	if err := validateBotName(*assistantName); err != nil {
+		add("助手名称", true, fmt.Errorf("invalid -assistant-name %q: %v", *assistantName, err), "")
+	} else {
+		add("助手名称", true, nil, *assistantName)
+	}
+	if *bandwidthResetHour < 0 || *bandwidthResetHour > 23 {
+		add("流量清零时刻", true, fmt.Errorf("-bandwidth-reset-hour must be 0-23"), "")
+	}
+	if !validSlowClientPolicy(*slowClientPolicy) {
+		add("慢连接处理", true, fmt.Errorf("unknown -slow-client-policy %q (want drop or disconnect)", *slowClientPolicy), "")
+	}
+	if maxClients.Load() < 0 || maxConnsPerIP.Load() < 0 {
+		add("连接数上限", true, fmt.Errorf("-max-clients and -max-conns-per-ip must not be negative"), "")
+	}
+	if *maxConnsPerUser < 1 {
+		add("每用户连接数", true, fmt.Errorf("-max-conns-per-user must be at least 1"), "")
+	}
+	if *replayBufferSize < 0 || *replayBufferSize > maxReplayBuffer {
+		add("补发缓冲", true, fmt.Errorf("-replay-buffer must be between 0 and %d", maxReplayBuffer), "")
+	}
+	if *compressionMaxRatio < 0 || *compressionMaxRTT < 0 {
+		add("WebSocket 压缩", true, fmt.Errorf("-compression-max-ratio and -compression-max-rtt must not be negative"), "")
+	}
+	if *defaultProto < 1 || *defaultProto > protocolVersion {
+		add("协议版本", true, fmt.Errorf("-default-proto must be between 1 and %d", protocolVersion), "")
+	}
+	if *userIDLength < minUserIDLength || *userIDLength > maxUserIDLength {
+		add("用户 ID", true, fmt.Errorf("-user-id-length must be between %d and %d", minUserIDLength, maxUserIDLength), "")
+	}
+	if *wsRate < 0 || *wsRateGrace < 0 || (*wsRate > 0 && *wsBurst < 1) {
+		add("入站限速", true, fmt.Errorf("-ws-rate and -ws-rate-grace must not be negative, -ws-burst must be at least 1"), "")
+	}
+	if *fanoutWorkers < 0 {
+		add("广播分发", true, fmt.Errorf("-fanout-workers must not be negative"), "")
+	}
+	if *usersDebounce < 0 {
+		add("用户列表广播", true, fmt.Errorf("-users-debounce must not be negative"), "")
+	}
+	if *idleTimeout < 0 {
+		add("空闲断开", true, fmt.Errorf("-idle-timeout must not be negative"), "")
+	}
+	if *receiptTTL <= 0 {
+		add("送达回执", true, fmt.Errorf("-receipt-ttl must be positive"), "")
+	}
+	if *deleteGrace < 0 {
+		add("消息撤销", true, fmt.Errorf("-delete-grace must not be negative"), "")
+	}
+	if *awayAfter < 0 {
+		add("离开状态", true, fmt.Errorf("-away-after must not be negative"), "")
+	}
+	if err := checkKeepalive(); err != nil {
+		add("连接参数", true, err, "")
+	}
+	if *otelSampleRatio < 0 || *otelSampleRatio > 1 {
+		add("追踪采样比例", false, fmt.Errorf("-otel-sample-ratio must be between 0 and 1"), "")
+	}
+	if *statsAdminOnly && *adminToken == "" {
+		add("统计接口", false, fmt.Errorf("-stats-admin-only is set but -admin-token is empty, nobody can read /api/stats"), "")
+	}
+	if *adminToken != "" && len(*adminToken) < 12 {
+		add("管理员令牌", false, fmt.Errorf("-admin-token is shorter than 12 characters"), "")
+	}
+
+	// 外部服务地址：能解析、主机名可解析即可，不实际发请求
+	for _, ep := range []struct{ name, flag, value string }{
+		{"消息转换服务", "-transform-url", *transformURL},
+		{"助手接口", "-assistant-endpoint", *assistantEndpoint},
+		{"追踪采集端", "-otel-endpoint", *otelEndpoint},
+	} {
+		if ep.value != "" {
+			add(ep.name, false, checkEndpoint(ep.flag, ep.value), ep.value)
+		}
+	}
+	if used, err := checkProxy(); err != nil || used != "" {
+		add("出站代理", false, err, used+" 可连接")
+	}
+	if *assistantEndpoint != "" && *assistantModel == "" {
+		add("助手模型", false, fmt.Errorf("-assistant-endpoint is set but -assistant-model is empty"), "")
+	}
+	return results
+}
+
+// checkWritableDir 创建目录并试写一个临时文件
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".gochat-check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = f.WriteString("ok")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	os.Remove(name)
+	return err
+}
+
+// checkIndexFile 索引不存在视为正常（首次启动），存在则必须能解析
+func checkIndexFile() error {
+	data, err := os.ReadFile(indexPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var idx indexData
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return fmt.Errorf("corrupt index (the .bak generation will be used): %v", err)
+	}
+	return nil
+}
+
+// checkEndpoint 校验 http(s) 地址并解析主机名（经代理访问的只校验格式）
+func checkEndpoint(flagName, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s %q", flagName, raw)
+	}
+	// 走代理时由代理解析主机名，本机可能无法解析
+	if p, _ := proxyConfig().ProxyFunc()(u); p != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+		return fmt.Errorf("cannot resolve %s: %v", u.Hostname(), err)
+	}
+	return nil
+}
+
+// printChecks 输出检查表，返回是否全部通过
+func printChecks(results []checkResult) bool {
+	pass := true
+	for _, r := range results {
+		mark := "✅"
+		if !r.ok {
+			pass = false
+			mark = "⚠️ "
+			if r.fatal {
+				mark = "❌"
+			}
+		}
+		// 中文按两个字符宽对齐
+		pad := 14
+		for _, c := range r.name {
+			pad--
+			if c > 0x2e80 {
+				pad--
+			}
+		}
+		fmt.Printf("  %s %s%*s%s\n", mark, r.name, max(pad, 1), "", r.detail)
+	}
+	return pass
+}
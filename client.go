@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 命令行客户端（sync / watch）共用的 HTTP 调用
+
+type uploadResult struct {
+	FileURL   string `json:"fileUrl"`
+	ShareURL  string `json:"shareUrl"`
+	Code      string `json:"code"`
+	FileName  string `json:"fileName"`
+	FileSize  int64  `json:"fileSize"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+var (
+	serverCaps   = make(map[string]*Capabilities) // server -> 能力描述，进程内只取一次
+	serverCapsMu sync.Mutex
+)
+
+// fetchCapabilities 取服务端限制；旧版服务端没有该接口时返回 nil，不做本地预检
+func fetchCapabilities(server string) *Capabilities {
+	serverCapsMu.Lock()
+	defer serverCapsMu.Unlock()
+	if c, ok := serverCaps[server]; ok {
+		return c
+	}
+	var c *Capabilities
+	if resp, err := outboundClient.Get(server + "/api/capabilities"); err == nil {
+		if resp.StatusCode == http.StatusOK {
+			c = new(Capabilities)
+			if json.NewDecoder(resp.Body).Decode(c) != nil {
+				c = nil
+			}
+		}
+		resp.Body.Close()
+		serverCaps[server] = c
+	}
+	return c
+}
+
+// uploadFile 以 multipart 流式上传，不把整个文件读入内存
+func uploadFile(server, path string) (uploadResult, error) {
+	var res uploadResult
+	f, err := os.Open(path)
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+
+	// 超出服务端限制的文件直接失败，不必先传完
+	if caps := fetchCapabilities(server); caps != nil {
+		if st, err := f.Stat(); err == nil && caps.MaxUploadSize > 0 && st.Size() > caps.MaxUploadSize {
+			return res, fmt.Errorf("%s: file too large (%d bytes, server max %d)", filepath.Base(path), st.Size(), caps.MaxUploadSize)
+		}
+		if caps.RequireExtension && filepath.Ext(path) == "" {
+			return res, fmt.Errorf("%s: server requires a file extension", filepath.Base(path))
+		}
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	resp, err := outboundClient.Post(server+"/upload", mw.FormDataContentType(), pr)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	warnLowQuota(resp.Header)
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return res, err
+	}
+	return res, json.NewDecoder(resp.Body).Decode(&res)
+}
+
+// 剩余流量低于上限的该比例时提示
+const quotaWarnRatio = 0.1
+
+// warnLowQuota 根据上传响应的 X-Quota-* 头提示即将用完的流量配额
+func warnLowQuota(h http.Header) {
+	limit, err1 := strconv.ParseInt(h.Get("X-Quota-Limit"), 10, 64)
+	remaining, err2 := strconv.ParseInt(h.Get("X-Quota-Remaining"), 10, 64)
+	if err1 != nil || err2 != nil || limit <= 0 || float64(remaining) >= float64(limit)*quotaWarnRatio {
+		return
+	}
+	reset := "未知"
+	if ts, err := strconv.ParseInt(h.Get("X-Quota-Reset"), 10, 64); err == nil {
+		reset = time.Unix(ts, 0).Format("2006-01-02 15:04")
+	}
+	fmt.Fprintf(os.Stderr, "⚠️  今日流量配额仅剩 %s / %s，%s 重置\n", humanSize(remaining), humanSize(limit), reset)
+}
+
+// postMessage 通过 /send 以指定身份发一条群聊消息
+func postMessage(server, from, text string) error {
+	body, _ := json.Marshal(map[string]string{"message": text, "from": from})
+	resp, err := outboundClient.Post(server+"/send", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, http.StatusOK)
+}
+
+func checkStatus(resp *http.Response, want int) error {
+	if resp.StatusCode == want {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	var e struct {
+		Error apiErrorBody `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error.Message != "" {
+		return fmt.Errorf("%s: %s", resp.Status, e.Error.Message)
+	}
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 服务端主动断开连接时的关闭码，统一使用 4000–4999 私有区间，reason 为下面固定的英文短语
+// （停止服务时例外，见 closeHint）。网页端的 CLOSE 常量（public/index.html）与这里一一对应，修改时两处同步
+const (
+	closeSuperseded       = 4001 // 同一身份在别处重新连接，旧连接被接管；不要自动重连
+	closeIdle             = 4002 // 超过 -idle-timeout 没有消息；等用户操作后再重连
+	closeServerFull       = 4003 // 已达 -max-clients
+	closeShuttingDown     = 4004 // 服务端停止或升级，reason 为 JSON，含错峰的 reconnectAfterMs
+	closeSlowConsumer     = 4005 // 接收过慢，发送队列已满
+	closeMessageTooLarge  = 4006 // 单条消息超过 -ws-read-limit
+	closeTooManyConns     = 4007 // 同一 IP 的连接超过 -max-conns-per-ip
+	closeHeartbeatTimeout = 4008 // 超过 -pong-timeout 没有收到 pong
+	closeNameTaken        = 4009 // ?name= 声明的名字已被在线用户占用
+	closeInvalidName      = 4010 // ?name= 声明的名字不合法或为保留名
+	closeNameForbidden    = 4011 // 设置了 -name-token，但没有携带或不匹配
+	closeRateLimited      = 4012 // 持续超过 -ws-rate 达 -ws-rate-grace；4008 已用于心跳超时，不能复用
+)
+
+var closeReasons = map[int]string{
+	closeSuperseded:       "superseded",
+	closeIdle:             "idle timeout",
+	closeServerFull:       "server full",
+	closeShuttingDown:     "shutting down",
+	closeSlowConsumer:     "slow consumer",
+	closeMessageTooLarge:  "message too large",
+	closeTooManyConns:     "too many connections",
+	closeHeartbeatTimeout: "heartbeat timeout",
+	closeNameTaken:        "name taken",
+	closeInvalidName:      "invalid name",
+	closeNameForbidden:    "name claim not allowed",
+	closeRateLimited:      "rate limited",
+}
+
+// closeFrame 按关闭码生成关闭帧
+func closeFrame(code int) []byte {
+	return websocket.FormatCloseMessage(code, closeReasons[code])
+}
+
+// closeNow 不经发送队列立即写出关闭帧（最多等待 1 秒）并关闭连接，用于对方可能已不读取的情况
+func closeNow(conn *websocket.Conn, code int) {
+	conn.WriteControl(websocket.CloseMessage, closeFrame(code), time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// rejectWS 连接数超限时：WebSocket 握手先完成升级再以关闭码拒绝（浏览器拿不到握手失败的 HTTP 状态），
+// 其余请求仍返回 HTTP 错误
+func rejectWS(w http.ResponseWriter, r *http.Request, code int, httpError func(http.ResponseWriter, *http.Request)) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		httpError(w, r)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	closeNow(conn, code)
+}
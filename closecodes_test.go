@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 接管（4001）与消息过大（4006）分别见 takeover_test.go 与 wstypes_test.go
+
+// setFlag 在测试期间修改配置；须在 newTestServer 之前调用，服务端协程才能看到新值
+func setFlag[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// dialClosed 连接后读到关闭帧为止，返回关闭码与原因
+func dialClosed(t *testing.T, srv *httptest.Server, query string) (int, string) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(srv, query), nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", query, err)
+	}
+	defer conn.Close()
+	return (&testConn{t: t, conn: conn}).closeOf()
+}
+
+func assertClose(t *testing.T, code int, reason string, want int) {
+	t.Helper()
+	if code != want || reason != closeReasons[want] {
+		t.Fatalf("closed with %d %q, want %d %q", code, reason, want, closeReasons[want])
+	}
+}
+
+func TestCloseIdle(t *testing.T) {
+	setFlag(t, idleTimeout, time.Millisecond)
+	tc := dialWS(t, newTestServer(t, nil), "uid=idler")
+	time.Sleep(10 * time.Millisecond)
+	closeIdleConns()
+	code, reason := tc.closeOf()
+	assertClose(t, code, reason, closeIdle)
+}
+
+func TestCloseServerFull(t *testing.T) {
+	maxClients.Store(1)
+	defer maxClients.Store(0)
+	srv := newTestServer(t, nil)
+	dialWS(t, srv, "uid=first")
+	code, reason := dialClosed(t, srv, "uid=second")
+	assertClose(t, code, reason, closeServerFull)
+}
+
+func TestCloseShuttingDown(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=bye")
+	closeAllClients("restarting")
+	code, reason := tc.closeOf()
+	var hint closeHint
+	if code != closeShuttingDown || json.Unmarshal([]byte(reason), &hint) != nil || hint.Reason != "restarting" {
+		t.Fatalf("closed with %d %q, want %d with a JSON hint", code, reason, closeShuttingDown)
+	}
+}
+
+func TestCloseSlowConsumer(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=slow")
+	clients.ByID("slow")[0].disconnectSlow()
+	code, reason := tc.closeOf()
+	assertClose(t, code, reason, closeSlowConsumer)
+}
+
+func TestCloseTooManyConns(t *testing.T) {
+	maxConnsPerIP.Store(1)
+	defer maxConnsPerIP.Store(0)
+	srv := newTestServer(t, nil)
+	dialWS(t, srv, "uid=first")
+	code, reason := dialClosed(t, srv, "uid=second")
+	assertClose(t, code, reason, closeTooManyConns)
+}
+
+func TestCloseHeartbeatTimeout(t *testing.T) {
+	setFlag(t, pingInterval, 50*time.Millisecond)
+	setFlag(t, pongTimeout, 200*time.Millisecond)
+	srv := newTestServer(t, nil)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(srv, "uid=silent"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetPingHandler(func(string) error { return nil }) // 不回 pong
+	tc := newTestConn(t, conn)
+	code, reason := tc.closeOf()
+	assertClose(t, code, reason, closeHeartbeatTimeout)
+}
+
+func TestCloseNameTaken(t *testing.T) {
+	srv := newTestServer(t, nil)
+	dialWS(t, srv, "name=claimed")
+	code, reason := dialClosed(t, srv, "name=claimed")
+	assertClose(t, code, reason, closeNameTaken)
+}
+
+func TestCloseInvalidName(t *testing.T) {
+	code, reason := dialClosed(t, newTestServer(t, nil), "name=system")
+	assertClose(t, code, reason, closeInvalidName)
+}
+
+func TestCloseNameForbidden(t *testing.T) {
+	setFlag(t, nameToken, "secret")
+	srv := newTestServer(t, nil)
+	code, reason := dialClosed(t, srv, "name=pager&token=wrong")
+	assertClose(t, code, reason, closeNameForbidden)
+	if tc := dialWS(t, srv, "name=pager&token=secret"); tc.userID() != "pager" {
+		t.Fatalf("userId with the right token = %q, want pager", tc.userID())
+	}
+}
+
+func TestCloseRateLimited(t *testing.T) {
+	setFlag(t, wsRateGrace, 0)
+	tc := dialWS(t, newTestServer(t, nil), "uid=flood")
+	for i := 0; i <= *wsBurst; i++ {
+		tc.sendRaw(`{"type":"typing"}`)
+	}
+	code, reason := tc.closeOf()
+	assertClose(t, code, reason, closeRateLimited)
+}
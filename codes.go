@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 短码：便于口头报给别人的文件提取码，访问 /f/{code} 跳转到分享页
+
+// 去掉易混淆的 0/O、1/I/L，且不区分大小写
+const codeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+var codeCooldown = flag.Duration("code-cooldown", 7*24*time.Hour, "文件删除后其短码需冷却多久才能重新分配")
+
+var (
+	// 以下两个表与 fileList 一起由 filesMu 保护
+	codeToFile   = make(map[string]string)    // code -> savedName
+	retiredCodes = make(map[string]time.Time) // 已删除文件的短码 -> 删除时间
+)
+
+func randomCode(n int) string {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(codeAlphabet)))
+	for i := range b {
+		v, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = codeAlphabet[v.Int64()]
+	}
+	return string(b)
+}
+
+// assignCode 为文件分配一个未使用、且不在冷却期的短码，调用方需持有 filesMu
+func assignCode(savedName string) string {
+	now := time.Now()
+	for attempt := 0; ; attempt++ {
+		// 连续冲突说明 5 位空间偏满，改用 6 位
+		n := 5
+		if attempt >= 5 {
+			n = 6
+		}
+		code := randomCode(n)
+		if _, used := codeToFile[code]; used {
+			continue
+		}
+		if t, ok := retiredCodes[code]; ok {
+			if now.Sub(t) < *codeCooldown {
+				continue
+			}
+			delete(retiredCodes, code)
+		}
+		codeToFile[code] = savedName
+		return code
+	}
+}
+
+// retireCode 文件删除时回收短码进入冷却期，调用方需持有 filesMu
+func retireCode(code string) {
+	if code == "" {
+		return
+	}
+	delete(codeToFile, code)
+	retiredCodes[code] = time.Now()
+
+	// 顺带清理冷却期已过的记录，避免无限增长
+	for c, t := range retiredCodes {
+		if time.Since(t) >= *codeCooldown {
+			delete(retiredCodes, c)
+		}
+	}
+}
+
+// shortCodeHandler GET /f/{code}
+func shortCodeHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(strings.TrimPrefix(r.URL.Path, "/f/"))
+
+	filesMu.RLock()
+	savedName, ok := codeToFile[code]
+	filesMu.RUnlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "code_not_found", "Code not found", map[string]interface{}{"code": code})
+		return
+	}
+	http.Redirect(w, r, "/share/"+savedName, http.StatusFound)
+}
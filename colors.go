@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// 用户名颜色：由服务端为每个身份分配，所有人看到的颜色一致。
+// 初始颜色由 userId 哈希决定，与当前在线（含断线保留期内）的用户撞色时顺延到调色板中下一个空闲颜色，
+// 调色板用尽时接受撞色。分配结果按 userId 保存，携带相同 uid 或 resume 令牌重连后颜色不变；
+// 用户可通过 {"type":"color"} 在调色板内自选颜色
+
+// namePalette 白色背景上对比度不低于 4.5:1（WCAG AA）的颜色
+var namePalette = []string{
+	"#B91C1C", "#C2410C", "#A16207", "#4D7C0F", "#15803D", "#047857",
+	"#0F766E", "#0E7490", "#1D4ED8", "#4338CA", "#7E22CE", "#BE185D",
+}
+
+const colorRetention = 30 * 24 * time.Hour // 身份不再出现后保留其颜色的时长
+
+var userColors = newExpiringMap[string, string]("colors", colorRetention, registryMaxEntries) // userId -> 颜色
+
+// paletteIndex userId 对应的初始颜色，不区分大小写
+func paletteIndex(userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(userID)))
+	return int(h.Sum32() % uint32(len(namePalette)))
+}
+
+// assignColor 为刚注册的连接确定颜色，调用方为 hub 协程且持有 identityMu
+func assignColor(userID string) string {
+	if color, ok := userColors.Get(userID); ok {
+		userColors.Set(userID, color) // 续期
+		return color
+	}
+	taken := make(map[string]bool)
+	for _, u := range clients.Snapshot() {
+		if u.ID != userID {
+			taken[colorOf(u.ID)] = true
+		}
+	}
+	for id := range lingering {
+		taken[colorOf(id)] = true
+	}
+	start := paletteIndex(userID)
+	color := namePalette[start]
+	for i := range namePalette {
+		if candidate := namePalette[(start+i)%len(namePalette)]; !taken[candidate] {
+			color = candidate
+			break
+		}
+	}
+	userColors.Set(userID, color)
+	return color
+}
+
+// colorOf 消息与用户列表中显示的颜色；未在线过的发送者（如经 /send 的机器人）按哈希取色，不做登记
+func colorOf(userID string) string {
+	if userID == "" || userID == "system" {
+		return ""
+	}
+	if color, ok := userColors.Get(userID); ok {
+		return color
+	}
+	return namePalette[paletteIndex(userID)]
+}
+
+func validColor(color string) (string, bool) {
+	for _, c := range namePalette {
+		if strings.EqualFold(c, color) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+func init() {
+	registerWSType(wsType{name: "color", rate: rateControl, minProto: 2, feature: "color", handle: func(f *wsFrame) { handleColor(f.c.userID, f.raw) }})
+}
+
+// handleColor 处理 {"type":"color","data":{"color":"#1D4ED8"}}，color 为空表示恢复自动分配
+func handleColor(userID string, raw json.RawMessage) {
+	var req struct {
+		Color string `json:"color"`
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		forwardSignal(userID, map[string]interface{}{"type": "color_error", "data": map[string]interface{}{"error": "invalid color payload"}})
+		return
+	}
+	color := ""
+	if req.Color != "" {
+		var ok bool
+		if color, ok = validColor(req.Color); !ok {
+			forwardSignal(userID, map[string]interface{}{"type": "color_error", "data": map[string]interface{}{
+				"error":   "color must be one of the palette",
+				"palette": namePalette,
+			}})
+			return
+		}
+	}
+
+	identityMu.Lock()
+	if color == "" {
+		userColors.Delete(userID)
+		color = assignColor(userID)
+	} else {
+		userColors.Set(userID, color)
+	}
+	identityMu.Unlock()
+
+	forwardSignal(userID, map[string]interface{}{"type": "color", "data": map[string]string{"color": color}})
+	broadcastUsers()
+}
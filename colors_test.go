@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 在线人数超过调色板大小：前 12 人颜色各不相同，之后的人接受撞色但仍取调色板中的颜色
+func TestColorPaletteExhaustion(t *testing.T) {
+	srv := newTestServer(t, nil)
+	seen := make(map[string]string)
+	for i := range len(namePalette) + 1 {
+		id := fmt.Sprintf("pal%02d", i)
+		userColors.Delete(id)
+		tc := dialWS(t, srv, "uid="+id)
+		color, _ := tc.init["color"].(string)
+		if _, ok := validColor(color); !ok {
+			t.Fatalf("%s got %q, not in the palette", id, color)
+		}
+		if i < len(namePalette) {
+			if other, dup := seen[color]; dup {
+				t.Fatalf("%s got %s, already taken by %s with the palette not exhausted", id, color, other)
+			}
+			seen[color] = id
+		}
+	}
+}
+
+// 自选的颜色在携带 resume 令牌重连后不变
+func TestColorSurvivesResume(t *testing.T) {
+	setFlag(t, resumeGrace, 500*time.Millisecond)
+	srv := newTestServer(t, nil)
+	userColors.Delete("tint")
+	first := dialWS(t, srv, "uid=tint")
+	chosen := namePalette[(paletteIndex("tint")+5)%len(namePalette)]
+	first.sendJSON(map[string]interface{}{"type": "color", "data": map[string]string{"color": chosen}})
+	if got := first.expect("color")["data"].(map[string]interface{})["color"]; got != chosen {
+		t.Fatalf("color reply %v, want %s", got, chosen)
+	}
+	token, _ := first.init["resumeToken"].(string)
+	first.conn.Close()
+	waitFor(t, "tint to enter the grace period", func() bool {
+		identityMu.Lock()
+		defer identityMu.Unlock()
+		return lingering["tint"] != nil
+	})
+
+	second := dialWS(t, srv, "uid=tint&resume="+token)
+	if second.init["resumed"] != true || second.init["color"] != chosen {
+		t.Fatalf("resumed %v with color %v, want %s", second.init["resumed"], second.init["color"], chosen)
+	}
+
+	// 等保留期结束再返回，以免离线广播落到后面的测试里
+	second.conn.Close()
+	waitFor(t, "tint's grace period to end", func() bool {
+		identityMu.Lock()
+		defer identityMu.Unlock()
+		return lingering["tint"] == nil && len(clients.ByID("tint")) == 0
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 文件评论：针对单个文件的简短讨论，随文件索引持久化，文件删除时一并清除
+
+const maxCommentLen = 500 // 单条评论最多字符数
+
+type FileComment struct {
+	ID     string    `json:"id"`
+	Author string    `json:"author"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// savedName -> 评论列表（按时间先后），与 fileList 一起由 filesMu 保护
+var fileComments = make(map[string][]FileComment)
+
+// fileCommentsHandler GET/POST /api/files/{savedName}/comments
+func fileCommentsHandler(w http.ResponseWriter, r *http.Request, savedName string) {
+	filesMu.RLock()
+	info, exists := fileList[savedName]
+	comments := append([]FileComment{}, fileComments[savedName]...)
+	filesMu.RUnlock()
+	if !exists {
+		errFileNotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(comments)
+	case http.MethodPost:
+		// 作者身份与上传相同：带上传令牌时以令牌对应用户为准
+		owner, ok := requestOwner(w, r)
+		if !ok {
+			return
+		}
+		var req struct {
+			From string `json:"from"`
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errInvalidJSON(w, r)
+			return
+		}
+		if owner != "" {
+			req.From = owner
+		}
+		req.Text = strings.TrimSpace(req.Text)
+		if req.Text == "" || req.From == "" {
+			errMissingFields(w, r, "text", "from")
+			return
+		}
+		if utf8.RuneCountInString(req.Text) > maxCommentLen {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "comment_too_long", "Comment too long", map[string]interface{}{"maxLength": maxCommentLen})
+			return
+		}
+
+		c := FileComment{ID: newMessageID(), Author: req.From, Text: req.Text, Time: time.Now()}
+		filesMu.Lock()
+		if _, ok := fileList[savedName]; !ok {
+			// 评论期间文件被删除
+			filesMu.Unlock()
+			errFileNotFound(w, r)
+			return
+		}
+		fileComments[savedName] = append(fileComments[savedName], c)
+		count := len(fileComments[savedName])
+		filesMu.Unlock()
+		saveIndex()
+
+		data, _ := json.Marshal(map[string]interface{}{
+			"file":    savedName,
+			"name":    info.Name,
+			"count":   count,
+			"comment": c,
+		})
+		broadcast(WSMessage{Type: "file_comment", Data: Message{ID: c.ID, Text: string(data), From: c.Author, Time: c.Time.Format("15:04:05"), At: c.Time.UnixMilli()}})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	default:
+		errMethodNotAllowed(w, r)
+	}
+}
+
+// fileCommentText file_comment 事件的纯文本版本
+func fileCommentText(msg WSMessage, _ *locale) (WSMessage, bool) {
+	if msg.Type != "file_comment" {
+		return msg, false
+	}
+	var ev struct {
+		Name    string      `json:"name"`
+		Comment FileComment `json:"comment"`
+	}
+	if json.Unmarshal([]byte(msg.Data.Text), &ev) != nil {
+		return msg, false
+	}
+	msg.Type = "message"
+	msg.Data.Text = fmt.Sprintf("💬 %s commented on %s: %s", ev.Comment.Author, ev.Name, ev.Comment.Text)
+	return msg, true
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCommentText(t *testing.T) {
+	msg := WSMessage{Type: "file_comment", Data: Message{Text: `{"file":"a.txt","name":"a.txt","count":1,"comment":{"author":"alice","text":"nice"}}`}}
+	got, ok := fileCommentText(msg, locales[0])
+	if !ok || got.Type != "message" || got.Data.Text != "💬 alice commented on a.txt: nice" {
+		t.Fatalf("got %+v, %v", got, ok)
+	}
+	for _, m := range []WSMessage{
+		{Type: "message", Data: Message{Text: msg.Data.Text}},
+		{Type: "file_comment", Data: Message{Text: "not json"}},
+	} {
+		if _, ok := fileCommentText(m, locales[0]); ok {
+			t.Errorf("%+v rendered as a comment", m)
+		}
+	}
+}
+
+// 未声明 file_comment 的连接与 v1 连接都改收一条普通消息，其余连接收到 file_comment 事件
+func TestFileCommentFallback(t *testing.T) {
+	srv := newTestServer(t, map[string]http.HandlerFunc{"/api/files/": fileItemHandler})
+	web := dialWS(t, srv, "uid=web")
+	irc := dialWS(t, srv, "uid=irc&caps=file_card")
+	old := dialWS(t, srv, "uid=old&proto=1")
+
+	filesMu.Lock()
+	fileList["comment-test.txt"] = FileInfo{Name: "comment-test.txt", SavedName: "comment-test.txt", Uploaded: time.Now()}
+	filesMu.Unlock()
+	t.Cleanup(func() {
+		filesMu.Lock()
+		delete(fileList, "comment-test.txt")
+		delete(fileComments, "comment-test.txt")
+		filesMu.Unlock()
+	})
+
+	resp, err := http.Post(srv.URL+"/api/files/comment-test.txt/comments", "application/json", strings.NewReader(`{"from":"web","text":"nice"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("post comment: %d", resp.StatusCode)
+	}
+
+	want := "💬 web commented on comment-test.txt: nice"
+	for _, tc := range []*testConn{irc, old} {
+		if got := chatText(tc.expectWhere("message", func(m map[string]interface{}) bool { return strings.HasPrefix(chatText(m), "💬") })); got != want {
+			t.Fatalf("%s got %q, want %q", tc.userID(), got, want)
+		}
+	}
+	web.expect("file_comment")
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"compress/flate"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket 压缩：客户端握手时提供 permessage-deflate 扩展即启用，按 -ws-compression 选择压缩级别。
+// 压缩在 writePump 中随写帧进行，每个连接的压缩状态只由其写协程使用
+
+// compressionMode 实现 flag.Value：off / default / best-speed
+type compressionMode string
+
+var wsCompression = compressionMode("default")
+
+func init() {
+	flag.Var(&wsCompression, "ws-compression", "WebSocket permessage-deflate 压缩级别：off（不协商）、default、best-speed")
+}
+
+func (m *compressionMode) String() string { return string(*m) }
+
+func (m *compressionMode) Set(v string) error {
+	switch v {
+	case "off", "default", "best-speed":
+		*m = compressionMode(v)
+		return nil
+	}
+	return fmt.Errorf("unknown compression mode %q (off / default / best-speed)", v)
+}
+
+func (m compressionMode) level() int {
+	if m == "best-speed" {
+		return flate.BestSpeed
+	}
+	return flate.DefaultCompression
+}
+
+// configureCompression 在开始接收连接前调用
+func configureCompression() {
+	upgrader.EnableCompression = wsCompression != "off"
+}
+
+// applyCompression 握手后设置压缩级别；未协商出压缩扩展的连接不受影响
+func applyCompression(conn *websocket.Conn) {
+	if wsCompression != "off" {
+		conn.SetCompressionLevel(wsCompression.level())
+	}
+}
+
+// 自适应关闭：permessage-deflate 只能在握手时协商，之后无法重新协商，但每条消息可单独决定是否压缩。
+// 写协程统计每个连接压缩发送的原始字节、实际写出的字节与写帧耗时，以下两种情况对该连接后续的消息停止压缩：
+// 已压缩发送的数据足够多且压缩后体积仍超过原始的 -compression-max-ratio（多为 base64 图片等已压缩内容）；
+// 心跳往返超过 -compression-max-rtt（浏览器按顺序处理帧，解压跟不上时 pong 明显变慢，常见于低端手机）
+
+var (
+	compressionMaxRatio = flag.Float64("compression-max-ratio", 0.9, "连接压缩后/压缩前的字节比超过该值即停止压缩其后续消息；0 表示不按压缩率关闭")
+	compressionMaxRTT   = flag.Duration("compression-max-rtt", time.Second, "连接的心跳往返超过该时长即停止压缩其后续消息；0 表示不按往返时间关闭")
+)
+
+// compressionSample 按压缩率判断的窗口：每压缩发送这么多原始字节判断一次最近的压缩率
+const compressionSample = 64 << 10
+
+// compressionState 单个连接的压缩统计；计数由写协程更新，管理接口并发读取
+type compressionState struct {
+	negotiated bool          // 握手时协商出了 permessage-deflate
+	counter    *countingConn // 底层连接的写出计数，取不到时不统计压缩率
+	off        atomic.Bool   // 已自动停止压缩
+	reason     atomic.Value  // string，停止压缩的原因
+	plain      atomic.Int64  // 压缩发送的消息原始字节
+	wire       atomic.Int64  // 上述消息实际写出的字节（含帧头）
+	nanos      atomic.Int64  // 写上述消息的耗时（含压缩）
+	rtt        atomic.Int64  // 最近一次心跳往返，纳秒
+	// 当前判断窗口内的原始与写出字节，每满 compressionSample 判断一次后清零，只由写协程访问
+	winPlain, winWire int64
+}
+
+// CompressionInfo 管理接口中的连接压缩统计
+type CompressionInfo struct {
+	Negotiated     bool    `json:"negotiated"`
+	Active         bool    `json:"active"`
+	Ratio          float64 `json:"ratio,omitempty"` // 压缩后/压缩前，越小越好
+	CompressMs     float64 `json:"compressMs"`      // 写压缩帧累计耗时（含压缩与写 socket）
+	RTTMs          float64 `json:"rttMs,omitempty"`
+	DisabledReason string  `json:"disabledReason,omitempty"`
+}
+
+// deflateOffered 客户端握手时是否提供了 permessage-deflate 扩展
+func deflateOffered(r *http.Request) bool {
+	for _, h := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(h, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// initCompression 握手后调用，记录是否协商出压缩
+func (c *client) initCompression(r *http.Request) {
+	c.compression.negotiated = upgrader.EnableCompression && deflateOffered(r)
+	c.compression.counter, _ = c.conn.NetConn().(*countingConn)
+}
+
+func (c *client) compressing() bool {
+	return c.compression.negotiated && !c.compression.off.Load()
+}
+
+// writeCompressed 写一条文本消息并统计压缩效果，只由 writePump 调用
+func (c *client) writeCompressed(f frame) error {
+	typ, data := f.typ, f.data
+	write := func() error {
+		if f.prepared != nil {
+			return c.conn.WritePreparedMessage(f.prepared)
+		}
+		return c.conn.WriteMessage(typ, data)
+	}
+	s := &c.compression
+	if typ == websocket.BinaryMessage && c.compressing() {
+		// 中继的文件内容多已压缩过，不再压缩
+		c.conn.EnableWriteCompression(false)
+		defer c.conn.EnableWriteCompression(true)
+	}
+	if typ != websocket.TextMessage || !c.compressing() {
+		return write()
+	}
+	c.adaptCompression()
+	if s.off.Load() {
+		return write()
+	}
+	var before int64
+	if s.counter != nil {
+		before = s.counter.written.Load()
+	}
+	start := time.Now()
+	err := write()
+	s.nanos.Add(int64(time.Since(start)))
+	if err == nil && s.counter != nil {
+		wire := s.counter.written.Load() - before
+		s.plain.Add(int64(len(data)))
+		s.wire.Add(wire)
+		s.winPlain += int64(len(data))
+		s.winWire += wire
+	}
+	return err
+}
+
+// adaptCompression 按压缩率与心跳往返决定是否停止压缩，只由 writePump 调用
+func (c *client) adaptCompression() {
+	s := &c.compression
+	reason := ""
+	ratio := 0.0
+	if s.winPlain >= compressionSample {
+		ratio = float64(s.winWire) / float64(s.winPlain)
+		s.winPlain, s.winWire = 0, 0
+	}
+	switch {
+	case *compressionMaxRatio > 0 && ratio > *compressionMaxRatio:
+		reason = fmt.Sprintf("poor ratio %.2f", ratio)
+	case *compressionMaxRTT > 0 && time.Duration(s.rtt.Load()) > *compressionMaxRTT:
+		reason = fmt.Sprintf("rtt %s", time.Duration(s.rtt.Load()).Round(time.Millisecond))
+	default:
+		return
+	}
+	c.conn.EnableWriteCompression(false)
+	s.reason.Store(reason)
+	s.off.Store(true)
+	compressionDisabled.Add(1)
+	log.Printf("🗜️ 用户 %s 的连接停止压缩：%s", c.userID, reason)
+}
+
+var compressionDisabled atomic.Int64 // 自动停止压缩的连接数
+
+func (c *client) compressionInfo() *CompressionInfo {
+	s := &c.compression
+	if !s.negotiated {
+		return nil
+	}
+	info := &CompressionInfo{
+		Negotiated: true,
+		Active:     !s.off.Load(),
+		CompressMs: float64(s.nanos.Load()) / 1e6,
+		RTTMs:      float64(s.rtt.Load()) / 1e6,
+	}
+	if plain := s.plain.Load(); plain > 0 {
+		info.Ratio = math.Round(float64(s.wire.Load())/float64(plain)*1000) / 1000
+	}
+	if r, ok := s.reason.Load().(string); ok {
+		info.DisabledReason = r
+	}
+	return info
+}
+
+// countingListener 统计每个连接写出的字节，WebSocket 接管连接后据此得到压缩后的实际大小
+type countingListener struct{ net.Listener }
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// ReadFrom 保留底层 TCP 连接的 sendfile 优化，文件下载不受影响
+func (c *countingConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(r)
+		c.written.Add(n)
+		return n, err
+	}
+	n, err := io.Copy(c.Conn, r)
+	c.written.Add(n)
+	return n, err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// 客户端提供 permessage-deflate 时双向的帧都压缩传输，收发内容不变；写出的字节明显少于原文
+func TestCompressionRoundTrip(t *testing.T) {
+	setFlag(t, &upgrader.EnableCompression, true)
+	srv := httptest.NewUnstartedServer(testMux(nil))
+	srv.Listener = countingListener{srv.Listener}
+	startTestServer(t, srv)
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial(wsURL(srv, "uid=zip"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("extensions %q, want permessage-deflate", ext)
+	}
+	zip := newTestConn(t, conn)
+	plain := dialWS(t, srv, "uid=plain")
+
+	text := strings.Repeat("compress me please ", 200)
+	zip.sendJSON(map[string]interface{}{"type": "message", "data": map[string]string{"text": text}})
+	for _, tc := range []*testConn{zip, plain} {
+		isLong := func(m map[string]interface{}) bool { return strings.HasPrefix(chatText(m), "compress") }
+		if got := chatText(tc.expectWhere("message", isLong)); got != text {
+			t.Fatalf("%s got %d bytes, want the %d-byte message unchanged", tc.userID(), len(got), len(text))
+		}
+	}
+
+	var info *CompressionInfo
+	for _, c := range clients.ByID("zip") {
+		info = c.compressionInfo()
+	}
+	if info == nil || !info.Active {
+		t.Fatalf("zip: compression %+v, want active", info)
+	}
+	if info.Ratio <= 0 || info.Ratio > 0.5 {
+		t.Fatalf("zip: ratio %v, want a highly compressed repetitive message", info.Ratio)
+	}
+	for _, c := range clients.ByID("plain") {
+		if info := c.compressionInfo(); info != nil {
+			t.Fatalf("plain: compression %+v without the extension offered", info)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func presenceOf(event, userID string) func(map[string]interface{}) bool {
+	return func(m map[string]interface{}) bool {
+		data, _ := m["data"].(map[string]interface{})
+		return data["event"] == event && data["userId"] == userID
+	}
+}
+
+func TestRegister(t *testing.T) {
+	srv := newTestServer(t, nil)
+	observer := dialWS(t, srv, "uid=observer")
+	tc := dialWS(t, srv, "uid=reg")
+	if tc.userID() != "reg" {
+		t.Fatalf("userId = %q, want reg", tc.userID())
+	}
+	if conns := clients.ByID("reg"); len(conns) != 1 {
+		t.Fatalf("registered connections = %d, want 1", len(conns))
+	}
+	observer.expectWhere("presence", presenceOf("join", "reg"))
+}
+
+// 读出错（对端直接断开 TCP，没有关闭帧）时读循环退出并注销连接
+func TestReadErrorLeaves(t *testing.T) {
+	srv := newTestServer(t, nil)
+	observer := dialWS(t, srv, "uid=observer")
+	tc := dialWS(t, srv, "uid=reader")
+	tc.conn.UnderlyingConn().Close()
+	observer.expectWhere("presence", presenceOf("leave", "reader"))
+	if clients.Online("reader") {
+		t.Fatal("reader still registered after read error")
+	}
+}
+
+// 写出错时 writePump 关闭连接，读循环随之退出并注销
+func TestWriteErrorLeaves(t *testing.T) {
+	srv, accepted := newFaultServer(t, nil)
+	observer := dialWS(t, srv, "uid=observer")
+	<-accepted
+	dialWS(t, srv, "uid=writer")
+	server := <-accepted
+
+	server.failWrites.Store(true)
+	observer.sendJSON(map[string]interface{}{"type": "message", "data": map[string]string{"text": "hello"}})
+	observer.expectWhere("presence", presenceOf("leave", "writer"))
+	if clients.Online("writer") {
+		t.Fatal("writer still registered after write error")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 连接数上限：-max-clients 限制同时打开的 WebSocket 连接（含握手中的），满员时拒绝升级并返回 503；
+// -max-conns-per-ip 限制同一客户端地址的连接数，超出返回 429。
+// 名额在升级前原子地占用、连接处理结束时归还（包括心跳超时被断开的连接），突发的大量连接也不会超出上限
+
+// 两个上限都可经配置导入在运行中修改，见 presets.go
+var maxClients, maxConnsPerIP liveInt
+
+func init() {
+	maxConnsPerIP.Store(8)
+	flag.Var(&maxClients, "max-clients", "同时打开的 WebSocket 连接上限，满员时拒绝新连接（503）；0 表示不限")
+	flag.Var(&maxConnsPerIP, "max-conns-per-ip", "同一 IP 同时打开的 WebSocket 连接上限，超出时拒绝（429）；0 表示不限。经受信任代理时按 X-Forwarded-For 计")
+}
+
+var (
+	wsSlots atomic.Int64 // 已占用的连接名额
+
+	ipConns   = make(map[string]int) // 客户端 IP -> 已占用的连接名额
+	ipConnsMu sync.Mutex
+)
+
+// acquireSlot 占用一个名额，满员时返回 false
+func acquireSlot() bool {
+	for {
+		n := wsSlots.Load()
+		if limit := maxClients.Load(); limit > 0 && n >= limit {
+			return false
+		}
+		if wsSlots.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+func releaseSlot() {
+	wsSlots.Add(-1)
+}
+
+// acquireIPSlot 为客户端 IP 占用一个名额，超出 -max-conns-per-ip 时返回 false
+func acquireIPSlot(ip string) bool {
+	ipConnsMu.Lock()
+	defer ipConnsMu.Unlock()
+	if limit := int(maxConnsPerIP.Load()); limit > 0 && ipConns[ip] >= limit {
+		return false
+	}
+	ipConns[ip]++
+	return true
+}
+
+func releaseIPSlot(ip string) {
+	ipConnsMu.Lock()
+	defer ipConnsMu.Unlock()
+	if ipConns[ip]--; ipConns[ip] <= 0 {
+		delete(ipConns, ip)
+	}
+}
+
+func errServerFull(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "30")
+	writeError(w, r, http.StatusServiceUnavailable, "server_full", "Server is full, please try again later", map[string]interface{}{
+		"maxClients":  maxClients.Load(),
+		"connections": wsSlots.Load(),
+	})
+}
+
+func errTooManyConns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "30")
+	writeError(w, r, http.StatusTooManyRequests, "too_many_connections", "Too many connections from this address", map[string]interface{}{
+		"maxConnsPerIp": maxConnsPerIP.Load(),
+	})
+}
+
+type ipConnCount struct {
+	IP          string `json:"ip"`
+	Connections int    `json:"connections"`
+}
+
+// adminIPConnsHandler GET /api/admin/ip-connections：各客户端 IP 的连接数，按数量降序，需管理员令牌
+func adminIPConnsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		errAdminRequired(w, r)
+		return
+	}
+	ipConnsMu.Lock()
+	list := make([]ipConnCount, 0, len(ipConns))
+	for ip, n := range ipConns {
+		list = append(list, ipConnCount{IP: ip, Connections: n})
+	}
+	ipConnsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Connections != list[j].Connections {
+			return list[i].Connections > list[j].Connections
+		}
+		return list[i].IP < list[j].IP
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"maxConnsPerIp": maxConnsPerIP.Load(), "ips": list})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 删除群聊消息：作者通过 {"type":"delete","data":{"id":"..."}} 删除自己的消息，管理员用
+// DELETE /api/admin/messages/{id} 删除任意消息。删除后立即以 edit（deleted:true、正文清空）通知所有人，
+// 最近动态与补发缓冲同步改为墓碑。作者删除时服务端保留原内容 -delete-grace，
+// 期间发送 {"type":"undelete"} 可恢复（以 edit 广播原内容），到期由登记表的定期清理永久清除；
+// 管理员删除不保留，立即清除。只能删除仍在最近消息中的消息
+
+var deleteGrace = flag.Duration("delete-grace", 30*time.Second, "作者删除消息后可撤销的时长，期间服务端保留原内容；0 表示删除后立即清除、不可撤销")
+
+// deletedMessages 可撤销的已删除消息：消息 ID -> 原内容，存活时间即撤销期限
+var deletedMessages = newExpiringMap[string, Message]("deleted_messages", 0, registryMaxEntries)
+
+var (
+	errMessageNotFound = errors.New("message not found")
+	errAlreadyDeleted  = errors.New("message already deleted")
+	errNotAuthor       = errors.New("only the author can delete this message")
+	errUndoExpired     = errors.New("message is not deleted or the undo window has expired")
+)
+
+type messageIDRequest struct {
+	ID string `json:"id"`
+}
+
+func init() {
+	registerWSType(wsType{
+		name:     "delete",
+		payload:  func() interface{} { return new(messageIDRequest) },
+		rate:     rateControl,
+		minProto: 2,
+		required: []string{"id"},
+		feature:  "delete",
+		handle: func(f *wsFrame) {
+			id := f.payload.(*messageIDRequest).ID
+			if err := deleteMessage(id, f.c.userID, *deleteGrace); err != nil {
+				sendWSError(f.c, "delete", deleteErrorCode(err), err.Error(), map[string]interface{}{"id": id})
+			}
+		},
+	})
+	registerWSType(wsType{
+		name:     "undelete",
+		payload:  func() interface{} { return new(messageIDRequest) },
+		rate:     rateControl,
+		minProto: 2,
+		required: []string{"id"},
+		handle: func(f *wsFrame) {
+			id := f.payload.(*messageIDRequest).ID
+			if err := undeleteMessage(id, f.c.userID); err != nil {
+				sendWSError(f.c, "undelete", deleteErrorCode(err), err.Error(), map[string]interface{}{"id": id})
+			}
+		},
+	})
+}
+
+func deleteErrorCode(err error) string {
+	switch err {
+	case errMessageNotFound:
+		return "message_not_found"
+	case errAlreadyDeleted:
+		return "already_deleted"
+	case errNotAuthor:
+		return "not_author"
+	case errUndoExpired:
+		return "undo_expired"
+	}
+	return "server_error"
+}
+
+// deleteMessage 把最近消息中的一条改为墓碑并广播；author 为空表示管理员删除，
+// grace > 0 时保留原内容以便撤销
+func deleteMessage(id, author string, grace time.Duration) error {
+	recentMessagesMu.Lock()
+	i := findRecentMessage(id)
+	if i < 0 {
+		recentMessagesMu.Unlock()
+		return errMessageNotFound
+	}
+	m := &recentMessages[i]
+	switch {
+	case m.Deleted:
+		recentMessagesMu.Unlock()
+		return errAlreadyDeleted
+	case author != "" && m.From != author:
+		recentMessagesMu.Unlock()
+		return errNotAuthor
+	}
+	orig := m.Message
+	// 在锁内先改为墓碑，并发的重复删除直接返回 already_deleted；广播经 rememberMessage 再更新一次，结果相同
+	tombstone(&m.Message)
+	recentMessagesMu.Unlock()
+
+	if author != "" && grace > 0 {
+		deletedMessages.SetTTL(id, orig, grace)
+	}
+	t := orig
+	tombstone(&t)
+	broadcast(WSMessage{Type: "edit", Data: t})
+	if author == "" {
+		log.Printf("🗑️ 管理员删除了 %s 的消息 %s", orig.From, id)
+	}
+	return nil
+}
+
+// undeleteMessage 撤销期限内由作者恢复原内容；与定期清理竞争时以登记表的删除为准，只有一方成功
+func undeleteMessage(id, author string) error {
+	orig, ok := deletedMessages.Get(id)
+	if !ok {
+		return errUndoExpired
+	}
+	if orig.From != author {
+		return errNotAuthor
+	}
+	if !deletedMessages.DeleteIf(id, func(Message) bool { return true }) {
+		return errUndoExpired
+	}
+	// 撤销期间附件可能已被删除
+	if orig.Attachments != nil {
+		list := make([]Attachment, len(orig.Attachments))
+		for i, a := range orig.Attachments {
+			if _, ok := lookupFile(a.SavedName); !ok && !a.Removed {
+				a.File, a.Removed = nil, true
+			}
+			list[i] = a
+		}
+		orig.Attachments = list
+	}
+	broadcast(WSMessage{Type: "edit", Data: orig})
+	return nil
+}
+
+// tombstone 清除正文、附件与译文，只保留 ID、发送者与时间
+func tombstone(m *Message) {
+	m.Text = ""
+	m.Attachments = []Attachment{}
+	m.Translations = nil
+	m.Deleted = true
+}
+
+// findRecentMessage 返回最近消息中该 ID 的下标，调用方需持有 recentMessagesMu
+func findRecentMessage(id string) int {
+	if id == "" {
+		return -1
+	}
+	for i := len(recentMessages) - 1; i >= 0; i-- {
+		if recentMessages[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// adminDeleteMessageHandler DELETE /api/admin/messages/{id}，不保留撤销期
+func adminDeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	if !isAdmin(r) {
+		errAdminRequired(w, r)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/admin/messages/")
+	// 同一消息若正处于作者的撤销期，一并清除原内容
+	deletedMessages.Delete(id)
+	switch err := deleteMessage(id, "", 0); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errMessageNotFound:
+		writeError(w, r, http.StatusNotFound, "message_not_found", "Message not found", nil)
+	case errAlreadyDeleted:
+		// 作者已删除（可能仍在撤销期内）：上面已清除原内容，视为成功
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusInternalServerError, "server_error", err.Error(), nil)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sendAndWaitID 发一条聊天消息，从回显中取得消息 ID
+func sendAndWaitID(tc *testConn, text string) string {
+	tc.t.Helper()
+	tc.sendJSON(map[string]interface{}{"type": "message", "data": map[string]string{"text": text}})
+	m := tc.expectWhere("message", func(m map[string]interface{}) bool { return chatText(m) == text })
+	id, _ := m["data"].(map[string]interface{})["id"].(string)
+	return id
+}
+
+// activityMessage /api/activity 中该 ID 的消息，不存在时返回 nil
+func activityMessage(t *testing.T, srv *httptest.Server, id string) *Message {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/api/activity?messages=100&files=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Items []ActivityItem `json:"items"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	for _, it := range body.Items {
+		if it.Message != nil && it.Message.ID == id {
+			return it.Message
+		}
+	}
+	return nil
+}
+
+func editOf(id string, deleted bool) func(map[string]interface{}) bool {
+	return func(m map[string]interface{}) bool {
+		data, _ := m["data"].(map[string]interface{})
+		return data["id"] == id && (data["deleted"] == true) == deleted
+	}
+}
+
+// 撤销期内最近动态只给出墓碑，原内容不外泄；撤销后恢复
+func TestDeleteTombstoneInHistory(t *testing.T) {
+	srv := newTestServer(t, map[string]http.HandlerFunc{"/api/activity": activityHandler})
+	author := dialWS(t, srv, "uid=eraser")
+	id := sendAndWaitID(author, "secret plan")
+
+	author.sendJSON(map[string]interface{}{"type": "delete", "data": map[string]string{"id": id}})
+	author.expectWhere("edit", editOf(id, true))
+	waitFor(t, "the tombstone in /api/activity", func() bool {
+		m := activityMessage(t, srv, id)
+		return m != nil && m.Deleted
+	})
+	if m := activityMessage(t, srv, id); m.Text != "" || m.From != "eraser" {
+		t.Fatalf("tombstone in history: %+v", m)
+	}
+
+	author.sendJSON(map[string]interface{}{"type": "undelete", "data": map[string]string{"id": id}})
+	author.expectWhere("edit", editOf(id, false))
+	waitFor(t, "the restored message in /api/activity", func() bool {
+		m := activityMessage(t, srv, id)
+		return m != nil && !m.Deleted && m.Text == "secret plan"
+	})
+}
+
+// 撤销与定期清理同时发生：只有一方成功。撤销成功则原文恢复，否则回 undo_expired 且原文已清除，
+// 两种情况下最近动态都与结果一致
+func TestUndeleteRacesPurge(t *testing.T) {
+	setFlag(t, deleteGrace, 30*time.Millisecond)
+	srv := newTestServer(t, map[string]http.HandlerFunc{"/api/activity": activityHandler})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				deletedMessages.sweep()
+				time.Sleep(100 * time.Microsecond)
+			}
+		}
+	}()
+
+	outcomes := make(map[bool]int)
+	for i := range 8 {
+		// 每轮换一个连接，避免控制类消息被限速
+		author := dialWS(t, srv, fmt.Sprintf("uid=racer%d", i))
+		text := fmt.Sprintf("race %d", i)
+		id := sendAndWaitID(author, text)
+		author.sendJSON(map[string]interface{}{"type": "delete", "data": map[string]string{"id": id}})
+		author.expectWhere("edit", editOf(id, true))
+		// 撤销落在撤销期限前后
+		time.Sleep(time.Duration(20+i*3) * time.Millisecond)
+		author.sendJSON(map[string]interface{}{"type": "undelete", "data": map[string]string{"id": id}})
+
+		var restored bool
+		for {
+			m, err := author.next()
+			if err != nil {
+				t.Fatalf("round %d: %v", i, err)
+			}
+			if m["type"] == "edit" && editOf(id, false)(m) {
+				restored = true
+				break
+			}
+			if data, _ := m["data"].(map[string]interface{}); m["type"] == "error" && data["type"] == "undelete" {
+				if data["code"] != "undo_expired" {
+					t.Fatalf("round %d: undelete error %v", i, data)
+				}
+				break
+			}
+		}
+		outcomes[restored]++
+		if _, ok := deletedMessages.Get(id); ok {
+			t.Fatalf("round %d: original content still held after the undo resolved", i)
+		}
+		waitFor(t, "history to match the outcome", func() bool {
+			m := activityMessage(t, srv, id)
+			if restored {
+				return m != nil && !m.Deleted && m.Text == text
+			}
+			return m != nil && m.Deleted && m.Text == ""
+		})
+	}
+	t.Logf("restored %d, expired %d", outcomes[true], outcomes[false])
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 免打扰：期间消息照常送达（保证历史完整），但聊天帧带 muted:true，前端据此不响铃、不弹通知。
+// 按 userId 保存，携带相同 uid 重连后仍然有效，到期自动失效
+
+var dndUntil = newExpiringMap[string, time.Time]("dnd", 0, registryMaxEntries) // userId -> 到期时间，记录随之过期
+
+func init() {
+	registerWSType(wsType{name: "dnd", rate: rateControl, feature: "dnd", handle: func(f *wsFrame) { handleDND(f.c.userID, f.raw) }})
+}
+
+// handleDND 处理 {"type":"dnd","data":{"room":"","until":"RFC3339"}}，until 为空或已过期表示关闭
+func handleDND(userID string, raw json.RawMessage) {
+	var req struct {
+		Room  string `json:"room"`
+		Until string `json:"until"`
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		forwardSignal(userID, map[string]interface{}{"type": "dnd_error", "data": map[string]string{"error": "invalid dnd payload"}})
+		return
+	}
+	// 服务端没有房间，只支持全局免打扰
+	if req.Room != "" {
+		forwardSignal(userID, map[string]interface{}{"type": "dnd_error", "data": map[string]string{"error": "rooms are not supported, use an empty room for server-wide dnd"}})
+		return
+	}
+	var until time.Time
+	if req.Until != "" {
+		t, err := time.Parse(time.RFC3339, req.Until)
+		if err != nil {
+			forwardSignal(userID, map[string]interface{}{"type": "dnd_error", "data": map[string]string{"error": "until must be RFC3339"}})
+			return
+		}
+		until = t
+	}
+
+	if d := time.Until(until); d > 0 {
+		dndUntil.SetTTL(userID, until, d)
+	} else {
+		dndUntil.Delete(userID)
+	}
+	forwardSignal(userID, map[string]interface{}{"type": "dnd", "data": dndStatus(userID)})
+}
+
+// isMuted 该用户当前是否处于免打扰
+func isMuted(userID string) bool {
+	_, ok := dndUntil.Get(userID)
+	return ok
+}
+
+// dndStatus 随 init 与 dnd 回执下发
+func dndStatus(userID string) map[string]interface{} {
+	until, ok := dndUntil.Get(userID)
+	if !ok {
+		return map[string]interface{}{"active": false}
+	}
+	return map[string]interface{}{"active": true, "room": "", "until": until.Format(time.RFC3339)}
+}
+
+// mutable 只有用户发出的聊天帧需要静音，系统通知与在线列表不受影响
+func mutable(msg WSMessage) bool {
+	return (msg.Type == "message" || msg.Type == "private") && msg.Data.From != "system"
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 自定义表情：小图片存放在上传目录的隐藏子目录中，消息里用 :name: 引用
+
+const (
+	emojiDirName = ".emoji"
+	maxEmojiSize = 256 << 10
+)
+
+var (
+	emojiNameRe = regexp.MustCompile(`^[a-z0-9_+-]{2,32}$`)
+	emojiTypes  = map[string]string{
+		"image/png":  ".png",
+		"image/gif":  ".gif",
+		"image/webp": ".webp",
+		"image/jpeg": ".jpg",
+	}
+
+	emojiCatalog = make(map[string]string) // name -> 磁盘文件名
+	emojiMu      sync.RWMutex
+)
+
+func emojiDir() string {
+	return filepath.Join(*uploadDir, emojiDirName)
+}
+
+// loadEmoji 启动时扫描表情目录重建目录表
+func loadEmoji() {
+	if err := os.MkdirAll(emojiDir(), 0755); err != nil {
+		log.Printf("⚠️  无法创建表情目录: %v", err)
+		return
+	}
+	entries, err := os.ReadDir(emojiDir())
+	if err != nil {
+		return
+	}
+	emojiMu.Lock()
+	defer emojiMu.Unlock()
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if !e.IsDir() && emojiNameRe.MatchString(name) {
+			emojiCatalog[name] = e.Name()
+		}
+	}
+}
+
+// emojiURLs 返回 name -> URL 的目录表
+func emojiURLs() map[string]string {
+	emojiMu.RLock()
+	defer emojiMu.RUnlock()
+	out := make(map[string]string, len(emojiCatalog))
+	for name, file := range emojiCatalog {
+		out[name] = "/emoji/" + file
+	}
+	return out
+}
+
+func broadcastEmojiCatalog() {
+	data, _ := json.Marshal(emojiURLs())
+	broadcast(WSMessage{Type: "emoji", Data: Message{Text: string(data), From: "system", Time: time.Now().Format("15:04:05")}})
+}
+
+// isMember 管理员或当前在线用户才能管理表情；在线用户以 X-Upload-Token 证明身份，与上传相同
+func isMember(r *http.Request) bool {
+	return isAdmin(r) || tokenUser(r) != ""
+}
+
+// emojiHandler GET/POST /api/emoji
+func emojiHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(emojiURLs())
+	case http.MethodPost:
+		addEmoji(w, r)
+	default:
+		errMethodNotAllowed(w, r)
+	}
+}
+
+func addEmoji(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxEmojiSize+4096)
+	if err := r.ParseMultipartForm(maxEmojiSize + 4096); err != nil {
+		writeError(w, r, http.StatusBadRequest, "emoji_too_large", "Emoji too large (max 256 KB)", map[string]interface{}{"maxBytes": maxEmojiSize})
+		return
+	}
+	if !isMember(r) {
+		writeError(w, r, http.StatusForbidden, "not_member", "Only online users (X-Upload-Token) or admins can add emoji", nil)
+		return
+	}
+	name := strings.ToLower(strings.Trim(r.FormValue("name"), ":"))
+	if !emojiNameRe.MatchString(name) {
+		writeError(w, r, http.StatusBadRequest, "invalid_emoji_name", "Invalid emoji name (2-32 chars of a-z 0-9 _ + -)", map[string]interface{}{"pattern": emojiNameRe.String()})
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "no_file", "No file uploaded", nil)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxEmojiSize+1))
+	if err != nil || len(data) > maxEmojiSize {
+		writeError(w, r, http.StatusBadRequest, "emoji_too_large", "Emoji too large (max 256 KB)", map[string]interface{}{"maxBytes": maxEmojiSize})
+		return
+	}
+	// 以实际内容判断类型，不信任扩展名
+	ext, ok := emojiTypes[http.DetectContentType(data)]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "unsupported_type", "Unsupported emoji type (png, gif, webp, jpeg)", map[string]interface{}{"allowed": []string{"png", "gif", "webp", "jpeg"}})
+		return
+	}
+
+	emojiMu.Lock()
+	defer emojiMu.Unlock()
+	if _, exists := emojiCatalog[name]; exists {
+		writeError(w, r, http.StatusConflict, "emoji_exists", "Emoji already exists", nil)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(emojiDir(), name+ext), data, 0644); err != nil {
+		log.Printf("保存表情失败: %v", err)
+		errServer(w, r)
+		return
+	}
+	emojiCatalog[name] = name + ext
+	go broadcastEmojiCatalog()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"name": name, "url": "/emoji/" + name + ext})
+}
+
+// deleteEmojiHandler DELETE /api/emoji/{name}
+func deleteEmojiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	if !isMember(r) {
+		writeError(w, r, http.StatusForbidden, "not_member", "Only online users (X-Upload-Token) or admins can delete emoji", nil)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/emoji/")
+
+	emojiMu.Lock()
+	file, ok := emojiCatalog[name]
+	if ok {
+		delete(emojiCatalog, name)
+	}
+	emojiMu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "emoji_not_found", "Emoji not found", nil)
+		return
+	}
+	if err := os.Remove(filepath.Join(emojiDir(), file)); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除表情文件失败 %s: %v", file, err)
+	}
+	go broadcastEmojiCatalog()
+	w.WriteHeader(http.StatusNoContent)
+}
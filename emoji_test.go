@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// 在线用户只能以上传令牌证明身份，from 填一个在线的 userId 不算
+func TestEmojiMembership(t *testing.T) {
+	srv := newTestServer(t, map[string]http.HandlerFunc{"/api/emoji/": deleteEmojiHandler})
+	alice := dialWS(t, srv, "uid=alice")
+	token := alice.uploadToken()
+
+	del := func(query, token string) (int, string) {
+		req, _ := http.NewRequest("DELETE", srv.URL+"/api/emoji/nope"+query, nil)
+		if token != "" {
+			req.Header.Set("X-Upload-Token", token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Error apiErrorBody `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Error.Code
+	}
+	if status, code := del("?from=alice", ""); status != 403 || code != "not_member" {
+		t.Fatalf("from=alice without a token: %d %q, want 403 not_member", status, code)
+	}
+	if status, code := del("", "bogus"); status != 403 || code != "not_member" {
+		t.Fatalf("unknown token: %d %q, want 403 not_member", status, code)
+	}
+	if status, code := del("", token); status != 404 || code != "emoji_not_found" {
+		t.Fatalf("alice's token: %d %q, want 404 emoji_not_found", status, code)
+	}
+
+	alice.conn.Close()
+	waitFor(t, "alice to go offline", func() bool { return !clients.Online("alice") })
+	if status, code := del("", token); status != 403 || code != "not_member" {
+		t.Fatalf("token of a closed connection: %d %q, want 403 not_member", status, code)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 统一的错误响应：{"error":{"code":"file_too_large","message":"...","details":{...}}}
+// 客户端通过 Accept: text/plain（或浏览器直接访问页面时的 text/html）可取得纯文本
+
+type apiErrorBody struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// wantsPlainText 客户端明确要纯文本或 HTML，且未同时接受 JSON
+func wantsPlainText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") {
+		return false
+	}
+	return strings.Contains(accept, "text/plain") || strings.Contains(accept, "text/html")
+}
+
+// writeError 按统一格式写出错误，保留 HTTP 状态码
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]interface{}) {
+	if wantsPlainText(r) {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]apiErrorBody{
+		"error": {Code: code, Message: message, Details: details},
+	})
+}
+
+func errMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", map[string]interface{}{"method": r.Method})
+}
+
+func errServer(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusInternalServerError, "server_error", "Server error", nil)
+}
+
+func errFileNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "file_not_found", "File not found", nil)
+}
+
+func errInvalidFilename(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusBadRequest, "invalid_filename", "Invalid filename", nil)
+}
+
+func errInvalidJSON(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusBadRequest, "invalid_json", "Invalid JSON", nil)
+}
+
+// errMissingFields 必填字段缺失，details.fields 列出字段名
+func errMissingFields(w http.ResponseWriter, r *http.Request, fields ...string) {
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		quoted[i] = "'" + f + "'"
+	}
+	writeError(w, r, http.StatusBadRequest, "missing_field", "Missing "+strings.Join(quoted, " or "), map[string]interface{}{"fields": fields})
+}
+
+func errAdminRequired(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusUnauthorized, "admin_required", "Admin token required", nil)
+}
+
+func errFileTooLarge(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusBadRequest, "file_too_large", fmt.Sprintf("File too large (max %s)", localeFor(r).size(maxSize.Load())), map[string]interface{}{"maxBytes": maxSize.Load()})
+}
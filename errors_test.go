@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 每个接口的典型错误：状态码与 error.code 都是客户端可以依赖的约定
+func TestEndpointErrorCodes(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tooMany := `["` + strings.Repeat(`a.txt","`, maxAttachments) + `a.txt"]`
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"GET", "/send", "", 405, "method_not_allowed"},
+		{"POST", "/send", "{", 400, "invalid_json"},
+		{"POST", "/send", `{"message":"hi"}`, 400, "missing_field"},
+		{"POST", "/send", `{"message":"hi","from":"a","attachments":` + tooMany + `}`, 400, "too_many_attachments"},
+		{"POST", "/send", `{"message":"hi","from":"a","attachments":["../main.go"]}`, 400, "attachment_not_found"},
+		{"POST", "/send", `{"message":"hi","from":"a","room":"no such/room"}`, 400, "invalid_room"},
+		{"GET", "/send/private", "", 405, "method_not_allowed"},
+		{"POST", "/send/private", "{", 400, "invalid_json"},
+		{"POST", "/send/private", `{"message":"hi","from":"a"}`, 400, "missing_field"},
+		{"POST", "/send/private", `{"message":"hi","from":"a","to":"nobody"}`, 404, "user_offline"},
+		{"GET", "/upload", "", 405, "method_not_allowed"},
+		{"POST", "/api/activity", "", 405, "method_not_allowed"},
+		{"POST", "/api/rooms", "", 405, "method_not_allowed"},
+		{"POST", "/api/capabilities", "", 405, "method_not_allowed"},
+		{"GET", "/api/files/changes?since=bogus", "", 410, "cursor_expired"},
+		{"GET", "/api/messages/nope/receipts", "", 404, "receipt_not_found"},
+		{"GET", "/api/admin/connections", "", 401, "admin_required"},
+		{"GET", "/api/stats?range=forever", "", 400, "invalid_range"},
+		{"POST", "/api/admin/config/import", "{}", 401, "admin_required"},
+		{"DELETE", "/api/admin/messages/nope", "", 401, "admin_required"},
+		{"GET", "/upload/progress/x", "", 404, "progress_not_found"},
+		{"GET", "/f/zzzzzz", "", 404, "code_not_found"},
+		{"GET", "/share/missing.txt", "", 404, "file_not_found"},
+		{"DELETE", "/api/emoji/nope", "", 403, "not_member"},
+		{"GET", "/api/files/missing.txt/preview?format=pdf", "", 400, "unsupported_format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var body struct {
+				Error apiErrorBody `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("status %d, body is not the JSON error envelope: %v", resp.StatusCode, err)
+			}
+			if resp.StatusCode != tt.status || body.Error.Code != tt.code {
+				t.Fatalf("got %d %q (%s), want %d %q", resp.StatusCode, body.Error.Code, body.Error.Message, tt.status, tt.code)
+			}
+			if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+		})
+	}
+}
+
+func TestErrorPlainText(t *testing.T) {
+	for _, accept := range []string{"text/plain", "text/html"} {
+		req := httptest.NewRequest("GET", "/send", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		sendHandler(w, req)
+		body, _ := io.ReadAll(w.Result().Body)
+		if w.Code != 405 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") || strings.TrimSpace(string(body)) != "Method not allowed" {
+			t.Fatalf("Accept %s: %d %q %q", accept, w.Code, w.Header().Get("Content-Type"), body)
+		}
+	}
+	// 同时接受 JSON 时仍返回 JSON
+	req := httptest.NewRequest("GET", "/send", nil)
+	req.Header.Set("Accept", "text/plain, application/json")
+	w := httptest.NewRecorder()
+	sendHandler(w, req)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("Content-Type = %q, want JSON", w.Header().Get("Content-Type"))
+	}
+}
+
+// 超限错误在 details 中带上被违反的数值上限
+func TestErrorDetails(t *testing.T) {
+	w := httptest.NewRecorder()
+	errFileTooLarge(w, httptest.NewRequest("POST", "/upload", nil))
+	var body struct {
+		Error apiErrorBody `json:"error"`
+	}
+	if err := json.NewDecoder(w.Result().Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 400 || body.Error.Code != "file_too_large" {
+		t.Fatalf("got %d %q", w.Code, body.Error.Code)
+	}
+	if max, _ := body.Error.Details["maxBytes"].(float64); int64(max) != int64(maxSize) {
+		t.Fatalf("details.maxBytes = %v, want %d", body.Error.Details["maxBytes"], int64(maxSize))
+	}
+}
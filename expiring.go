@@ -0,0 +1,281 @@
+package main
+
+import (
+	"container/list"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 带过期与容量上限的登记表：按用户、令牌等键保存的临时状态（上传令牌、上传进度、免打扰、
+// 邀请频率、流量计数）都用它，长期运行的公开实例不会因为身份不断更替而无限增长。
+// 每条记录有各自的到期时间；超过容量时淘汰最久未访问的记录。
+// 所有登记表由 startRegistrySweeper 定期清理，大小与淘汰数在 /metrics 中输出
+
+var debugLog = flag.Bool("debug", false, "输出调试日志（如各登记表的定期报告）")
+
+const (
+	registryMaxEntries     = 100000 // 按用户或令牌索引的登记表默认容量
+	expiringSweepInterval  = time.Minute
+	expiringReportInterval = 5 * time.Minute
+	expiringShrinkMin      = 1024 // 峰值低于此数的登记表不值得重建
+)
+
+type expiringEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time // 零值表示不过期
+}
+
+type expiringMap[K comparable, V any] struct {
+	name       string
+	ttl        time.Duration // Set 的默认存活时间，0 表示不过期
+	maxEntries int
+
+	mu       sync.Mutex
+	items    map[K]*list.Element
+	order    *list.List // 表头为最近访问
+	expired  uint64
+	capacity uint64 // 因超出容量被淘汰的条数
+	peak     int    // 上次重建 items 以来的最大条数，见 sweep
+}
+
+// registry 供 /metrics 与定期报告遍历全部登记表
+type registry interface {
+	stats() registryStats
+	sweep()
+}
+
+type registryStats struct {
+	Name     string
+	Entries  int
+	Max      int
+	Expired  uint64
+	Capacity uint64
+}
+
+var (
+	registries   []registry
+	registriesMu sync.Mutex
+	sweeperOnce  sync.Once
+)
+
+func newExpiringMap[K comparable, V any](name string, ttl time.Duration, maxEntries int) *expiringMap[K, V] {
+	m := &expiringMap[K, V]{name: name, ttl: ttl, maxEntries: maxEntries, items: make(map[K]*list.Element), order: list.New()}
+	registriesMu.Lock()
+	registries = append(registries, m)
+	registriesMu.Unlock()
+	return m
+}
+
+// lookup 调用方需持有 mu；已过期的记录顺带删除
+func (m *expiringMap[K, V]) lookup(k K, now time.Time) *expiringEntry[K, V] {
+	el, ok := m.items[k]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*expiringEntry[K, V])
+	if !e.expires.IsZero() && now.After(e.expires) {
+		m.order.Remove(el)
+		delete(m.items, k)
+		m.expired++
+		return nil
+	}
+	return e
+}
+
+// store 调用方需持有 mu
+func (m *expiringMap[K, V]) store(k K, v V, expires time.Time) {
+	if el, ok := m.items[k]; ok {
+		e := el.Value.(*expiringEntry[K, V])
+		e.value, e.expires = v, expires
+		m.order.MoveToFront(el)
+		return
+	}
+	m.items[k] = m.order.PushFront(&expiringEntry[K, V]{key: k, value: v, expires: expires})
+	m.peak = max(m.peak, len(m.items))
+	for m.maxEntries > 0 && len(m.items) > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.items, oldest.Value.(*expiringEntry[K, V]).key)
+		m.capacity++
+	}
+}
+
+func (m *expiringMap[K, V]) expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+func (m *expiringMap[K, V]) Get(k K) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(k, time.Now())
+	if e == nil {
+		var zero V
+		return zero, false
+	}
+	m.order.MoveToFront(m.items[k])
+	return e.value, true
+}
+
+// Set 以默认存活时间保存
+func (m *expiringMap[K, V]) Set(k K, v V) {
+	m.SetTTL(k, v, m.ttl)
+}
+
+// SetTTL 以指定存活时间保存，ttl <= 0 表示不过期
+func (m *expiringMap[K, V]) SetTTL(k K, v V, ttl time.Duration) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store(k, v, m.expiry(now, ttl))
+}
+
+// Update 原子地读改写一条记录并重置其存活时间；fn 的 ok 表示原记录是否存在
+func (m *expiringMap[K, V]) Update(k K, fn func(v V, ok bool) V) V {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var old V
+	e := m.lookup(k, now)
+	if e != nil {
+		old = e.value
+	}
+	v := fn(old, e != nil)
+	m.store(k, v, m.expiry(now, m.ttl))
+	return v
+}
+
+func (m *expiringMap[K, V]) Delete(k K) {
+	m.DeleteIf(k, func(V) bool { return true })
+}
+
+// DeleteIf 记录存在且 fn 返回 true 时删除，返回是否删除
+func (m *expiringMap[K, V]) DeleteIf(k K, fn func(V) bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(k, time.Now())
+	if e == nil || !fn(e.value) {
+		return false
+	}
+	m.order.Remove(m.items[k])
+	delete(m.items, k)
+	return true
+}
+
+// DeleteFunc 删除 fn 返回 true 的全部记录
+func (m *expiringMap[K, V]) DeleteFunc(fn func(K, V) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, el := range m.items {
+		if fn(k, el.Value.(*expiringEntry[K, V]).value) {
+			m.order.Remove(el)
+			delete(m.items, k)
+		}
+	}
+}
+
+// Range 遍历未过期的记录，不影响访问顺序；fn 中不得再访问本登记表
+func (m *expiringMap[K, V]) Range(fn func(K, V)) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, el := range m.items {
+		if e := el.Value.(*expiringEntry[K, V]); e.expires.IsZero() || !now.After(e.expires) {
+			fn(k, e.value)
+		}
+	}
+}
+
+// Clear 清空全部记录，不计入淘汰数
+func (m *expiringMap[K, V]) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = make(map[K]*list.Element)
+	m.order.Init()
+	m.peak = 0
+}
+
+func (m *expiringMap[K, V]) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items)
+}
+
+// sweep 删除过期记录。Go 的 map 删除后不归还桶的内存，条数降到峰值的四分之一以下时重建一次，
+// 一阵身份涌入过后内存能回落
+func (m *expiringMap[K, V]) sweep() {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.items {
+		m.lookup(k, now)
+	}
+	if m.peak >= expiringShrinkMin && len(m.items) < m.peak/4 {
+		items := make(map[K]*list.Element, len(m.items))
+		for k, el := range m.items {
+			items[k] = el
+		}
+		m.items, m.peak = items, len(items)
+	}
+}
+
+func (m *expiringMap[K, V]) stats() registryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return registryStats{Name: m.name, Entries: len(m.items), Max: m.maxEntries, Expired: m.expired, Capacity: m.capacity}
+}
+
+func registryStatsAll() []registryStats {
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	list := make([]registryStats, len(registries))
+	for i, r := range registries {
+		list[i] = r.stats()
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// startRegistrySweeper 定期清理过期记录，-debug 时输出各登记表的大小
+func startRegistrySweeper() {
+	sweeperOnce.Do(func() {
+		go func() {
+			lastReport := time.Now()
+			for range time.Tick(expiringSweepInterval) {
+				registriesMu.Lock()
+				all := append([]registry(nil), registries...)
+				registriesMu.Unlock()
+				for _, r := range all {
+					r.sweep()
+				}
+				if *debugLog && time.Since(lastReport) >= expiringReportInterval {
+					lastReport = time.Now()
+					for _, s := range registryStatsAll() {
+						log.Printf("[debug] 登记表 %s: %d/%d 条，已过期 %d，超容淘汰 %d", s.Name, s.Entries, s.Max, s.Expired, s.Capacity)
+					}
+				}
+			}
+		}()
+	})
+}
+
+func writeRegistryMetrics(w io.Writer) {
+	all := registryStatsAll()
+	io.WriteString(w, "# HELP gochat_registry_entries Entries in in-memory registries keyed by user or token.\n# TYPE gochat_registry_entries gauge\n")
+	for _, s := range all {
+		fmt.Fprintf(w, "gochat_registry_entries{registry=%q} %d\n", s.Name, s.Entries)
+	}
+	io.WriteString(w, "# HELP gochat_registry_evictions_total Registry entries removed by expiry or capacity.\n# TYPE gochat_registry_evictions_total counter\n")
+	for _, s := range all {
+		fmt.Fprintf(w, "gochat_registry_evictions_total{registry=%q,reason=\"expired\"} %d\n", s.Name, s.Expired)
+		fmt.Fprintf(w, "gochat_registry_evictions_total{registry=%q,reason=\"capacity\"} %d\n", s.Name, s.Capacity)
+	}
+}
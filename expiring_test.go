@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// churnTarget 一个按 userId 索引的登记表，soak 测试向其中写入短暂的身份
+type churnTarget struct {
+	name  string
+	set   func(id string, ttl time.Duration)
+	len   func() int
+	sweep func()
+}
+
+func churnOf[V any](m *expiringMap[string, V], v V) churnTarget {
+	return churnTarget{
+		name:  m.name,
+		set:   func(id string, ttl time.Duration) { m.SetTTL(id, v, ttl) },
+		len:   m.Len,
+		sweep: m.sweep,
+	}
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// 公开实例长期运行：10 万个短暂出现的身份在各登记表里留下记录，过期清理后条数与内存都回到基线
+func TestRegistrySoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	const identities = 100000
+	const ttl = 50 * time.Millisecond
+	targets := []churnTarget{
+		churnOf(userColors, "#0E7490"),
+		churnOf(userNicks, "nick"),
+		churnOf(uploadTokens, uploadToken{}),
+		churnOf(dndUntil, time.Time{}),
+		churnOf(userSessions, "session"),
+		churnOf(resumeTokens, resumeTicket{}),
+		churnOf(userBandwidth, &bandwidth{}),
+		churnOf(userForcedCloses, int64(1)),
+	}
+	baseLen := make([]int, len(targets))
+	for i, m := range targets {
+		baseLen[i] = m.len()
+	}
+	baseHeap := heapAlloc()
+
+	for i := range identities {
+		id := fmt.Sprintf("soak%06d", i)
+		for _, m := range targets {
+			m.set(id, ttl)
+		}
+	}
+	for _, m := range targets {
+		if n := m.len(); n > registryMaxEntries {
+			t.Errorf("%s: %d entries, capacity %d", m.name, n, registryMaxEntries)
+		}
+	}
+	peakHeap := heapAlloc()
+	time.Sleep(ttl)
+	for i, m := range targets {
+		m.sweep()
+		// 超出容量时淘汰的可能是测试前已有的记录，所以只要求不多于基线
+		if n := m.len(); n > baseLen[i] {
+			t.Errorf("%s: %d entries after sweep, baseline %d", m.name, n, baseLen[i])
+		}
+	}
+	// 留出几 MB 余量给测试期间其他分配
+	if heap := heapAlloc(); heap > baseHeap+4<<20 {
+		t.Errorf("heap after sweep %d KB, baseline %d KB (peak %d KB)", heap>>10, baseHeap>>10, peakHeap>>10)
+	}
+}
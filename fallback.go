@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// 富消息的纯文本降级：连接可在握手时用 ?caps=file_card,file_comment 声明能渲染的结构化消息，
+// 未声明的能力改收一条普通 message（如 IRC 网关、命令行客户端）；不带 caps 参数视为全部支持。
+// 各能力的文本渲染放在对应功能的文件里
+
+type textFallback struct {
+	capability string
+	// render 按接收方的显示格式把消息转成纯文本版本；消息不属于该能力时返回 false
+	render func(WSMessage, *locale) (WSMessage, bool)
+}
+
+var textFallbacks = []textFallback{
+	{"file_card", fileCardText},
+	{"file_comment", fileCommentText},
+}
+
+// parseCaps 解析 ?caps=，未提供时返回 nil（全部支持）
+func parseCaps(r *http.Request) map[string]bool {
+	if !r.URL.Query().Has("caps") {
+		return nil
+	}
+	caps := make(map[string]bool)
+	for _, c := range strings.Split(r.URL.Query().Get("caps"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			caps[c] = true
+		}
+	}
+	return caps
+}
+
+func (c *client) hasCap(capability string) bool {
+	return c.caps == nil || c.caps[capability]
+}
+
+// capList 连接实际生效的能力，用于管理接口展示
+func (c *client) capList() []string {
+	list := []string{}
+	for _, f := range textFallbacks {
+		if c.hasCap(f.capability) {
+			list = append(list, f.capability)
+		}
+	}
+	return list
+}
+
+// plainTextFor 若消息需要某项能力，返回该能力名与降级后的消息
+func plainTextFor(msg WSMessage, l *locale) (string, WSMessage, bool) {
+	for _, f := range textFallbacks {
+		if plain, ok := f.render(msg, l); ok {
+			return f.capability, plain, true
+		}
+	}
+	return "", msg, false
+}
+
+// absoluteLink 广播时没有请求可推断地址，配置了 -public-url 才能补全相对链接
+func absoluteLink(u string) string {
+	if strings.HasPrefix(u, "/") && *publicURL != "" {
+		return *publicURL + u
+	}
+	return u
+}
+
+// frameVariant 决定发给 c 的版本：是否改用纯文本、是否带 muted；ok 为 false 表示不发。
+// 连接不认识的消息类型若有纯文本版本，同样降级发送（如 v1 客户端收到文件评论提醒）
+func frameVariant(c *client, msg WSMessage, capability string, plain WSMessage, hasPlain bool) (asText, muted, ok bool) {
+	asText = hasPlain && (!c.hasCap(capability) || !c.supports(msg.Type))
+	if asText && !c.supports(plain.Type) || !asText && !c.supports(msg.Type) {
+		return false, false, false
+	}
+	muted = mutable(msg) && c.userID != msg.Data.From && isMuted(c.userID)
+	return asText, muted, true
+}
+
+// encodeFor 单个接收方的编码，用于私聊等点对点发送
+func encodeFor(c *client, msg WSMessage) []byte {
+	capability, plain, hasPlain := plainTextFor(msg, c.locale)
+	asText, muted, _ := frameVariant(c, msg, capability, plain, hasPlain)
+	if asText {
+		msg = plain
+	}
+	msg.Muted = muted
+	data, _ := json.Marshal(localize(msg, c.locale))
+	return data
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 广播分发：hub 为每条广播把接收方分成 -fanout-workers 份，由固定数量的协程并行编码并放入各连接的发送队列，
+// 全部放完后 hub 才处理下一条消息，因此每个连接收到的顺序仍与 hub 的处理顺序一致。
+// 同一条广播中内容相同的帧只生成一个 PreparedMessage，压缩与分帧在各连接间共享。
+// 在线连接较少时并行得不偿失，仍由 hub 协程逐个投递。每次分发的耗时记入最近 fanoutSamples 次的样本，
+// /info 的 fanoutP99Ms 为其 p99
+
+var fanoutWorkers = flag.Int("fanout-workers", 0, "并行分发广播的协程数，在线连接较多时把接收方分给这些协程放入发送队列；1 表示只由 hub 协程逐个投递，0 表示与可用 CPU 数（GOMAXPROCS）相同")
+
+const (
+	fanoutParallelMin = 64   // 在线连接少于此数时不并行
+	fanoutSamples     = 1024 // p99 统计的样本数
+)
+
+// fanoutJob 一条广播中分给一个协程的接收方
+type fanoutJob struct {
+	targets  []*client
+	encode   func(*client) []byte
+	prepared *preparedFrames
+	failed   []*client // 连接已结束，由 hub 随后移出在线列表
+	done     *sync.WaitGroup
+}
+
+var (
+	fanoutQueue chan *fanoutJob // 未启用并行时为 nil
+
+	fanoutMu        sync.Mutex
+	fanoutDurations [fanoutSamples]time.Duration
+	fanoutCount     int
+)
+
+// startFanoutWorkers 启动分发协程，协程数为 1 时不启动：单核上并行只会增加调度开销
+func startFanoutWorkers() {
+	if *fanoutWorkers == 0 {
+		*fanoutWorkers = runtime.GOMAXPROCS(0)
+	}
+	if *fanoutWorkers <= 1 {
+		return
+	}
+	fanoutQueue = make(chan *fanoutJob, *fanoutWorkers)
+	for i := 0; i < *fanoutWorkers; i++ {
+		go func() {
+			for j := range fanoutQueue {
+				j.run()
+				j.done.Done()
+			}
+		}()
+	}
+}
+
+func (j *fanoutJob) run() {
+	for _, c := range j.targets {
+		data := j.encode(c)
+		if data == nil {
+			continue
+		}
+		if c.sendPrepared(data, j.prepared.get(data)) == errConnClosed {
+			j.failed = append(j.failed, c)
+		}
+	}
+}
+
+// preparedFrames 一条广播中已生成的 PreparedMessage，按帧内容的底层数组区分：
+// 编码函数对同一种变体返回同一个切片，见 broadcastEncoder
+type preparedFrames struct {
+	mu sync.Mutex
+	m  map[*byte]*websocket.PreparedMessage
+}
+
+func (p *preparedFrames) get(data []byte) *websocket.PreparedMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pm := p.m[&data[0]]
+	if pm == nil {
+		pm, _ = websocket.NewPreparedMessage(websocket.TextMessage, data)
+		p.m[&data[0]] = pm
+	}
+	return pm
+}
+
+// fanout 在 hub 协程中把一条广播放入所有在线连接的发送队列，返回已结束的连接
+func fanout(encode func(*client) []byte) []*client {
+	start := time.Now()
+	targets := clients.Conns()
+	prepared := &preparedFrames{m: make(map[*byte]*websocket.PreparedMessage)}
+	var failed []*client
+	if fanoutQueue == nil || len(targets) < fanoutParallelMin {
+		j := &fanoutJob{targets: targets, encode: encode, prepared: prepared}
+		j.run()
+		failed = j.failed
+	} else {
+		var wg sync.WaitGroup
+		size := (len(targets) + *fanoutWorkers - 1) / *fanoutWorkers
+		var jobs []*fanoutJob
+		for i := 0; i < len(targets); i += size {
+			jobs = append(jobs, &fanoutJob{targets: targets[i:min(i+size, len(targets))], encode: encode, prepared: prepared, done: &wg})
+		}
+		wg.Add(len(jobs))
+		for _, j := range jobs {
+			fanoutQueue <- j
+		}
+		wg.Wait()
+		for _, j := range jobs {
+			failed = append(failed, j.failed...)
+		}
+	}
+	recordFanout(time.Since(start))
+	return failed
+}
+
+func recordFanout(d time.Duration) {
+	fanoutMu.Lock()
+	fanoutDurations[fanoutCount%fanoutSamples] = d
+	fanoutCount++
+	fanoutMu.Unlock()
+}
+
+// fanoutP99 最近 fanoutSamples 次分发耗时的 p99
+func fanoutP99() time.Duration {
+	fanoutMu.Lock()
+	samples := append([]time.Duration(nil), fanoutDurations[:min(fanoutCount, fanoutSamples)]...)
+	fanoutMu.Unlock()
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[int(math.Ceil(0.99*float64(len(samples))))-1]
+}
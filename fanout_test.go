@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// BenchmarkFanout 一条聊天广播放入 n 个连接发送队列的耗时。
+// sequential 为改动前 hub 协程逐个编码、逐个入队的做法，pool 为 fanout（共享 PreparedMessage，
+// 连接较多且 -fanout-workers 大于 1 时并行），p99-µs 为 fanoutP99 统计的 p99。
+// 连接没有 writePump，每 sendQueueSize 次分发后清空一次队列，不计入耗时
+func BenchmarkFanout(b *testing.B) {
+	modes := []struct {
+		name    string
+		deliver func(encode func(*client) []byte)
+	}{
+		{"sequential", func(encode func(*client) []byte) {
+			for _, c := range clients.Conns() {
+				if data := encode(c); data != nil {
+					c.sendBroadcast(data)
+				}
+			}
+		}},
+		{"pool", func(encode func(*client) []byte) { fanout(encode) }},
+	}
+	for _, n := range []int{100, 500, 1000} {
+		for _, mode := range modes {
+			b.Run(fmt.Sprintf("%s/clients=%d", mode.name, n), func(b *testing.B) {
+				conns := make([]*client, n)
+				saved := clients
+				clients = newRegistry()
+				defer func() { clients = saved }()
+				for i := range conns {
+					c := &client{conn: &websocket.Conn{}, proto: protocolVersion, queue: newSendQueue(), done: make(chan struct{})}
+					c.setRooms([]string{defaultRoom})
+					clients.Add(c, fmt.Sprintf("bench%d", i))
+					conns[i] = c
+				}
+				fanoutMu.Lock()
+				fanoutCount = 0
+				fanoutMu.Unlock()
+
+				msg := WSMessage{Type: "message"}
+				msg.Data.Text = "hello everyone"
+				msg.Data.From = "bench0"
+				msg.Data.Time = time.Now().Format("15:04:05")
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					mode.deliver(broadcastEncoder(msg))
+					if (i+1)%sendQueueSize == 0 {
+						b.StopTimer()
+						for _, c := range conns {
+							for {
+								if _, ok := c.queue.next(); !ok {
+									break
+								}
+							}
+						}
+						b.StartTimer()
+					}
+				}
+				if mode.name == "pool" {
+					b.ReportMetric(float64(fanoutP99().Microseconds()), "p99-µs")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 磁盘文件名策略：对外的 savedName（URL 中使用）保持不变，索引记录其在磁盘上的实际文件名，
+// 便于直接通过 SMB 等方式浏览上传目录
+
+const (
+	StrategyRandom           = "random"
+	StrategyOriginal         = "original"
+	StrategyOriginalSuffixed = "original-suffixed"
+)
+
+var filenameStrategy = flag.String("filename-strategy", StrategyRandom, "磁盘文件命名方式：random | original（保留原名，重名追加 (1)）| original-suffixed（原名加随机后缀）")
+
+var errBadFilename = errors.New("invalid filename")
+
+// Windows 保留设备名，无论扩展名如何都不能作为文件名
+var reservedNames = map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true}
+
+func init() {
+	for i := 1; i <= 9; i++ {
+		reservedNames[fmt.Sprintf("COM%d", i)] = true
+		reservedNames[fmt.Sprintf("LPT%d", i)] = true
+	}
+}
+
+func validFilenameStrategy(s string) bool {
+	return s == StrategyRandom || s == StrategyOriginal || s == StrategyOriginalSuffixed
+}
+
+// sanitizeFilename 校验上传时的原始文件名能否直接用作磁盘文件名
+func sanitizeFilename(name string) (string, error) {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "" || name == "." || name == ".." || isHiddenName(name) || len(name) > 200 {
+		return "", errBadFilename
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(`<>:"/\|?*`, c) {
+			return "", errBadFilename
+		}
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return "", errBadFilename
+	}
+	stem := strings.ToUpper(strings.TrimSuffix(name, filepath.Ext(name)))
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if reservedNames[stem] {
+		return "", errBadFilename
+	}
+	return name, nil
+}
+
+// createUploadFile 按当前策略在上传目录中独占创建文件，返回磁盘文件名
+// （与 savedName 相同时为空，兼容旧索引）
+func createUploadFile(savedName, original string) (string, *os.File, error) {
+	if *filenameStrategy == StrategyRandom {
+		f, err := os.Create(filepath.Join(*uploadDir, savedName))
+		return "", f, err
+	}
+
+	clean, err := sanitizeFilename(original)
+	if err != nil {
+		return "", nil, err
+	}
+	ext := filepath.Ext(clean)
+	stem := strings.TrimSuffix(clean, ext)
+	for i := 0; i < 1000; i++ {
+		var name string
+		switch {
+		case *filenameStrategy == StrategyOriginalSuffixed:
+			name = fmt.Sprintf("%s-%s%s", stem, strings.ToLower(randomCode(6)), ext)
+		case i == 0:
+			name = clean
+		default:
+			name = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		}
+		f, err := os.OpenFile(filepath.Join(*uploadDir, name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		return name, f, err
+	}
+	return "", nil, fmt.Errorf("too many files named %s", clean)
+}
+
+// diskPath 文件在磁盘上的路径
+func (f FileInfo) diskPath() string {
+	name := f.DiskName
+	if name == "" {
+		name = f.SavedName
+	}
+	return filepath.Join(*uploadDir, name)
+}
+
+// resolveDiskPath 把 URL 中的 savedName 映射到磁盘路径；不在索引中的按磁盘文件名处理
+func resolveDiskPath(savedName string) string {
+	filesMu.RLock()
+	info, ok := fileList[savedName]
+	filesMu.RUnlock()
+	if ok {
+		return info.diskPath()
+	}
+	return filepath.Join(*uploadDir, savedName)
+}
+
+// serveUploads /files/{savedName}：已索引的文件按映射路径提供，其余交给目录文件服务。
+// 由 http.ServeContent 处理 Range / If-Range / HEAD 与条件请求：ETag 取内容的 SHA-256（强校验），
+// Last-Modified 取上传时间，Content-Type 按原始文件名推断，下载时保存为原始文件名
+func serveUploads(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filesMu.RLock()
+		info, ok := fileList[r.URL.Path]
+		filesMu.RUnlock()
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		f, err := os.Open(info.diskPath())
+		if err != nil {
+			errFileNotFound(w, r)
+			return
+		}
+		defer f.Close()
+		st, err := f.Stat()
+		if err != nil || st.IsDir() {
+			errFileNotFound(w, r)
+			return
+		}
+		modified := info.Uploaded
+		if modified.IsZero() {
+			modified = st.ModTime()
+		}
+		h := w.Header()
+		if info.SHA256 != "" {
+			h.Set("ETag", `"`+info.SHA256+`"`)
+		}
+		h.Set("Cache-Control", "no-cache")
+		if d := mime.FormatMediaType("inline", map[string]string{"filename": info.Name}); d != "" {
+			h.Set("Content-Disposition", d)
+		}
+		http.ServeContent(w, r, info.Name, modified, f)
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveUploads 的条件请求与断点续传：HEAD、中间一段的 Range、匹配与过期的 If-None-Match、过期的 If-Range
+func TestServeUploadsRangeAndETag(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	sum := sha256.Sum256(content)
+	info := FileInfo{
+		Name:      "report.txt",
+		SavedName: "range-test.txt",
+		DiskName:  "range-test-disk.txt",
+		Size:      int64(len(content)),
+		Uploaded:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+	if err := os.WriteFile(filepath.Join(*uploadDir, info.DiskName), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	filesMu.Lock()
+	fileList[info.SavedName] = info
+	filesMu.Unlock()
+	t.Cleanup(func() {
+		filesMu.Lock()
+		delete(fileList, info.SavedName)
+		filesMu.Unlock()
+		os.Remove(info.diskPath())
+	})
+	etag := `"` + info.SHA256 + `"`
+	handler := serveUploads(http.NotFoundHandler())
+
+	do := func(method string, headers map[string]string) *http.Response {
+		r := httptest.NewRequest(method, "/"+info.SavedName, nil)
+		r.URL.Path = info.SavedName
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result()
+	}
+	get := func(headers map[string]string) *http.Response { return do("GET", headers) }
+	body := func(resp *http.Response) []byte {
+		b, _ := io.ReadAll(resp.Body)
+		return b
+	}
+
+	resp := get(nil)
+	if resp.StatusCode != 200 || resp.Header.Get("ETag") != etag || !bytes.Equal(body(resp), content) {
+		t.Fatalf("plain GET: %d, ETag %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	resp = do("HEAD", nil)
+	h := resp.Header
+	if resp.StatusCode != 200 || h.Get("Content-Length") != "1000" || h.Get("ETag") != etag || h.Get("Accept-Ranges") != "bytes" ||
+		h.Get("Last-Modified") != "Fri, 02 Jan 2026 03:04:05 GMT" || len(body(resp)) != 0 {
+		t.Fatalf("HEAD: %d %v", resp.StatusCode, h)
+	}
+
+	resp = get(map[string]string{"Range": "bytes=100-199"})
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != "bytes 100-199/1000" {
+		t.Fatalf("range: %d, Content-Range %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+	if got := body(resp); !bytes.Equal(got, content[100:200]) {
+		t.Fatalf("range body %q", got)
+	}
+
+	resp = get(map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusNotModified || len(body(resp)) != 0 {
+		t.Fatalf("matching If-None-Match: %d", resp.StatusCode)
+	}
+
+	resp = get(map[string]string{"If-None-Match": `"stale"`})
+	if resp.StatusCode != 200 || !bytes.Equal(body(resp), content) {
+		t.Fatalf("stale If-None-Match: %d", resp.StatusCode)
+	}
+
+	// 客户端手里的版本已过期：忽略 Range，返回整个文件
+	resp = get(map[string]string{"Range": "bytes=100-199", "If-Range": `"stale"`})
+	if resp.StatusCode != 200 || !bytes.Equal(body(resp), content) {
+		t.Fatalf("stale If-Range: %d", resp.StatusCode)
+	}
+}
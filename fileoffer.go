@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"sync"
+	"time"
+)
+
+// 定向发送文件前的握手：发送方发出 file_offer，接收方接受后服务端分配中继会话 ID
+
+var fileOfferTimeout = flag.Duration("file-offer-timeout", 60*time.Second, "文件发送邀请的等待时限")
+
+const (
+	maxPendingOffers   = 5  // 每个用户同时作为发送方/接收方的未决邀请上限
+	maxOffersPerMinute = 10 // 每个用户每分钟最多发出的邀请数
+)
+
+type fileOffer struct {
+	ID      string `json:"offerId"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	timer   *time.Timer
+	created time.Time
+}
+
+var (
+	offers   = make(map[string]*fileOffer) // offerId -> 邀请
+	offersMu sync.Mutex
+
+	// userId -> 最近一分钟内的发出时间，一分钟没有新邀请即过期
+	offerLog = newExpiringMap[string, []time.Time]("offer_rate", time.Minute, registryMaxEntries)
+)
+
+func sendOfferEvent(userID, typ string, data interface{}) {
+	if err := forwardSignal(userID, map[string]interface{}{"type": typ, "data": data}); err != nil {
+		log.Printf("发送 %s 失败: %v", typ, err)
+	}
+}
+
+func init() {
+	registerWSType(wsType{name: "file_offer", rate: rateControl, relayed: true, feature: "file_offer", handle: func(f *wsFrame) { handleFileOffer(f.c.userID, f.raw) }})
+	registerWSType(wsType{name: "file_offer_accept", rate: rateControl, relayed: true, handle: func(f *wsFrame) { handleFileOfferReply(f.c.userID, true, f.raw) }})
+	registerWSType(wsType{name: "file_offer_decline", rate: rateControl, relayed: true, handle: func(f *wsFrame) { handleFileOfferReply(f.c.userID, false, f.raw) }})
+}
+
+// handleFileOffer 处理客户端发来的 file_offer
+func handleFileOffer(from string, raw json.RawMessage) {
+	var req struct {
+		To   string `json:"to"`
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+	fail := func(reason string) {
+		sendOfferEvent(from, "file_offer_error", map[string]string{"error": reason})
+	}
+	if err := json.Unmarshal(raw, &req); err != nil || req.To == "" || req.Name == "" {
+		fail("missing 'to' or 'name'")
+		return
+	}
+	if req.To == from {
+		fail("cannot send a file to yourself")
+		return
+	}
+	if req.Size <= 0 || req.Size > maxSize.Load() {
+		fail("invalid size or file too large")
+		return
+	}
+	if !clients.Online(req.To) {
+		fail("target user not online")
+		return
+	}
+	if overBandwidthCap(from) || overBandwidthCap(req.To) {
+		fail("daily bandwidth cap exceeded, file relay unavailable")
+		return
+	}
+	if !userSupports(req.To, "file_offer") {
+		fail("target client does not support file offers")
+		return
+	}
+
+	offersMu.Lock()
+	now := time.Now()
+	recent := offerLog.Update(from, func(sent []time.Time, _ bool) []time.Time {
+		var kept []time.Time
+		for _, t := range sent {
+			if now.Sub(t) < time.Minute {
+				kept = append(kept, t)
+			}
+		}
+		return kept
+	})
+	if len(recent) >= maxOffersPerMinute {
+		offersMu.Unlock()
+		fail("too many offers, slow down")
+		return
+	}
+	sent, received := 0, 0
+	for _, o := range offers {
+		if o.From == from {
+			sent++
+		}
+		if o.To == req.To {
+			received++
+		}
+	}
+	if sent >= maxPendingOffers || received >= maxPendingOffers {
+		offersMu.Unlock()
+		fail("too many pending offers")
+		return
+	}
+
+	o := &fileOffer{ID: newMessageID(), From: from, To: req.To, Name: req.Name, Size: req.Size, created: now}
+	o.timer = time.AfterFunc(*fileOfferTimeout, func() { expireOffer(o.ID) })
+	offers[o.ID] = o
+	offerLog.Set(from, append(recent, now))
+	offersMu.Unlock()
+
+	payload := map[string]interface{}{
+		"offerId":   o.ID,
+		"from":      o.From,
+		"to":        o.To,
+		"name":      o.Name,
+		"size":      o.Size,
+		"expiresIn": int(fileOfferTimeout.Seconds()),
+	}
+	sendOfferEvent(o.To, "file_offer", payload)
+	sendOfferEvent(o.From, "file_offer_sent", payload)
+}
+
+// handleFileOfferReply 处理接收方的 file_offer_accept / file_offer_decline
+func handleFileOfferReply(userID string, accept bool, raw json.RawMessage) {
+	var req struct {
+		OfferID string `json:"offerId"`
+	}
+	json.Unmarshal(raw, &req)
+
+	offersMu.Lock()
+	o, ok := offers[req.OfferID]
+	if ok && o.To == userID {
+		delete(offers, o.ID)
+		o.timer.Stop()
+	}
+	offersMu.Unlock()
+	if !ok || o.To != userID {
+		sendOfferEvent(userID, "file_offer_error", map[string]string{"error": "offer not found or expired", "offerId": req.OfferID})
+		return
+	}
+
+	if !accept {
+		sendOfferEvent(o.From, "file_offer_declined", map[string]string{"offerId": o.ID, "by": userID})
+		return
+	}
+	// 双方优先尝试 WebRTC，连不通时发送方可用同一 sessionId 改走服务端中继，见 relay.go
+	sessionID := newMessageID()
+	offerRelay(sessionID, o)
+	session := map[string]interface{}{
+		"offerId":   o.ID,
+		"sessionId": sessionID,
+		"from":      o.From,
+		"to":        o.To,
+		"name":      o.Name,
+		"size":      o.Size,
+	}
+	sendOfferEvent(o.From, "file_session", session)
+	sendOfferEvent(o.To, "file_session", session)
+}
+
+func expireOffer(id string) {
+	offersMu.Lock()
+	o, ok := offers[id]
+	delete(offers, id)
+	offersMu.Unlock()
+	if !ok {
+		return
+	}
+	ev := map[string]string{"offerId": o.ID}
+	sendOfferEvent(o.From, "file_offer_expired", ev)
+	sendOfferEvent(o.To, "file_offer_expired", ev)
+}
+
+// cancelOffersFor 用户离线时撤销与其相关的邀请并通知另一方
+func cancelOffersFor(userID string) {
+	var gone []*fileOffer
+	offersMu.Lock()
+	for id, o := range offers {
+		if o.From == userID || o.To == userID {
+			o.timer.Stop()
+			delete(offers, id)
+			gone = append(gone, o)
+		}
+	}
+	offerLog.Delete(userID)
+	offersMu.Unlock()
+
+	for _, o := range gone {
+		other := o.From
+		if other == userID {
+			other = o.To
+		}
+		sendOfferEvent(other, "file_offer_cancelled", map[string]string{"offerId": o.ID, "reason": "user offline"})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// 客户端握手：连接建立后客户端可以先发一帧
+// {"type":"hello","data":{"client":"web/2.1","caps":["typing","presence","resync"]}}，
+// 声明自身名称与支持的能力（取值同 init 中的 features）。服务端记录后只向该连接广播它声明过的事件，
+// 并回一帧 hello 列出采纳的能力与不认识的能力。hello 必须是连接上的第一帧，之后再发返回 hello_too_late；
+// 从不发 hello 的旧客户端保持原行为，按协议版本决定收哪些事件。
+// init 在收到 hello 之前就已下发，hello 只影响之后的广播
+
+const (
+	maxHelloClientLen = 64 // client 字段的最大长度（字符）
+	maxHelloCaps      = 64
+)
+
+// helloEvents 能力对应的广播事件，未列出的能力对应同名事件
+var helloEvents = map[string][]string{
+	"typing": {"typing", "typing_stop"},
+	"nick":   {"rename"},
+	"delete": {"edit"}, // 删除以 edit 通知
+}
+
+// clientHello 连接声明的名称与能力，设置后不再修改
+type clientHello struct {
+	client string
+	caps   []string        // 采纳的能力，按名称排序
+	events map[string]bool // caps 对应的广播事件
+}
+
+type helloRequest struct {
+	Client string   `json:"client"`
+	Caps   []string `json:"caps"`
+}
+
+func init() {
+	registerWSType(wsType{
+		name:    "hello",
+		payload: func() interface{} { return new(helloRequest) },
+		rate:    rateControl,
+		handle:  handleHello,
+	})
+}
+
+// handleHello 记录客户端声明的能力并回一帧 hello
+func handleHello(f *wsFrame) {
+	c := f.c
+	if c.framesIn.Load() != 1 || c.hello.Load() != nil {
+		sendWSError(c, "hello", "hello_too_late", "hello must be the first message on the connection", nil)
+		return
+	}
+	req := f.payload.(*helloRequest)
+	name := normalizeName(req.Client)
+	if utf8.RuneCountInString(name) > maxHelloClientLen {
+		sendWSError(c, "hello", "invalid_payload", "client name is too long", map[string]interface{}{"maxLength": maxHelloClientLen})
+		return
+	}
+	if len(req.Caps) > maxHelloCaps {
+		sendWSError(c, "hello", "invalid_payload", "too many caps", map[string]interface{}{"maxCaps": maxHelloCaps})
+		return
+	}
+
+	known := make(map[string]bool)
+	for _, feat := range protocolFeatures() {
+		known[feat] = true
+	}
+	h := &clientHello{client: name, caps: []string{}, events: make(map[string]bool)}
+	ignored := []string{}
+	seen := make(map[string]bool)
+	for _, cp := range req.Caps {
+		if seen[cp] {
+			continue
+		}
+		seen[cp] = true
+		if !known[cp] {
+			ignored = append(ignored, cp)
+			continue
+		}
+		h.caps = append(h.caps, cp)
+		h.events[cp] = true
+		for _, ev := range helloEvents[cp] {
+			h.events[ev] = true
+		}
+	}
+	sort.Strings(h.caps)
+	c.hello.Store(h)
+
+	c.send(mustMarshal(map[string]interface{}{
+		"type": "hello",
+		"data": map[string]interface{}{"proto": c.proto, "caps": h.caps, "ignored": ignored},
+	}))
+}
+
+// helloCaps 连接通过 hello 声明的名称与能力，未发 hello 时 ok 为 false
+func (c *client) helloCaps() (name string, caps []string, ok bool) {
+	h := c.hello.Load()
+	if h == nil {
+		return "", nil, false
+	}
+	return h.client, h.caps, true
+}
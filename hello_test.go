@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// 广播按连接的能力过滤：没发 hello 的旧客户端按协议版本，发过 hello 的只收声明过的事件。
+// 观察者看另一个用户上线、输入并发言，记录收到的 presence、typing 与用户列表格式
+func TestHelloFanout(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		caps       []string // nil 表示不发 hello
+		wantEvents bool     // 收到 presence 与 typing
+		structured bool     // 用户列表为结构化对象数组，否则为逗号分隔的 text
+	}{
+		{name: "v1 without hello", query: "proto=1"},
+		{name: "v2 without hello", query: "proto=2", wantEvents: true, structured: true},
+		{name: "v2 hello without caps", query: "proto=2", caps: []string{}},
+		{name: "v1 hello with caps", query: "proto=1", caps: []string{"typing", "presence", "users_list"}, wantEvents: true, structured: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, nil)
+			observer := dialWS(t, srv, fmt.Sprintf("uid=hello-obs%d&%s", i, tt.query))
+			if tt.caps != nil {
+				observer.sendJSON(map[string]interface{}{"type": "hello", "data": map[string]interface{}{"client": "test/1", "caps": tt.caps}})
+				observer.expect("hello")
+			}
+			actorID := fmt.Sprintf("hello-act%d", i)
+			actor := dialWS(t, srv, "uid="+actorID)
+
+			// presence 在上线的同一轮 hub 处理中发出，先于合并后的用户列表
+			var structured bool
+			seen := readUntil(observer, func(m map[string]interface{}) bool {
+				if m["type"] != "users" {
+					return false
+				}
+				ids, isStructured := usersFrameIDs(m)
+				structured = isStructured
+				return slices.Contains(ids, actorID)
+			})
+			actor.sendJSON(map[string]interface{}{"type": "typing", "data": map[string]string{}})
+			actor.sendJSON(map[string]interface{}{"type": "message", "data": map[string]string{"text": "hello-marker"}})
+			seen = append(seen, readUntil(observer, func(m map[string]interface{}) bool { return chatText(m) == "hello-marker" })...)
+
+			for _, typ := range []string{"presence", "typing"} {
+				got := slices.ContainsFunc(seen, func(m map[string]interface{}) bool { return m["type"] == typ })
+				if got != tt.wantEvents {
+					t.Errorf("received %s: %v, want %v", typ, got, tt.wantEvents)
+				}
+			}
+			if structured != tt.structured {
+				t.Errorf("structured users list %v, want %v", structured, tt.structured)
+			}
+		})
+	}
+}
+
+// usersFrameIDs users 事件中的用户 id 与是否为结构化格式
+func usersFrameIDs(m map[string]interface{}) (ids []string, structured bool) {
+	data, _ := m["data"].(map[string]interface{})
+	if users, ok := data["users"].([]interface{}); ok {
+		for _, u := range users {
+			id, _ := u.(map[string]interface{})["id"].(string)
+			ids = append(ids, id)
+		}
+		return ids, true
+	}
+	text, _ := data["text"].(string)
+	return strings.Split(text, ","), false
+}
+
+// readUntil 读到满足 stop 的一帧为止，返回读到的全部帧
+func readUntil(tc *testConn, stop func(map[string]interface{}) bool) []map[string]interface{} {
+	tc.t.Helper()
+	var frames []map[string]interface{}
+	for {
+		m, err := tc.next()
+		if err != nil {
+			tc.t.Fatalf("read: %v", err)
+		}
+		frames = append(frames, m)
+		if stop(m) {
+			return frames
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// 代码预览：文本文件按扩展名（或 ?language=）识别语言，在服务端渲染为带行号的高亮 HTML，
+// GET /api/files/{savedName}/preview?format=html。只做关键字、字符串、注释、数字四类着色，
+// 不认识的语言按纯文本转义输出；超过 previewMaxBytes 的部分不渲染
+
+const previewMaxBytes = 256 << 10
+
+type language struct {
+	name         string
+	exts         string // 空格分隔的扩展名
+	keywords     map[string]bool
+	lineComment  []string
+	blockComment [2]string
+	quotes       string
+}
+
+func keywordSet(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, k := range strings.Fields(s) {
+		m[k] = true
+	}
+	return m
+}
+
+var languages = []*language{
+	{name: "go", exts: ".go", lineComment: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'`",
+		keywords: keywordSet("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota")},
+	{name: "javascript", exts: ".js .mjs .cjs .jsx .ts .tsx", lineComment: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'`",
+		keywords: keywordSet("async await break case catch class const continue default delete do else export extends finally for from function if import in instanceof interface let new null of return static super switch this throw true false try type typeof undefined var void while yield")},
+	{name: "python", exts: ".py", lineComment: []string{"#"}, quotes: "\"'",
+		keywords: keywordSet("and as assert async await break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return self True try while with yield")},
+	{name: "c", exts: ".c .h .cc .cpp .hpp", lineComment: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'",
+		keywords: keywordSet("auto bool break case char class const continue default delete do double else enum extern false float for goto if include define inline int long namespace new nullptr private protected public return short signed sizeof static struct switch template this true typedef union unsigned using virtual void volatile while")},
+	{name: "java", exts: ".java .kt", lineComment: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'",
+		keywords: keywordSet("abstract boolean break byte case catch char class continue default do double else enum extends false final finally float for if implements import instanceof int interface long new null package private protected public return short static super switch synchronized this throw throws true try var void while fun val when object")},
+	{name: "rust", exts: ".rs", lineComment: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"",
+		keywords: keywordSet("as async await break const continue crate else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while")},
+	{name: "shell", exts: ".sh .bash .zsh", lineComment: []string{"#"}, quotes: "\"'",
+		keywords: keywordSet("case do done elif else esac export fi for function if in local return then until while")},
+	{name: "sql", exts: ".sql", lineComment: []string{"--"}, blockComment: [2]string{"/*", "*/"}, quotes: "'\"",
+		keywords: keywordSet("select from where insert into values update set delete create table drop alter index primary key foreign references not null and or join left right inner outer on group by order having limit as distinct union SELECT FROM WHERE INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER INDEX PRIMARY KEY FOREIGN REFERENCES NOT NULL AND OR JOIN LEFT RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT AS DISTINCT UNION")},
+	{name: "json", exts: ".json", quotes: "\"", keywords: keywordSet("true false null")},
+	{name: "yaml", exts: ".yaml .yml", lineComment: []string{"#"}, quotes: "\"'", keywords: keywordSet("true false null yes no on off")},
+	{name: "css", exts: ".css", blockComment: [2]string{"/*", "*/"}, quotes: "\"'", keywords: keywordSet("important media import from to")},
+}
+
+var languagesByExt = func() map[string]*language {
+	m := make(map[string]*language)
+	for _, l := range languages {
+		for _, ext := range strings.Fields(l.exts) {
+			m[ext] = l
+		}
+	}
+	return m
+}()
+
+// languageFor 先看显式指定的语言名（或扩展名），再看文件扩展名；不认识返回 nil
+func languageFor(name, explicit string) *language {
+	if explicit != "" {
+		explicit = strings.ToLower(explicit)
+		for _, l := range languages {
+			if l.name == explicit {
+				return l
+			}
+		}
+		if l := languagesByExt["."+explicit]; l != nil {
+			return l
+		}
+	}
+	return languagesByExt[strings.ToLower(filepath.Ext(name))]
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= 0x80 || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+// highlightLines 把源码切成已转义的 HTML 行；跨行的注释和字符串在每行末尾闭合 span
+func highlightLines(src string, lang *language) []string {
+	var lines []string
+	var cur strings.Builder
+	emit := func(class, text string) {
+		for j, part := range strings.Split(text, "\n") {
+			if j > 0 {
+				lines = append(lines, cur.String())
+				cur.Reset()
+			}
+			if part == "" {
+				continue
+			}
+			if class == "" {
+				cur.WriteString(html.EscapeString(part))
+			} else {
+				fmt.Fprintf(&cur, `<span class="%s">%s</span>`, class, html.EscapeString(part))
+			}
+		}
+	}
+
+	i := 0
+scan:
+	for i < len(src) {
+		if lang == nil {
+			emit("", src)
+			break
+		}
+		rest := src[i:]
+		for _, lc := range lang.lineComment {
+			if strings.HasPrefix(rest, lc) {
+				end := strings.IndexByte(rest, '\n')
+				if end < 0 {
+					end = len(rest)
+				}
+				emit("c", rest[:end])
+				i += end
+				continue scan
+			}
+		}
+		if open := lang.blockComment[0]; open != "" && strings.HasPrefix(rest, open) {
+			end := strings.Index(rest[len(open):], lang.blockComment[1])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += len(open) + len(lang.blockComment[1])
+			}
+			emit("c", rest[:end])
+			i += end
+			continue
+		}
+		b := src[i]
+		switch {
+		case strings.IndexByte(lang.quotes, b) >= 0:
+			j := 1
+			for j < len(rest) {
+				if rest[j] == '\\' && b != '`' {
+					j += 2
+					continue
+				}
+				if rest[j] == b {
+					j++
+					break
+				}
+				if rest[j] == '\n' && b != '`' {
+					break
+				}
+				j++
+			}
+			j = min(j, len(rest))
+			emit("s", rest[:j])
+			i += j
+		case '0' <= b && b <= '9' && (i == 0 || !isIdentByte(src[i-1])):
+			j := 1
+			for j < len(rest) && (isIdentByte(rest[j]) || rest[j] == '.') {
+				j++
+			}
+			emit("n", rest[:j])
+			i += j
+		case isIdentByte(b):
+			j := 1
+			for j < len(rest) && isIdentByte(rest[j]) {
+				j++
+			}
+			if lang.keywords[rest[:j]] {
+				emit("k", rest[:j])
+			} else {
+				emit("", rest[:j])
+			}
+			i += j
+		default:
+			emit("", rest[:1])
+			i++
+		}
+	}
+	if cur.Len() > 0 || !strings.HasSuffix(src, "\n") {
+		lines = append(lines, cur.String())
+	}
+	return lines
+}
+
+// highlightCSS 预览页与分享页共用
+const highlightCSS = `
+.hl { text-align: left; font: 13px/1.5 ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; background: #fff; border: 1px solid #e5e5e5; border-radius: 8px; overflow: auto; }
+.hl .meta { font-family: -apple-system, BlinkMacSystemFont, sans-serif; color: #666; padding: 8px 12px; border-bottom: 1px solid #eee; }
+.hl table { border-collapse: collapse; }
+.hl td { padding: 0 12px; vertical-align: top; white-space: pre; }
+.hl td.ln { color: #aaa; text-align: right; user-select: none; border-right: 1px solid #eee; }
+.hl .k { color: #a626a4; } .hl .s { color: #50a14f; } .hl .c { color: #a0a1a7; font-style: italic; } .hl .n { color: #986801; }
+`
+
+// renderHighlight 生成高亮片段；truncated 时在标题栏注明
+func renderHighlight(src string, lang *language, truncated bool) template.HTML {
+	lines := highlightLines(src, lang)
+	name := "text"
+	if lang != nil {
+		name = lang.name
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<div class="hl"><div class="meta">%s · %d 行`, name, len(lines))
+	if truncated {
+		fmt.Fprintf(&b, " · 仅显示前 %s", humanSize(previewMaxBytes))
+	}
+	b.WriteString("</div><table>")
+	for i, line := range lines {
+		fmt.Fprintf(&b, `<tr><td class="ln">%d</td><td>%s</td></tr>`, i+1, line)
+	}
+	b.WriteString("</table></div>")
+	return template.HTML(b.String())
+}
+
+// readTextPreview 读取文件开头至多 previewMaxBytes 字节；含 NUL 或不是 UTF-8 的视为二进制文件
+func readTextPreview(path string) (text string, truncated, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, previewMaxBytes+1))
+	if err != nil {
+		return "", false, false
+	}
+	if len(data) > previewMaxBytes {
+		data, truncated = data[:previewMaxBytes], true
+		// 截在最后一个完整的行，避免切断多字节字符
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			data = data[:i+1]
+		}
+		for len(data) > 0 && !utf8.Valid(data) {
+			data = data[:len(data)-1]
+		}
+	}
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return "", false, false
+	}
+	return string(data), truncated, true
+}
+
+var previewTmpl = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+  <title>{{.Name}}</title>
+  <style>body { margin: 0; padding: 16px; background: #fafafa; } {{.CSS}}</style>
+</head>
+<body>{{.Body}}</body>
+</html>
+`))
+
+// setPreviewHeaders 预览页不执行任何脚本，只允许内联样式
+func setPreviewHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'; frame-ancestors 'self'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+}
+
+// filePreviewHandler GET /api/files/{savedName}/preview?format=html[&language=go]
+func filePreviewHandler(w http.ResponseWriter, r *http.Request, savedName string) {
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" && format != "html" {
+		writeError(w, r, http.StatusBadRequest, "unsupported_format", "Unsupported preview format", map[string]interface{}{"formats": []string{"html"}})
+		return
+	}
+	info, ok := lookupFile(savedName)
+	if !ok {
+		errFileNotFound(w, r)
+		return
+	}
+	text, truncated, ok := readTextPreview(info.diskPath())
+	if !ok {
+		writeError(w, r, http.StatusUnsupportedMediaType, "not_text", "File is not a text file", nil)
+		return
+	}
+
+	var buf bytes.Buffer
+	err := previewTmpl.Execute(&buf, map[string]interface{}{
+		"Name": info.Name,
+		"CSS":  template.CSS(highlightCSS),
+		"Body": renderHighlight(text, languageFor(info.Name, r.URL.Query().Get("language")), truncated),
+	})
+	if err != nil {
+		errServer(w, r)
+		return
+	}
+	setPreviewHeaders(w)
+	w.Write(buf.Bytes())
+}
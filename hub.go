@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 连接中枢：由唯一的 hub 协程负责注册、注销和全部出站消息的分发。
+// 在线连接表 clients 只由 hub 登记与移除（同时持 identityMu，见 registry.go），其他协程可随时查询；
+// 分发只是把帧放进各连接的发送队列，真正的网络写由各自的 writePump 完成，慢连接拖不住别人。
+// 所有广播（/send、系统提示、文件事件等）都经由这一个协程，按到达顺序编号后依次入队，因此每个连接看到的广播顺序一致。
+// 不属于房间的广播用全局的 seq 编号，发给所有连接；房间内的消息只发给成员，改用该房间自己的 roomSeq 编号，
+// 不在房间中的连接因此不会看到序号空缺。两种序号各自连续递增，只有发送队列满而丢帧时才会出现空缺，可经 resync 补发（见 resync.go）
+
+type registration struct {
+	c       *client
+	resume  *websocket.Conn               // resume 令牌对应的旧连接，见 takeover.go
+	session string                        // 加入同一用户已有连接的 sessionKey，见 tabs.go
+	welcome func(c *client, resumed bool) // 注册后立即调用，保证 init 是该连接收到的第一帧
+	exact   bool                          // 通过 ?name= 声明的名字：被占用时拒绝，而不是改为随机分配，见 names.go
+	reply   chan registered
+}
+
+type registered struct {
+	old      *client // 被顶替的旧连接
+	resumed  bool    // 在断线保留期内恢复了原身份，见 takeover.go
+	attached bool    // 加入了同一用户已在线的其他连接，见 tabs.go
+	taken    bool    // exact 声明的名字已被占用，连接未注册
+	count    int     // 在线用户数
+}
+
+type unregistration struct {
+	c     *client
+	reply chan departed
+}
+
+type departed struct {
+	superseded bool // 身份已由新连接继承
+	lingering  bool // 身份在断线保留期内，暂不算离线
+	remaining  bool // 同一用户仍有其他连接，不算离线
+	count      int  // 在线用户数
+}
+
+type outbound struct {
+	ctx    context.Context
+	to     string               // 定向发送的目标 userId，为空表示广播
+	conn   *websocket.Conn      // 定向发送时只发给该用户的这个连接，为空表示全部连接
+	msg    WSMessage            // 广播内容，记入最近消息；encode 为 nil 时按它编码
+	encode func(*client) []byte // 每个接收方的帧，返回 nil 表示不发给该连接
+	reply  chan error           // 定向发送时回报目标是否在线
+	synced chan struct{}        // 非空时不发送任何内容，只表示此前排队的消息都已分发
+}
+
+var (
+	hubSeq     uint64                    // 最近一次不属于房间的广播的序号，只由 hub 协程读写
+	hubRoomSeq = make(map[string]uint64) // 各房间最近一条消息的 roomSeq，只由 hub 协程读写
+
+	hubRegister   = make(chan registration)
+	hubUnregister = make(chan unregistration)
+	hubOutbound   = make(chan outbound, 256)
+)
+
+func runHub() {
+	for {
+		select {
+		case reg := <-hubRegister:
+			reg.reply <- hubAdd(reg)
+		case u := <-hubUnregister:
+			u.reply <- hubRemove(u.c)
+		case out := <-hubOutbound:
+			hubDeliver(out)
+		case e := <-hubExpire:
+			e.reply <- hubExpireGrace(e)
+		case r := <-hubResync:
+			hubReplay(r)
+		}
+		flushPresence()
+	}
+}
+
+// hubAdd 注册连接：resume 的旧连接仍在线时原子地顶替它，处于断线保留期时恢复其身份，
+// 带有效 sessionKey 时加入该用户已有的连接，否则同名或未指定时分配随机 userId（exact 声明的名字被占用时拒绝）
+func hubAdd(reg registration) registered {
+	c := reg.c
+	id := c.userID // 请求的 userId，同名或未指定时改为随机分配，登记时写回 c.userID
+	var pending []func(*client) []byte
+	identityMu.Lock()
+	old := clients.ByConn(reg.resume)
+	l := lingering[id]
+	session := validSessionKey(id, reg.session)
+	resumed, attached := false, false
+	switch {
+	// 旧连接可能已自行断开，或已被另一个新连接抢先接管
+	case reg.resume != nil && old != nil && old.userID == id:
+		old.superseded = true
+		clients.Remove(reg.resume)
+		c.setRooms(old.roomList())
+	case l != nil && (reg.resume != nil && l.conn == reg.resume || session):
+		old, resumed, pending = nil, true, l.pending
+		c.setRooms(l.rooms)
+		l.timer.Stop()
+		delete(lingering, id)
+	case session && len(clients.ByID(id)) < *maxConnsPerUser:
+		old, attached = nil, true
+	default:
+		old = nil
+		switch {
+		case reg.exact && (nameTaken(id) || nickTaken("", id)):
+			identityMu.Unlock()
+			return registered{taken: true}
+		case id == "" || nameTaken(id):
+			id = newUserID()
+		}
+	}
+	assignColor(id)
+	claimNick(id)
+	clients.Add(c, id)
+	count := clients.Users()
+	// 接管、在保留期内恢复或加入已有连接时身份不变，不算上线
+	if old == nil && !resumed && !attached {
+		queuePresence("join", c.userID)
+	}
+	identityMu.Unlock()
+
+	if reg.welcome != nil {
+		reg.welcome(c, old != nil || resumed)
+	}
+	if old != nil {
+		inheritQueue(old, c)
+	}
+	for _, encode := range pending {
+		if data := encode(c); data != nil {
+			c.send(data)
+		}
+	}
+	return registered{old: old, resumed: resumed, attached: attached, count: count}
+}
+
+// hubRemove 可重复调用：连接写失败时由 hub 先行移除，读循环退出时再确认一次
+func hubRemove(c *client) departed {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	if c.superseded {
+		return departed{superseded: true, count: clients.Users()}
+	}
+	if _, ok := clients.Remove(c.conn); ok {
+		c.departedCount = clients.Users()
+		switch {
+		case clients.Online(c.userID):
+			// 其他连接仍在线：该连接不进入保留期，重连时凭 sessionKey 加入
+			c.departedShared = true
+			resumeTokens.Delete(c.resumeToken)
+		case !startGrace(c):
+			resumeTokens.Delete(c.resumeToken)
+			userSessions.Delete(c.userID)
+			queuePresence("leave", c.userID)
+		}
+	}
+	l := lingering[c.userID]
+	return departed{lingering: l != nil && l.conn == c.conn, remaining: c.departedShared, count: c.departedCount}
+}
+
+// hubDeliver 在 hub 协程中执行；hub 是 lingering 的唯一修改者，读取无需加锁
+func hubDeliver(out outbound) {
+	if out.synced != nil {
+		close(out.synced)
+		return
+	}
+	if out.to != "" {
+		conns := clients.ByID(out.to)
+		// 断线保留期内的用户：暂存，恢复后补发
+		if l := lingering[out.to]; len(conns) == 0 && out.conn == nil && l != nil && len(l.pending) < maxPendingDirect {
+			l.pending = append(l.pending, out.encode)
+			out.reply <- nil
+			return
+		}
+		// 发给该用户的全部连接（指定 conn 时只发给它），有一个送达即算成功
+		err := fmt.Errorf("target user %s not found", out.to)
+		for _, c := range conns {
+			if out.conn != nil && c.conn != out.conn {
+				continue
+			}
+			e := c.send(out.encode(c))
+			if e == errConnClosed {
+				hubRemove(c)
+			}
+			if err != nil {
+				err = e
+			}
+		}
+		out.reply <- err
+		return
+	}
+
+	_, span := tracer.Start(out.ctx, "broadcast", trace.WithAttributes(attribute.String("message.type", out.msg.Type), attribute.Int("clients", clients.Count())))
+	defer span.End()
+	out.msg.Category = out.msg.category()
+	if out.msg.Data.ID == "" {
+		out.msg.Data.ID = newMessageID()
+	}
+	if out.msg.Data.At == 0 {
+		out.msg.Data.At = time.Now().UnixMilli()
+	}
+	if out.msg.Data.Color == "" {
+		out.msg.Data.Color = colorOf(out.msg.Data.From)
+	}
+	if !delivers(destWebSocket, out.msg.Category) {
+		rememberMessage(out.msg)
+		return
+	}
+	// 推送给 WebSocket 的广播依次编号并留作补发，房间内的消息按房间编号；
+	// 自带编码的（用户列表、输入提示等瞬时状态）不编号
+	encode := out.encode
+	if encode == nil {
+		if room := out.msg.Data.Room; room != "" {
+			hubRoomSeq[room]++
+			out.msg.RoomSeq = hubRoomSeq[room]
+		} else {
+			hubSeq++
+			out.msg.Seq = hubSeq
+		}
+		rememberReplay(out.msg)
+		encode = broadcastEncoder(out.msg)
+	}
+	rememberMessage(out.msg)
+	// 写失败或接收过慢被断开的连接立即移出在线列表，离线提示在本轮结束后发出；
+	// 队列满而丢弃的帧已计入 droppedFrames，见 writer.go
+	for _, c := range fanout(encode) {
+		hubRemove(c)
+	}
+}
+
+// broadcastEncoder 按是否降级为纯文本、是否静音与接收方的显示格式区分帧，每种只编码一次
+func broadcastEncoder(msg WSMessage) func(*client) []byte {
+	capability, plain, hasPlain := plainTextFor(msg, defaultLocale)
+	type variant struct {
+		asText, muted bool
+		locale        *locale
+	}
+	type encoded struct {
+		once  sync.Once
+		frame []byte
+	}
+	frames := make(map[variant]*encoded)
+	var mu sync.Mutex // 并行分发时各协程共用缓存，只保护查找，编码在锁外进行，见 fanout.go
+	return func(c *client) []byte {
+		if msg.Data.Room != "" && !c.inRoom(msg.Data.Room) {
+			return nil
+		}
+		asText, muted, ok := frameVariant(c, msg, capability, plain, hasPlain)
+		if !ok {
+			return nil
+		}
+		v := variant{asText, muted, c.locale}
+		mu.Lock()
+		e := frames[v]
+		if e == nil {
+			e = new(encoded)
+			frames[v] = e
+		}
+		mu.Unlock()
+		e.once.Do(func() {
+			m := msg
+			if asText {
+				_, m, _ = plainTextFor(msg, c.locale)
+			}
+			m.Muted = muted
+			e.frame, _ = json.Marshal(localize(m, c.locale))
+		})
+		return e.frame
+	}
+}
+
+func broadcast(msg WSMessage) {
+	broadcastCtx(context.Background(), msg)
+}
+
+// broadcastCtx 同 broadcast，span 挂在调用方的链路下
+func broadcastCtx(ctx context.Context, msg WSMessage) {
+	hubOutbound <- outbound{ctx: ctx, msg: msg}
+}
+
+// hubSync 等待此前排队的广播与定向消息都已放入各连接的发送队列
+func hubSync() {
+	synced := make(chan struct{})
+	hubOutbound <- outbound{synced: synced}
+	<-synced
+}
+
+// sendTo 定向发给某个在线用户，encode 为该连接生成帧；用户不在线时返回错误
+func sendTo(userID string, encode func(*client) []byte) error {
+	reply := make(chan error, 1)
+	hubOutbound <- outbound{ctx: context.Background(), to: userID, encode: encode, reply: reply}
+	return <-reply
+}
+
+func forwardSignal(toUserId string, payload interface{}) error {
+	data, _ := json.Marshal(payload)
+	return sendTo(toUserId, func(*client) []byte { return data })
+}
+
+// replyTo 经 hub 只回给这一个连接，排在此前交给 hub 的广播之后（如聊天消息的 ack）
+func replyTo(c *client, payload interface{}) {
+	data, _ := json.Marshal(payload)
+	reply := make(chan error, 1)
+	hubOutbound <- outbound{ctx: context.Background(), to: c.userID, conn: c.conn, encode: func(*client) []byte { return data }, reply: reply}
+	<-reply
+}
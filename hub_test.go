@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 对端的 TCP 已失效但读循环还没察觉（半开连接）：下一次广播写失败时移出在线列表，离线只广播一次
+func TestDeadConnectionDroppedOnBroadcast(t *testing.T) {
+	srv, accepted := newFaultServer(t, map[string]http.HandlerFunc{"/send": sendHandler})
+	observer := dialWS(t, srv, "uid=observer")
+	<-accepted
+	dialWS(t, srv, "uid=ghost")
+	ghost := <-accepted
+	if n := clients.Users(); n != 2 {
+		t.Fatalf("online users = %d, want 2", n)
+	}
+	left := func(m map[string]interface{}) bool {
+		return strings.Contains(chatText(m), "用户 ghost 离线")
+	}
+
+	ghost.failWrites.Store(true)
+	post := func(text string) {
+		resp, err := http.Post(srv.URL+"/send", "application/json", strings.NewReader(`{"message":"`+text+`","from":"poster"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	post("first")
+	observer.expectWhere("message", left)
+	if n := clients.Users(); n != 1 {
+		t.Fatalf("online users after broadcast = %d, want 1", n)
+	}
+
+	// 读循环随后退出时不再重复广播离线
+	post("second")
+	for {
+		m, err := observer.next()
+		if err != nil {
+			t.Fatalf("waiting for the second message: %v", err)
+		}
+		if left(m) {
+			t.Fatal("leave broadcast twice")
+		}
+		if chatText(m) == "second" {
+			break
+		}
+	}
+}
+
+// /send 与 WebSocket 的生产者同时发消息：所有消息都经 hub 定序，每个观察者看到的序号与顺序完全一致
+func TestOrderingConcurrentProducers(t *testing.T) {
+	// 每个 WebSocket 生产者的条数不超过聊天消息的突发上限，避免被限速丢弃
+	const producers, perProducer, observers = 5, 20, 4
+	const total = 2 * producers * perProducer
+	srv := newTestServer(t, map[string]http.HandlerFunc{"/send": sendHandler})
+
+	type seen struct {
+		seq  float64
+		text string
+	}
+	results := make([][]seen, observers)
+	var readers sync.WaitGroup
+	for i := range observers {
+		obs := dialWS(t, srv, fmt.Sprintf("uid=obs%d", i))
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for len(results[i]) < total {
+				m, err := obs.next()
+				if err != nil {
+					t.Errorf("obs%d after %d messages: %v", i, len(results[i]), err)
+					return
+				}
+				if text := chatText(m); strings.HasPrefix(text, "ord-") {
+					seq, _ := m["roomSeq"].(float64)
+					results[i] = append(results[i], seen{seq, text})
+				}
+			}
+		}()
+	}
+
+	var wsProducers []*testConn
+	for i := range producers {
+		wsProducers = append(wsProducers, dialWS(t, srv, fmt.Sprintf("uid=ws%d", i)))
+	}
+	var writers sync.WaitGroup
+	for i := range producers {
+		writers.Add(2)
+		go func() {
+			defer writers.Done()
+			for j := range perProducer {
+				body := fmt.Sprintf(`{"message":"ord-http%d-%d","from":"http%d"}`, i, j, i)
+				resp, err := http.Post(srv.URL+"/send", "application/json", strings.NewReader(body))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+			}
+		}()
+		go func() {
+			defer writers.Done()
+			for j := range perProducer {
+				msg := map[string]interface{}{"type": "message", "data": map[string]string{"text": fmt.Sprintf("ord-ws%d-%d", i, j)}}
+				if err := wsProducers[i].conn.WriteJSON(msg); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	writers.Wait()
+	readers.Wait()
+	if t.Failed() {
+		return
+	}
+
+	for i, list := range results {
+		for j := 1; j < len(list); j++ {
+			if list[j].seq <= list[j-1].seq {
+				t.Fatalf("obs%d: seq %v after %v", i, list[j].seq, list[j-1].seq)
+			}
+		}
+		if i == 0 {
+			continue
+		}
+		for j := range list {
+			if list[j] != results[0][j] {
+				t.Fatalf("obs%d saw %v at position %d, obs0 saw %v", i, list[j], j, results[0][j])
+			}
+		}
+	}
+}
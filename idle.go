@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// 空闲断开：超过 -idle-timeout 没有发来任何消息帧（聊天、输入提示、信令等）的连接，
+// 以关闭码 closeIdle（4002）关闭，随后按正常离线清理。心跳是否算作活动由 -idle-include-pings 决定：
+// 浏览器会自动回复 pong，算作活动时只有真正断网的连接才会被清理。网页端收到 4002 后不自动重连，等用户操作
+
+var (
+	idleTimeout      = flag.Duration("idle-timeout", 0, "连接多久没有发来消息即断开（如 24h）；0 表示不断开")
+	idleIncludePings = flag.Bool("idle-include-pings", false, "收到心跳（ping/pong）也算活动，重置空闲计时")
+)
+
+var idleDisconnects atomic.Int64 // 因空闲断开的连接数，供 /info
+
+// heard 收到心跳控制帧，只在 -idle-include-pings 时计入空闲计时
+func (c *client) heard() {
+	if *idleIncludePings {
+		c.lastHeard.Store(time.Now().UnixNano())
+	}
+}
+
+// startIdleTicker 定期检查空闲连接
+func startIdleTicker() {
+	if *idleTimeout <= 0 {
+		return
+	}
+	interval := max(min(*idleTimeout/4, 30*time.Second), time.Second)
+	go func() {
+		for range time.Tick(interval) {
+			closeIdleConns()
+		}
+	}()
+}
+
+func closeIdleConns() {
+	cutoff := time.Now().Add(-*idleTimeout).UnixNano()
+	var idle []*client
+	for _, c := range clients.Conns() {
+		if max(c.lastActive.Load(), c.lastHeard.Load()) < cutoff && c.idleClosing.CompareAndSwap(false, true) {
+			idle = append(idle, c)
+		}
+	}
+
+	for _, c := range idle {
+		idleDisconnects.Add(1)
+		log.Printf("💤 用户 %s 已空闲超过 %s，断开连接", c.userID, *idleTimeout)
+		// 关闭帧排在已排队的消息之后；对方不回应关闭时强制断开，读循环随之退出并按正常离线清理
+		c.sendClose(closeIdle, closeReasons[closeIdle])
+		time.AfterFunc(closeHandshakeWait, c.kill)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 文件索引持久化：原始文件名、短码、变更日志保存在上传目录下的隐藏文件中，重启后恢复
+
+const (
+	indexFileName = ".gochat-index.json"
+	// 短时间内的多次变更（如一次上传 50 个文件）合并为一次写盘
+	indexSaveDelay = 200 * time.Millisecond
+)
+
+type indexData struct {
+	Files        map[string]FileInfo      `json:"files"`
+	RetiredCodes map[string]time.Time     `json:"retiredCodes,omitempty"`
+	Comments     map[string][]FileComment `json:"comments,omitempty"`
+	Stars        map[string][]string      `json:"stars,omitempty"`
+	Journal      journalState             `json:"journal"`
+}
+
+var (
+	saveMu    sync.Mutex
+	saveTimer *time.Timer // 待执行的延迟写盘，由 saveMu 保护
+	// 平滑升级期间旧进程仍可能写索引，新进程在接管前不落盘
+	indexHeld atomic.Bool
+)
+
+func indexPath() string {
+	return filepath.Join(*uploadDir, indexFileName)
+}
+
+// isHiddenName 隐藏文件（索引等）不对外列出、下载或删除
+func isHiddenName(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// hasHiddenSegment 路径中任一段为隐藏名时返回 true
+func hasHiddenSegment(path string) bool {
+	for _, seg := range strings.Split(path, "/") {
+		if isHiddenName(seg) {
+			return true
+		}
+	}
+	return false
+}
+
+// readIndex 读取索引文件；主文件缺失或损坏时退回上一代 .bak
+func readIndex() (indexData, bool) {
+	var idx indexData
+	data, err := os.ReadFile(indexPath())
+	if err == nil {
+		if err = json.Unmarshal(data, &idx); err == nil {
+			return idx, true
+		}
+	}
+	primaryErr := err
+
+	idx = indexData{}
+	data, err = os.ReadFile(indexPath() + ".bak")
+	if err == nil {
+		err = json.Unmarshal(data, &idx)
+	}
+	switch {
+	case err == nil:
+		log.Printf("⚠️  文件索引不可用（%v），已从上一代备份 %s.bak 恢复", primaryErr, indexFileName)
+		return idx, true
+	case os.IsNotExist(primaryErr) && os.IsNotExist(err):
+		// 首次运行
+	default:
+		log.Printf("⚠️  读取文件索引失败: %v", primaryErr)
+	}
+	return indexData{}, false
+}
+
+// loadIndex 启动时读取索引，丢弃磁盘上已不存在的文件
+func loadIndex() {
+	idx, ok := readIndex()
+	if !ok {
+		return
+	}
+
+	filesMu.Lock()
+	for name, info := range idx.Files {
+		if _, err := os.Stat(info.diskPath()); err != nil {
+			continue
+		}
+		fileList[name] = info
+		if info.Code != "" {
+			codeToFile[info.Code] = name
+		}
+		if c := idx.Comments[name]; len(c) > 0 {
+			fileComments[name] = c
+		}
+		for _, u := range idx.Stars[name] {
+			if fileStars[name] == nil {
+				fileStars[name] = make(map[string]bool)
+			}
+			fileStars[name][u] = true
+		}
+	}
+	for code, t := range idx.RetiredCodes {
+		retiredCodes[code] = t
+	}
+	count := len(fileList)
+	filesMu.Unlock()
+
+	restoreJournal(idx.Journal)
+	log.Printf("📂 已恢复文件索引，共 %d 个文件", count)
+}
+
+// saveIndex 安排一次写盘，短时间内的多次调用只写一次
+func saveIndex() {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	if saveTimer == nil {
+		saveTimer = time.AfterFunc(indexSaveDelay, flushIndex)
+	}
+}
+
+// flushIndex 立即把当前索引写回磁盘，退出前调用以免丢失尚未执行的延迟写盘
+func flushIndex() {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	if saveTimer != nil {
+		saveTimer.Stop()
+		saveTimer = nil
+	}
+	if indexHeld.Load() {
+		return
+	}
+
+	filesMu.RLock()
+	idx := indexData{
+		Files:        make(map[string]FileInfo, len(fileList)),
+		RetiredCodes: make(map[string]time.Time, len(retiredCodes)),
+		Comments:     make(map[string][]FileComment, len(fileComments)),
+		Stars:        make(map[string][]string, len(fileStars)),
+	}
+	for k, v := range fileList {
+		idx.Files[k] = v
+	}
+	for k, v := range retiredCodes {
+		idx.RetiredCodes[k] = v
+	}
+	for k, v := range fileComments {
+		idx.Comments[k] = v
+	}
+	for k := range fileStars {
+		idx.Stars[k] = starredBy(k)
+	}
+	filesMu.RUnlock()
+	idx.Journal = snapshotJournal()
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		log.Printf("⚠️  序列化文件索引失败: %v", err)
+		return
+	}
+	if err := writeFileAtomic(indexPath(), data); err != nil {
+		log.Printf("⚠️  保存文件索引失败: %v", err)
+	}
+}
+
+// writeFileAtomic 先写同目录下的临时文件并 fsync，再重命名覆盖，崩溃时不会留下半截文件；
+// 覆盖前把上一代保留为 .bak
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	bak := path + ".bak"
+	os.Remove(bak)
+	os.Link(path, bak)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// 目录也要 fsync，确保重命名本身落盘（Windows 不支持，忽略错误）
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func holdIndex() {
+	indexHeld.Store(true)
+}
+
+// adoptIndex 旧进程退出后合并其最后写入的索引：补上交接期间旧进程完成的上传，
+// 去掉磁盘上已被删除的文件，然后恢复落盘
+func adoptIndex() {
+	idx, _ := readIndex()
+
+	var added, removed []FileInfo
+	filesMu.Lock()
+	for name, info := range idx.Files {
+		if _, ok := fileList[name]; ok {
+			continue
+		}
+		if _, err := os.Stat(info.diskPath()); err != nil {
+			continue
+		}
+		if _, taken := codeToFile[info.Code]; info.Code == "" || taken {
+			info.Code = assignCode(name)
+		} else {
+			codeToFile[info.Code] = name
+		}
+		fileList[name] = info
+		if c := idx.Comments[name]; len(c) > 0 {
+			fileComments[name] = c
+		}
+		for _, u := range idx.Stars[name] {
+			if fileStars[name] == nil {
+				fileStars[name] = make(map[string]bool)
+			}
+			fileStars[name][u] = true
+		}
+		added = append(added, info)
+	}
+	for name, info := range fileList {
+		if _, err := os.Stat(info.diskPath()); os.IsNotExist(err) {
+			delete(fileList, name)
+			delete(fileComments, name)
+			delete(fileStars, name)
+			retireCode(info.Code)
+			removed = append(removed, info)
+		}
+	}
+	filesMu.Unlock()
+
+	for _, info := range added {
+		recordChange(ChangeAdd, info)
+	}
+	for _, info := range removed {
+		recordChange(ChangeDelete, info)
+	}
+	indexHeld.Store(false)
+	flushIndex()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 实例锁：同一上传目录只允许一个 gochat 进程，避免两个进程交替覆盖文件索引。
+// 启动时对上传目录下的 .gochat.lock 加排他锁（Unix 为 flock，Windows 为 LockFileEx）并写入 PID，
+// 进程退出时由系统释放；平滑升级时锁随文件描述符交给新进程
+
+const lockFileName = ".gochat.lock"
+
+var forceUnlock = flag.Bool("force-unlock", false, "锁文件记录的进程已不存在但锁仍无法获取时（如网络文件系统上的残留锁），删除锁文件后重新加锁")
+
+var instanceLock *os.File
+
+func lockPath() string {
+	return filepath.Join(*uploadDir, lockFileName)
+}
+
+// lockHolder 读取锁文件中记录的 PID，读不到返回 0
+func lockHolder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// acquireInstanceLock 获取上传目录的实例锁，失败时直接退出
+func acquireInstanceLock() {
+	if f := inheritedLock(); f != nil {
+		instanceLock = f
+		writeLockPID(f)
+		return
+	}
+	path := lockPath()
+	f, err := tryLock(path)
+	if errors.Is(err, errLocked) && *forceUnlock {
+		pid := lockHolder(path)
+		if pid != 0 && processAlive(pid) {
+			log.Fatalf("❌ 上传目录 %s 正被进程 %d 使用，该进程仍在运行，-force-unlock 不会强行解锁", *uploadDir, pid)
+		}
+		log.Printf("⚠️  删除残留的锁文件 %s（记录的进程 %d 已不存在）", path, pid)
+		if err := os.Remove(path); err != nil {
+			log.Fatalf("❌ 删除锁文件失败: %v", err)
+		}
+		f, err = tryLock(path)
+	}
+	switch {
+	case errors.Is(err, errLocked):
+		log.Fatalf("❌ 上传目录 %s 已被另一个 gochat 进程（pid %d）使用；请先停止该进程或换用其他 -upload-dir，"+
+			"若该进程已不存在可加 -force-unlock 启动", *uploadDir, lockHolder(path))
+	case err != nil:
+		log.Fatalf("❌ 无法创建锁文件 %s: %v", path, err)
+	}
+	instanceLock = f
+	writeLockPID(f)
+}
+
+func writeLockPID(f *os.File) {
+	f.Truncate(0)
+	f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+}
+
+// releaseInstanceLock 关闭锁文件即释放；平滑升级后新进程仍持有同一把锁，不受影响
+func releaseInstanceLock() {
+	if instanceLock != nil {
+		instanceLock.Close()
+		instanceLock = nil
+	}
+}
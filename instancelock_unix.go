@@ -0,0 +1,43 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// 平滑升级时锁文件作为 fd 6 交给新进程：flock 属于打开的文件，旧进程退出后锁仍由新进程持有
+
+const lockFDEnv = "GOCHAT_LOCK_FD"
+
+var errLocked = errors.New("lock is held by another process")
+
+func tryLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func inheritedLock() *os.File {
+	if os.Getenv(lockFDEnv) == "" {
+		return nil
+	}
+	os.Unsetenv(lockFDEnv)
+	return os.NewFile(6, "instance-lock")
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 心跳：writePump 每隔 -ping-interval 发一次 ping，收到 pong 时顺延读超时。
+// 休眠、NAT 超时等静默断开的连接在 -pong-timeout 内没有回应，读循环因超时退出，按正常离线清理。
+// 单条消息超过 -ws-read-limit 时先回一帧 message_too_large，再以关闭码 closeMessageTooLarge 断开连接
+
+var (
+	pingInterval = flag.Duration("ping-interval", 30*time.Second, "服务端发送 WebSocket ping 的间隔，0 表示不发送也不检测")
+	pongTimeout  = flag.Duration("pong-timeout", 75*time.Second, "超过该时长未收到 pong 即断开连接，应大于 -ping-interval")
+
+	wsReadLimit ByteSize = 64 << 10
+)
+
+func init() {
+	flag.Var(&wsReadLimit, "ws-read-limit", "客户端经 WebSocket 发来的单条消息上限（如 64K），超出即断开连接")
+}
+
+var errMessageTooLarge = errors.New("message too large")
+
+// startKeepalive 设置初始读超时与 pong 处理；心跳关闭时不设超时
+func (c *client) startKeepalive() {
+	if *idleIncludePings {
+		// 客户端主动发来的 ping 也算活动，其余同默认处理：立即回 pong
+		c.conn.SetPingHandler(func(data string) error {
+			c.heard()
+			err := c.conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(*writeTimeout))
+			if err == websocket.ErrCloseSent || isTimeout(err) {
+				return nil
+			}
+			return err
+		})
+	}
+	if *pingInterval <= 0 {
+		return
+	}
+	c.conn.SetReadDeadline(time.Now().Add(*pongTimeout))
+	c.conn.SetPongHandler(func(data string) error {
+		c.heard()
+		// ping 携带发送时刻，据此得到往返时间，见 compression.go
+		if sent, err := strconv.ParseInt(data, 10, 64); err == nil {
+			c.compression.rtt.Store(time.Now().UnixNano() - sent)
+		}
+		return c.conn.SetReadDeadline(time.Now().Add(*pongTimeout))
+	})
+}
+
+// readFrame 读一条消息，最多读入 -ws-read-limit 字节，超出时返回 errMessageTooLarge，其余部分不读
+func (c *client) readFrame() (int, []byte, error) {
+	mt, r, err := c.conn.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(wsReadLimit)+1))
+	if err == nil && len(data) > int(wsReadLimit) {
+		return mt, nil, errMessageTooLarge
+	}
+	return mt, data, err
+}
+
+// closeWith 在发送队列末尾排一个关闭帧，然后只等待对方回应关闭（至多 closeHandshakeWait），
+// 期间收到的消息一律丢弃；由读循环在退出前调用
+func (c *client) closeWith(code int) {
+	c.sendClose(code, closeReasons[code])
+	c.conn.SetPongHandler(func(string) error { return nil })
+	c.conn.SetReadDeadline(time.Now().Add(closeHandshakeWait))
+	for {
+		if _, _, err := c.conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+// pingTicker 心跳关闭时返回 nil 通道，select 中永不触发
+func pingTicker() (<-chan time.Time, func()) {
+	if *pingInterval <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(*pingInterval)
+	return t.C, t.Stop
+}
+
+func (c *client) ping() error {
+	now := time.Now()
+	return c.conn.WriteControl(websocket.PingMessage, []byte(strconv.FormatInt(now.UnixNano(), 10)), now.Add(*writeTimeout))
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func checkKeepalive() error {
+	if wsReadLimit <= 0 {
+		return fmt.Errorf("-ws-read-limit must be positive")
+	}
+	if *writeTimeout <= 0 {
+		return fmt.Errorf("-write-timeout must be positive")
+	}
+	if *writeStallTimeout < 0 {
+		return fmt.Errorf("-write-stall-timeout must not be negative")
+	}
+	if *pingInterval < 0 || *pongTimeout < 0 {
+		return fmt.Errorf("-ping-interval and -pong-timeout must not be negative")
+	}
+	if *pingInterval > 0 && *pongTimeout <= *pingInterval {
+		return fmt.Errorf("-pong-timeout (%s) must be longer than -ping-interval (%s)", *pongTimeout, *pingInterval)
+	}
+	return nil
+}
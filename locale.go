@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	langtag "golang.org/x/text/language"
+)
+
+// 本地化显示：按连接握手时的 ?lang= 或 Accept-Language 选择时间与数字格式（12/24 小时制、小数点或逗号）。
+// 只影响给人看的字符串——消息时间、文件大小、时长；JSON 中的数值字段与 at 时间戳（UTC 毫秒）不变。
+// 消息正文的语言不随之变化
+
+type locale struct {
+	tag     langtag.Tag
+	layout  string // 消息时间的格式
+	decimal string // 小数分隔符
+}
+
+// locales 第一项为默认（与改动前的输出一致）
+var locales = []*locale{
+	{langtag.Chinese, "15:04:05", "."},
+	{langtag.English, "3:04:05 PM", "."},
+	{langtag.German, "15:04:05", ","},
+	{langtag.French, "15:04:05", ","},
+	{langtag.Spanish, "15:04:05", ","},
+	{langtag.Russian, "15:04:05", ","},
+	{langtag.Japanese, "15:04:05", "."},
+}
+
+var (
+	defaultLocale = locales[0]
+	localeMatcher = func() langtag.Matcher {
+		tags := make([]langtag.Tag, len(locales))
+		for i, l := range locales {
+			tags[i] = l.tag
+		}
+		return langtag.NewMatcher(tags)
+	}()
+)
+
+// localeFor ?lang= 优先，其次 Accept-Language；都无法匹配时用默认格式
+func localeFor(r *http.Request) *locale {
+	var tags []langtag.Tag
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		if t, err := langtag.Parse(lang); err == nil {
+			tags = append(tags, t)
+		}
+	}
+	accepted, _, _ := langtag.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	tags = append(tags, accepted...)
+	if len(tags) == 0 {
+		return defaultLocale
+	}
+	_, i, conf := localeMatcher.Match(tags...)
+	if conf == langtag.No {
+		return defaultLocale
+	}
+	return locales[i]
+}
+
+func (l *locale) String() string { return l.tag.String() }
+
+func (l *locale) number(format string, v float64) string {
+	return strings.Replace(fmt.Sprintf(format, v), ".", l.decimal, 1)
+}
+
+// size 把字节数格式化为 B/KB/MB/GB，与前端 formatSize 保持一致
+func (l *locale) size(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%d B", n)
+	case n < 1<<20:
+		return l.number("%.1f KB", float64(n)/(1<<10))
+	case n < 1<<30:
+		return l.number("%.1f MB", float64(n)/(1<<20))
+	}
+	return l.number("%.1f GB", float64(n)/(1<<30))
+}
+
+// clock 消息时间，按服务器本地时区显示
+func (l *locale) clock(t time.Time) string {
+	return t.Local().Format(l.layout)
+}
+
+// duration 时长：不足一分钟精确到 0.1 秒，否则精确到分钟，如 5h20m
+func (l *locale) duration(d time.Duration) string {
+	if d < time.Minute {
+		return l.number("%.1fs", d.Seconds())
+	}
+	s := d.Round(time.Minute).String()
+	return strings.TrimSuffix(s, "0s")
+}
+
+// localize 按接收方的格式重写展示字段；没有 at 时间戳的消息保持原样
+func localize(msg WSMessage, l *locale) WSMessage {
+	if msg.Data.At != 0 {
+		msg.Data.Time = l.clock(time.UnixMilli(msg.Data.At))
+	}
+	return msg
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLocaleFor(t *testing.T) {
+	tests := []struct {
+		query, accept string
+		want          string
+	}{
+		{"", "", "zh"},
+		{"", "en-US,en;q=0.9", "en"},
+		{"", "zh-CN,zh;q=0.9,en;q=0.8", "zh"},
+		{"lang=en", "zh-CN", "en"},
+		{"lang=bogus!", "en-GB", "en"},
+		{"", "ko", "zh"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws?"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept-Language", tt.accept)
+		}
+		if got := localeFor(r).String(); got != tt.want {
+			t.Errorf("?%s Accept-Language %q: got %s, want %s", tt.query, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestLocaleFormatting(t *testing.T) {
+	zh, en, de := locales[0], locales[1], locales[2]
+	afternoon := time.Date(2026, 3, 1, 15, 4, 5, 0, time.Local)
+	morning := time.Date(2026, 3, 1, 9, 30, 0, 0, time.Local)
+	tests := []struct {
+		l    *locale
+		got  string
+		want string
+	}{
+		{zh, zh.clock(afternoon), "15:04:05"},
+		{zh, zh.clock(morning), "09:30:00"},
+		{en, en.clock(afternoon), "3:04:05 PM"},
+		{en, en.clock(morning), "9:30:00 AM"},
+
+		{zh, zh.size(512), "512 B"},
+		{zh, zh.size(1536), "1.5 KB"},
+		{zh, zh.size(5 << 20), "5.0 MB"},
+		{zh, zh.size(3 << 30), "3.0 GB"},
+		{en, en.size(1023), "1023 B"},
+		{en, en.size(1536), "1.5 KB"},
+		{en, en.size(2621440), "2.5 MB"},
+		{de, de.size(1536), "1,5 KB"},
+
+		{zh, zh.duration(2500 * time.Millisecond), "2.5s"},
+		{en, en.duration(5*time.Hour + 20*time.Minute + 10*time.Second), "5h20m"},
+		{de, de.duration(2500 * time.Millisecond), "2,5s"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.l, tt.got, tt.want)
+		}
+	}
+}
+
+// localize 只重写展示用的 time，at 不变；没有 at 的消息保持原样
+func TestLocalize(t *testing.T) {
+	at := time.Date(2026, 3, 1, 15, 4, 5, 0, time.Local).UnixMilli()
+	msg := WSMessage{Type: "message", Data: Message{Text: "hi", Time: "15:04:05", At: at}}
+	if got := localize(msg, locales[1]); got.Data.Time != "3:04:05 PM" || got.Data.At != at {
+		t.Fatalf("en: %+v", got.Data)
+	}
+	if got := localize(msg, locales[0]); got.Data.Time != "15:04:05" {
+		t.Fatalf("zh: %+v", got.Data)
+	}
+	legacy := WSMessage{Type: "message", Data: Message{Text: "hi", Time: "15:04:05"}}
+	if got := localize(legacy, locales[1]); got.Data.Time != "15:04:05" {
+		t.Fatalf("message without at rewritten: %+v", got.Data)
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -9,7 +12,6 @@ import (
 	"io"
 	"io/fs"
 	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"os"
@@ -18,19 +20,31 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	"github.com/rs/cors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const Version = "1.3.6"
 
-// 新增：支持人类可读单位的 ByteSize 类型
+// maxMessageLen 经 WebSocket 发送的群聊消息最多字符数；/send 供机器人使用，不受此限制
+const maxMessageLen = 4000
+
+// 新增：支持人类可读单位的 ByteSize 类型。
+// 读写都是原子的，可经配置导入在运行中修改的参数（见 presets.go）用 Load 读取
 type ByteSize int64
 
 func (b *ByteSize) String() string {
-	return strconv.FormatInt(int64(*b), 10)
+	return strconv.FormatInt(b.Load(), 10)
+}
+
+func (b *ByteSize) Load() int64 {
+	return atomic.LoadInt64((*int64)(b))
 }
 
 func (b *ByteSize) Set(value string) error {
@@ -57,10 +71,15 @@ func (b *ByteSize) Set(value string) error {
 		return fmt.Errorf("invalid size: %s", value)
 	}
 
-	*b = ByteSize(num * float64(multiplier))
+	atomic.StoreInt64((*int64)(b), int64(num*float64(multiplier)))
 	return nil
 }
 
+// humanSize 按默认格式显示字节数，用于日志与命令行；发给用户的字符串见 locale.go
+func humanSize(n int64) string {
+	return defaultLocale.size(n)
+}
+
 // 全局配置变量（由 flag 解析）
 var (
 	port      = flag.Int("port", 3027, "服务监听端口")
@@ -68,31 +87,92 @@ var (
 	maxSize   = ByteSize(50 << 20) // 默认 50 MiB
 )
 
+func init() {
+	flag.Var(&maxSize, "max-size", "单文件最大大小，支持 100M、2G、0.5G 或字节数（默认 50M）")
+}
+
 //go:embed public
 var staticFiles embed.FS
 
 var (
 	startTime = time.Now()
-	clients   = make(map[*websocket.Conn]string)
-	clientsMu sync.RWMutex
-
-	// 反向索引：userId -> conn，用于精确转发信令到目标对端
-	userIdToConn = make(map[string]*websocket.Conn)
 
 	fileList = make(map[string]FileInfo)
 	filesMu  sync.RWMutex
 )
 
+// client 一个 WebSocket 连接及其元信息
+type client struct {
+	conn        *websocket.Conn
+	userID      string
+	userAgent   string
+	device      string
+	remoteAddr  string
+	connectedAt time.Time
+	proto       int     // 协商出的协议版本，见 protocol.go
+	locale      *locale // 展示字符串的格式，见 locale.go
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+	framesIn    atomic.Int64 // 收到的消息帧数（不含心跳），见 bandwidth.go
+	framesOut   atomic.Int64 // 成功写出的消息帧数
+	lastActive  atomic.Int64 // 最近一次收到消息帧的 UnixNano，见 presence.go
+	lastHeard   atomic.Int64 // 最近一次收到心跳的 UnixNano，仅 -idle-include-pings 时记录，见 idle.go
+	idleClosing atomic.Bool  // 已因空闲开始关闭，见 idle.go
+	away        atomic.Bool
+	// 尚未并入用户流量计数的字节，见 bandwidth.go
+	unflushedIn  atomic.Int64
+	unflushedOut atomic.Int64
+	// 当前这次写开始的 UnixNano，未在写时为 0，见 watchdog.go
+	writeStarted   atomic.Int64
+	superseded     bool            // 已被同一身份的新连接接管，由 identityMu 保护，见 takeover.go
+	departedCount  int             // 移出在线列表后剩余的在线人数，由 identityMu 保护
+	departedShared bool            // 移出时同一用户仍有其他连接，由 identityMu 保护，见 tabs.go
+	resumeToken    string          // init 中下发的 resume 令牌，只由 hub 协程读写
+	caps           map[string]bool // 声明能渲染的富消息，nil 表示全部，见 fallback.go
+	queue          *sendQueue      // 发送队列，由 writePump 独占写连接，见 writer.go
+	dropped        atomic.Int64    // 因发送队列满丢弃的帧
+	slowOnce       sync.Once
+	done           chan struct{} // 连接已失效（写失败或处理结束）时关闭，见 kill
+	killOnce       sync.Once
+	typing         *typingState                // 正在输入状态，见 typing.go
+	rates          [rateClasses]tokenBucket    // 入站消息按类型限速，只由读循环访问，见 wstypes.go
+	inbound        inboundLimit                // 连接级入站限速，只由读循环访问，见 ratelimit.go
+	compression    compressionState            // 压缩统计与自适应关闭，见 compression.go
+	relayOut       *relaySession               // 最近开始发送的中继，结束后保留以丢弃在途的帧；只由读循环访问，见 relay.go
+	hello          atomic.Pointer[clientHello] // 客户端声明的能力，未发 hello 时为 nil，见 hello.go
+	rooms          map[string]bool             // 所在的房间，由 roomsMu 保护，见 rooms.go
+}
+
 type Message struct {
+	ID   string `json:"id,omitempty"`
 	Text string `json:"text"`
 	From string `json:"from"`
 	To   string `json:"to,omitempty"`
-	Time string `json:"time"`
+	Time string `json:"time"`         // 展示用，按接收方的格式生成，见 locale.go
+	At   int64  `json:"at,omitempty"` // UTC 毫秒时间戳
+	// 发送者的名字颜色，见 colors.go
+	Color string `json:"color,omitempty"`
+	// 引用的已上传文件，见 attachments.go
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// 由消息转换钩子附加，如 {"de": "...", "en": "..."}
+	Translations map[string]string `json:"translations,omitempty"`
+	// 已删除：正文、附件与译文均已清空，见 deletes.go
+	Deleted bool `json:"deleted,omitempty"`
+	// 群聊消息所在的房间，为空表示发给所有连接，见 rooms.go
+	Room string `json:"room,omitempty"`
+	// 发送者要求送达回执，见 receipts.go
+	Receipt bool `json:"receipt,omitempty"`
 }
 
 type WSMessage struct {
-	Type string  `json:"type"`
-	Data Message `json:"data"`
+	Type  string  `json:"type"`
+	Data  Message `json:"data"`
+	Muted bool    `json:"muted,omitempty"` // 接收方处于免打扰，见 dnd.go
+	Seq   uint64  `json:"seq,omitempty"`   // 广播序号，由 hub 按投递顺序分配，见 hub.go
+	// 房间内消息的序号，每个房间各自计数，此时没有 seq，见 hub.go
+	RoomSeq uint64 `json:"roomSeq,omitempty"`
+	// chat / presence / system / file / moderation，见 category.go
+	Category string `json:"category,omitempty"`
 }
 
 type ServiceInfo struct {
@@ -100,6 +180,27 @@ type ServiceInfo struct {
 	StartTime   string `json:"startTime"`
 	Uptime      string `json:"uptime"`
 	OnlineUsers int    `json:"onlineUsers"`
+	// 当前打开的 WebSocket 连接（含握手中的）与上限，0 表示不限，见 connlimit.go
+	Connections int64 `json:"connections"`
+	MaxClients  int   `json:"maxClients"`
+	// 在线连接按协商出的协议版本计数，如 {"v1":1,"v2":5}，见 protocol.go
+	Protocols map[string]int `json:"protocols"`
+	// 对外访问地址（-public-url 或按请求推断）
+	PublicURL string `json:"publicUrl"`
+	// 消息转换钩子失败（超时/出错）次数
+	TransformFailures int64 `json:"transformFailures"`
+	// 接收过慢的连接：因发送队列满丢弃的帧与断开的连接，见 writer.go
+	DroppedFrames   int64 `json:"droppedFrames"`
+	SlowDisconnects int64 `json:"slowDisconnects"`
+	// 自动停止压缩的连接数，见 compression.go
+	CompressionDisabled int64 `json:"compressionDisabled"`
+	// 因空闲超时断开的连接数，见 idle.go
+	IdleDisconnects int64 `json:"idleDisconnects"`
+	// 被连接级限速过的连接数与因持续超限断开的连接数，见 ratelimit.go
+	RateLimitedConnections int64 `json:"rateLimitedConnections"`
+	RateLimitDisconnects   int64 `json:"rateLimitDisconnects"`
+	// 最近 1024 次广播从开始分发到放入全部发送队列的 p99 耗时（毫秒），见 fanout.go
+	FanoutP99Ms float64 `json:"fanoutP99Ms"`
 }
 
 type FileInfo struct {
@@ -108,12 +209,23 @@ type FileInfo struct {
 	Size      int64     `json:"size"`
 	Uploaded  time.Time `json:"uploaded"`
 	URL       string    `json:"url"`
+	ShareURL  string    `json:"shareUrl"`
+	Code      string    `json:"code,omitempty"`
+	SHA256    string    `json:"sha256,omitempty"`
+	// 磁盘上的实际文件名，为空表示与 SavedName 相同（见 -filename-strategy）
+	DiskName string `json:"diskName,omitempty"`
+	// 通过上传令牌确认的上传者 userId，匿名上传为空
+	Owner string `json:"owner,omitempty"`
+	// 以下仅在列表接口中填充
+	CommentCount int `json:"commentCount"`
+	StarredBy    int `json:"starredBy"`
 }
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
+	Subprotocols: wsSubprotocols,
 }
 
 func printLogo() {
@@ -159,27 +271,6 @@ func getLocalIP() string {
 	return "127.0.0.1"
 }
 
-func generateUserID() string {
-	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, 6)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(b)
-}
-
-func broadcast(msg WSMessage) {
-	clientsMu.RLock()
-	defer clientsMu.RUnlock()
-
-	data, _ := json.Marshal(msg)
-	for client := range clients {
-		if err := client.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("广播失败: %v", err)
-		}
-	}
-}
-
 // 简易信令消息结构（用于 WebRTC 建链）
 type SignalMessage struct {
 	Type    string                 `json:"type"`    // offer/answer/candidate
@@ -188,244 +279,305 @@ type SignalMessage struct {
 	Payload map[string]interface{} `json:"payload"` // SDP/ICE
 }
 
-func forwardSignal(toUserId string, payload interface{}) error {
-	clientsMu.RLock()
-	defer clientsMu.RUnlock()
-	conn := userIdToConn[toUserId]
-	if conn == nil {
-		return fmt.Errorf("target user %s not found", toUserId)
-	}
-	data, _ := json.Marshal(payload)
-	return conn.WriteMessage(websocket.TextMessage, data)
-}
-
 func wsHandler(w http.ResponseWriter, r *http.Request) {
+	// 准入、身份声明、注册、读循环与注销见 session.go
+	release, ok := admit(w, r)
+	if !ok {
+		return
+	}
+	defer release()
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket 升级失败: %v", err)
 		return
 	}
 	defer conn.Close()
+	applyCompression(conn)
 
-	// 支持通过查询参数 uid 指定固定用户ID（用于持久化身份）
-	want := r.URL.Query().Get("uid")
-	userID := want
-	if userID == "" {
-		userID = generateUserID()
-	}
-	// 若已存在同名在线用户，避免冲突（追加随机后缀）
-	clientsMu.RLock()
-	_, taken := userIdToConn[userID]
-	clientsMu.RUnlock()
-	if taken {
-		userID = generateUserID()
-	}
-
-	clientsMu.Lock()
-	clients[conn] = userID
-	userIdToConn[userID] = conn
-	count := len(clients)
-	// 更新在线用户列表
-	var users []string
-	for _, uid := range clients {
-		users = append(users, uid)
-	}
-	clientsMu.Unlock()
-
-	conn.WriteMessage(websocket.TextMessage, mustMarshal(map[string]interface{}{
-		"type":   "init",
-		"userId": userID,
-	}))
-	broadcast(WSMessage{Type: "users", Data: Message{Text: strings.Join(users, ","), From: "system", Time: time.Now().Format("15:04:05")}})
-
-	now := time.Now().Format("15:04:05")
-	broadcast(WSMessage{
-		Type: "message",
-		Data: Message{
-			Text: fmt.Sprintf("👥 用户 %s 上线，当前在线: %d", userID, count),
-			From: "system",
-			Time: now,
-		},
-	})
-
-	log.Printf("👥 用户 %s 上线，当前在线: %d", userID, count)
-
-	defer func() {
-		clientsMu.Lock()
-		delete(clients, conn)
-		delete(userIdToConn, userID)
-		newCount := len(clients)
-		// 更新在线用户列表
-		var users []string
-		for _, uid := range clients {
-			users = append(users, uid)
-		}
-		clientsMu.Unlock()
-
-		broadcast(WSMessage{Type: "users", Data: Message{Text: strings.Join(users, ","), From: "system", Time: time.Now().Format("15:04:05")}})
-		broadcast(WSMessage{
-			Type: "message",
-			Data: Message{
-				Text: fmt.Sprintf("👋 用户 %s 离线，当前在线: %d", userID, newCount),
-				From: "system",
-				Time: time.Now().Format("15:04:05"),
-			},
-		})
-		log.Printf("👋 用户 %s 离线，当前在线: %d", userID, newCount)
-	}()
-
-	for {
-		_, msgBytes, err := conn.ReadMessage()
-		if err != nil {
-			break
-		}
-		// 解析消息封装
-		var envelope struct {
-			Type string          `json:"type"`
-			Data json.RawMessage `json:"data"`
-		}
-		if err := json.Unmarshal(msgBytes, &envelope); err == nil && envelope.Type == "signal" {
-			var s SignalMessage
-			if err := json.Unmarshal(envelope.Data, &s); err == nil && s.Type != "" && s.To != "" {
-				// 添加来源（如前端未填充）
-				if s.From == "" {
-					s.From = userID
-				}
-				payload := map[string]interface{}{
-					"type": "signal",
-					"data": s,
-				}
-				if err := forwardSignal(s.To, payload); err != nil {
-					log.Printf("转发信令失败: %v", err)
-				}
-			}
-		}
+	userID, exact, ok := claimIdentity(conn, r)
+	if !ok {
+		return
+	}
+	self := newClient(conn, r, userID)
+	if !self.register(r, exact) {
+		return
 	}
+	defer self.leave()
+	self.readPump()
+}
+
+// announceLeave 用户确实离开：取消其邀请并广播在线列表；离线提示已由 hub 在移除时发出（见 presence.go）
+func announceLeave(userID string, count int) {
+	cancelOffersFor(userID)
+	broadcastUsers()
+	log.Printf("👋 用户 %s 离线，当前在线: %d", userID, count)
 }
 
 func sendHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		errMethodNotAllowed(w, r)
 		return
 	}
 
 	var req struct {
-		Message string `json:"message"`
-		From    string `json:"from"`
+		Message     string   `json:"message"`
+		From        string   `json:"from"`
+		ClientID    string   `json:"clientId"`
+		Attachments []string `json:"attachments"` // 已上传文件的 savedName
+		Room        string   `json:"room"`        // 省略时为默认房间，见 rooms.go
+		NoTransform bool     `json:"noTransform"`
+		Receipt     bool     `json:"receipt"` // 要求送达回执，见 receipts.go
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		errInvalidJSON(w, r)
 		return
 	}
 
 	if req.Message == "" || req.From == "" {
-		http.Error(w, "Missing 'message' or 'from'", http.StatusBadRequest)
+		errMissingFields(w, r, "message", "from")
+		return
+	}
+	if !checkFromName(w, r, &req.From) {
+		return
+	}
+	if len(req.Attachments) > maxAttachments {
+		writeError(w, r, http.StatusBadRequest, "too_many_attachments", "Too many attachments", map[string]interface{}{"max": maxAttachments})
+		return
+	}
+	attachments, missing, ok := resolveAttachments(req.Attachments)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "attachment_not_found", "Attachment not found", map[string]interface{}{"savedName": missing})
+		return
+	}
+	room, ok := normalizeRoom(req.Room)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_room", "Invalid room name", nil)
 		return
 	}
 
-	now := time.Now().Format("15:04:05")
-	broadcast(WSMessage{
-		Type: "message",
-		Data: Message{
-			Text: req.Message,
-			From: req.From,
-			Time: now,
-		},
-	})
+	id, duplicate := publishChat(r.Context(), req.From, room, req.Message, req.ClientID, attachments, req.NoTransform, req.Receipt)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "id": id, "duplicate": duplicate})
+}
+
+// sentClientIDs 发送者 + clientId -> 服务端消息 ID，窗口内重发同一 clientId 不会重复广播
+var sentClientIDs = newExpiringMap[string, string]("client_ids", 2*time.Minute, registryMaxEntries)
+
+// publishChat 广播一条群聊消息并返回其 ID；/send 与 WebSocket 的 message 帧共用，只发给 room 的成员。
+// clientId 在窗口内已出现过时不再广播，返回首次分配的 ID 与 duplicate=true
+func publishChat(ctx context.Context, from, room, text, clientID string, attachments []Attachment, noTransform, receipt bool) (string, bool) {
+	id := newMessageID()
+	if clientID != "" {
+		duplicate := false
+		id = sentClientIDs.Update(from+"\x00"+clientID, func(prev string, ok bool) string {
+			if ok {
+				duplicate = true
+				return prev
+			}
+			return id
+		})
+		if duplicate {
+			return id, true
+		}
+	}
+	now := time.Now()
+	msg := applyTransform(Message{
+		ID:   id,
+		Text: text,
+		From: from,
+		Time: now.Format("15:04:05"),
+		At:   now.UnixMilli(),
+		Room: room,
+	}, noTransform)
+	msg.Attachments = attachments
+	if receipt {
+		msg.Receipt = true
+		trackReceipt(msg)
+	}
+	broadcastCtx(ctx, WSMessage{Type: "message", Data: msg})
+	assistantObserve(msg)
+	return id, false
+}
+
+// handleChatMessage 处理 {"type":"message","data":{"text":"...","room":"dev","noTransform":false}}，
+// 发送者即连接的 userId，room 省略时为默认房间且须已加入；不合法时只回给该连接 message_error
+func handleChatMessage(ctx context.Context, c *client, raw json.RawMessage) {
+	userID := c.userID
+	var req struct {
+		Text        string   `json:"text"`
+		Room        string   `json:"room"`
+		ClientID    string   `json:"clientId"`
+		Attachments []string `json:"attachments"`
+		NoTransform bool     `json:"noTransform"`
+		Receipt     bool     `json:"receipt"`
+	}
+	fail := func(code, reason string) {
+		replyTo(c, map[string]interface{}{"type": "message_error", "data": map[string]interface{}{"code": code, "error": reason, "maxLength": maxMessageLen, "clientId": req.ClientID}})
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		fail("invalid_json", "invalid message payload")
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		fail("empty_message", "message text is empty")
+		return
+	}
+	if utf8.RuneCountInString(req.Text) > maxMessageLen {
+		fail("message_too_long", "message text too long")
+		return
+	}
+	if len(req.Attachments) > maxAttachments {
+		fail("too_many_attachments", "too many attachments")
+		return
+	}
+	room, ok := normalizeRoom(req.Room)
+	if !ok {
+		fail("invalid_room", "invalid room name")
+		return
+	}
+	if !c.inRoom(room) {
+		fail("not_in_room", "not a member of this room")
+		return
+	}
+	attachments, missing, ok := resolveAttachments(req.Attachments)
+	if !ok {
+		fail("attachment_not_found", "attachment not found: "+missing)
+		return
+	}
+	id, duplicate := publishChat(ctx, userID, room, req.Text, req.ClientID, attachments, req.NoTransform, req.Receipt)
+	// 广播已交给 hub，ack 排在其后，发送者总是先收到自己的消息
+	ack := map[string]interface{}{"type": "ack", "id": id}
+	if req.ClientID != "" {
+		ack["clientId"] = req.ClientID
+	}
+	if duplicate {
+		ack["duplicate"] = true
+	}
+	replyTo(c, ack)
 }
 
 // 私聊消息：只发给目标与发送者自己
 func sendPrivateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		errMethodNotAllowed(w, r)
 		return
 	}
 	var req struct {
-		Message string `json:"message"`
-		From    string `json:"from"`
-		To      string `json:"to"`
+		Message     string `json:"message"`
+		From        string `json:"from"`
+		To          string `json:"to"`
+		NoTransform bool   `json:"noTransform"`
+		Receipt     bool   `json:"receipt"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		errInvalidJSON(w, r)
 		return
 	}
 	if req.Message == "" || req.From == "" || req.To == "" {
-		http.Error(w, "Missing 'message' or 'from' or 'to'", http.StatusBadRequest)
+		errMissingFields(w, r, "message", "from", "to")
 		return
 	}
-	clientsMu.RLock()
-	targetConn := userIdToConn[req.To]
-	senderConn := userIdToConn[req.From]
-	clientsMu.RUnlock()
-	if targetConn == nil {
-		http.Error(w, "Target user not online", http.StatusNotFound)
+	if !checkFromName(w, r, &req.From) {
 		return
 	}
-	now := time.Now().Format("15:04:05")
-	payload := WSMessage{Type: "private", Data: Message{Text: req.Message, From: req.From, To: req.To, Time: now}}
-	data, _ := json.Marshal(payload)
-	// 发给对方
-	if err := targetConn.WriteMessage(websocket.TextMessage, data); err != nil {
-		log.Printf("私聊发送失败(对方): %v", err)
+	// 断线保留期内的用户也算在线，消息在其恢复后送达
+	identityMu.Lock()
+	online := clients.Online(req.To) || lingering[req.To] != nil
+	identityMu.Unlock()
+	if !online {
+		writeError(w, r, http.StatusNotFound, "user_offline", "Target user not online", nil)
+		return
 	}
-	// 回显给自己
-	if senderConn != nil {
-		if err := senderConn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("私聊发送失败(自己): %v", err)
-		}
+	now := time.Now()
+	msg := applyTransform(Message{ID: newMessageID(), Text: req.Message, From: req.From, To: req.To, Time: now.Format("15:04:05"), At: now.UnixMilli(), Color: colorOf(req.From)}, req.NoTransform)
+	if req.Receipt {
+		msg.Receipt = true
+		trackReceipt(msg)
 	}
+	payload := WSMessage{Type: "private", Data: msg}
+	encode := func(c *client) []byte { return encodeFor(c, payload) }
+	// 发给对方：按其能力降级文件卡片，免打扰时带 muted
+	if err := sendTo(req.To, encode); err != nil {
+		log.Printf("私聊发送失败(对方): %v", err)
+	}
+	// 回显给自己（发送者可能不在线）
+	sendTo(req.From, encode)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": msg.ID})
 }
 
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		errMethodNotAllowed(w, r)
+		return
+	}
+	setQuotaHeaders(w, r, "")
+	owner, ok := requestOwner(w, r)
+	if !ok {
 		return
 	}
+	setQuotaHeaders(w, r, owner)
+	if overBandwidthCap(owner) {
+		errBandwidthCap(w, r)
+		return
+	}
+	if token := r.URL.Query().Get("progress"); token != "" {
+		finish, ok := trackUploadProgress(w, r, token)
+		if !ok {
+			return
+		}
+		defer finish()
+	}
 
 	// 使用配置的 maxSize 限制
-	err := r.ParseMultipartForm(int64(maxSize))
+	err := r.ParseMultipartForm(maxSize.Load())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("File too large (max %.1f MB)", float64(maxSize)/(1<<20)), http.StatusBadRequest)
+		errFileTooLarge(w, r)
 		return
 	}
 
 	file, handler, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "No file uploaded", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "no_file", "No file uploaded", nil)
 		return
 	}
 	defer file.Close()
 
-	if handler.Size > int64(maxSize) {
-		http.Error(w, fmt.Sprintf("File too large (max %.1f MB)", float64(maxSize)/(1<<20)), http.StatusBadRequest)
+	if handler.Size > maxSize.Load() {
+		errFileTooLarge(w, r)
 		return
 	}
 
 	ext := filepath.Ext(handler.Filename)
 	if ext == "" {
-		http.Error(w, "Invalid file", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_file", "Invalid file: a file extension is required", nil)
 		return
 	}
 
 	savedName := fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-	savePath := filepath.Join(*uploadDir, savedName)
 
-	out, err := os.Create(savePath)
+	_, span := tracer.Start(r.Context(), "upload.store", trace.WithAttributes(attribute.Int64("file.size", handler.Size)))
+	defer span.End()
+	diskName, out, err := createUploadFile(savedName, handler.Filename)
+	if err == errBadFilename {
+		writeError(w, r, http.StatusBadRequest, "invalid_filename", "Filename cannot be stored on disk (reserved name, control or special characters)", map[string]interface{}{"strategy": *filenameStrategy})
+		return
+	}
 	if err != nil {
+		span.RecordError(err)
 		log.Printf("保存文件失败: %v", err)
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		errServer(w, r)
 		return
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, file)
+	// 写盘同时计算 SHA-256，供同步客户端校验
+	sum := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, sum), file)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		span.RecordError(err)
+		errServer(w, r)
 		return
 	}
 
@@ -435,27 +587,88 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		Size:      handler.Size,
 		Uploaded:  time.Now(),
 		URL:       "/files/" + savedName,
+		ShareURL:  "/share/" + savedName,
+		SHA256:    hex.EncodeToString(sum.Sum(nil)),
+		DiskName:  diskName,
+		Owner:     owner,
 	}
 
+	// 内容相同的文件只保留一份，直接返回已有条目
 	filesMu.Lock()
-	fileList[savedName] = info
+	dup, isDup := findBySHA256(info.SHA256, info.Size)
+	if !isDup {
+		info.Code = assignCode(savedName)
+		fileList[savedName] = info
+	}
 	filesMu.Unlock()
+	if isDup {
+		out.Close()
+		os.Remove(info.diskPath())
+		info = dup
+	} else {
+		recordChange(ChangeAdd, info)
+		saveIndex()
+		countUploadStat(info.Size)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"fileUrl":  info.URL,
-		"fileName": info.Name,
-		"fileSize": info.Size,
-	})
+	base := baseURL(r)
+	res := map[string]interface{}{
+		"fileUrl":     base + info.URL,
+		"shareUrl":    base + info.ShareURL,
+		"code":        info.Code,
+		"fileName":    info.Name,
+		"fileSize":    info.Size,
+		"duplicate":   isDup,
+		"maxFileSize": maxSize.Load(),
+	}
+	if quota := setQuotaHeaders(w, r, owner); quota != nil {
+		res["quota"] = quota
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// findBySHA256 查找内容相同的已上传文件，调用方需持有 filesMu
+func findBySHA256(sum string, size int64) (FileInfo, bool) {
+	for _, f := range fileList {
+		if f.SHA256 == sum && f.Size == size {
+			return f, true
+		}
+	}
+	return FileInfo{}, false
 }
 
+// withFileStats 为列表中的文件填充评论数与收藏数
+func withFileStats(list []FileInfo) {
+	filesMu.RLock()
+	defer filesMu.RUnlock()
+	for i := range list {
+		list[i].CommentCount = len(fileComments[list[i].SavedName])
+		list[i].StarredBy = len(fileStars[list[i].SavedName])
+	}
+}
+
+// listFilesHandler GET /api/files，?starred=1 只返回自己收藏的文件（需 X-Upload-Token）
 func listFilesHandler(w http.ResponseWriter, r *http.Request) {
+	starredOnly := r.URL.Query().Get("starred") == "1"
+	var userID string
+	if starredOnly {
+		var ok bool
+		if userID, ok = starIdentity(w, r); !ok {
+			return
+		}
+	}
+
 	filesMu.RLock()
 	list := make([]FileInfo, 0, len(fileList))
-	for _, f := range fileList {
+	for name, f := range fileList {
+		if starredOnly && !fileStars[name][userID] {
+			continue
+		}
 		list = append(list, f)
 	}
 	filesMu.RUnlock()
+	withFileStats(list)
 
 	sort.Slice(list, func(i, j int) bool {
 		return list[i].Uploaded.After(list[j].Uploaded)
@@ -469,13 +682,21 @@ func listFilesHandler(w http.ResponseWriter, r *http.Request) {
 func listAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 	entries, err := os.ReadDir(*uploadDir)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		errServer(w, r)
 		return
 	}
 
+	// 磁盘文件名 -> 索引条目
+	filesMu.RLock()
+	byDisk := make(map[string]FileInfo, len(fileList))
+	for _, f := range fileList {
+		byDisk[filepath.Base(f.diskPath())] = f
+	}
+	filesMu.RUnlock()
+
 	var list []FileInfo
 	for _, e := range entries {
-		if e.IsDir() {
+		if e.IsDir() || isHiddenName(e.Name()) {
 			continue
 		}
 		name := e.Name()
@@ -485,10 +706,8 @@ func listAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		// 如果内存里有记录，尽量保留原始名称
-		filesMu.RLock()
-		fi, ok := fileList[name]
-		filesMu.RUnlock()
+		// 如果内存里有记录，尽量保留原始名称与对外的 savedName
+		fi, ok := byDisk[name]
 
 		item := FileInfo{
 			Name:      name,
@@ -496,51 +715,89 @@ func listAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 			Size:      st.Size(),
 			Uploaded:  st.ModTime(),
 			URL:       "/files/" + name,
+			ShareURL:  "/share/" + name,
 		}
 		if ok && fi.Name != "" {
 			item.Name = fi.Name
+			item.SavedName = fi.SavedName
+			item.DiskName = fi.DiskName
+			item.URL = fi.URL
+			item.ShareURL = fi.ShareURL
+			item.Code = fi.Code
+			item.SHA256 = fi.SHA256
 		}
 		list = append(list, item)
 	}
 
+	withFileStats(list)
 	sort.Slice(list, func(i, j int) bool { return list[i].Uploaded.After(list[j].Uploaded) })
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(list)
 }
 
+var fileSubresources = map[string]func(http.ResponseWriter, *http.Request, string){
+	"/comments": fileCommentsHandler,
+	"/star":     fileStarHandler,
+	"/preview":  filePreviewHandler,
+}
+
+// fileItemHandler 分发 /api/files/{savedName} 及其子资源
+func fileItemHandler(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path[len("/api/files/"):]
+	for suffix, h := range fileSubresources {
+		name, ok := strings.CutSuffix(path, suffix)
+		if !ok {
+			continue
+		}
+		if name == "" || strings.Contains(name, "/") || strings.Contains(name, "..") || isHiddenName(name) {
+			errInvalidFilename(w, r)
+			return
+		}
+		h(w, r, name)
+		return
+	}
+	deleteFileHandler(w, r)
+}
+
 func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		errMethodNotAllowed(w, r)
 		return
 	}
 
 	path := r.URL.Path[len("/api/files/"):]
 	savedName := filepath.Base(path)
-	if savedName == "" || strings.Contains(savedName, "..") || !strings.Contains(path, savedName) {
-		http.Error(w, "Invalid filename", http.StatusBadRequest)
+	if savedName == "" || strings.Contains(savedName, "..") || isHiddenName(savedName) || !strings.Contains(path, savedName) {
+		errInvalidFilename(w, r)
 		return
 	}
 
 	filesMu.RLock()
-	_, exists := fileList[savedName]
+	info, exists := fileList[savedName]
 	filesMu.RUnlock()
 
 	if !exists {
-		http.Error(w, "File not found", http.StatusNotFound)
+		errFileNotFound(w, r)
 		return
 	}
 
-	filePath := filepath.Join(*uploadDir, savedName)
+	filePath := info.diskPath()
 	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
 		log.Printf("删除文件失败 %s: %v", filePath, err)
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		errServer(w, r)
 		return
 	}
 
 	filesMu.Lock()
 	delete(fileList, savedName)
+	delete(fileComments, savedName)
+	delete(fileStars, savedName)
+	retireCode(info.Code)
 	filesMu.Unlock()
+	recordChange(ChangeDelete, info)
+	saveIndex()
+	tombstoneAttachments(savedName)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -548,16 +805,16 @@ func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
 // deleteRealFileHandler 真实删除：不依赖内存索引，直接按磁盘文件名删除
 func deleteRealFileHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		errMethodNotAllowed(w, r)
 		return
 	}
 	path := r.URL.Path[len("/api/files/all/"):]
 	savedName := filepath.Base(path)
-	if savedName == "" || strings.Contains(savedName, "..") || !strings.Contains(path, savedName) {
-		http.Error(w, "Invalid filename", http.StatusBadRequest)
+	if savedName == "" || strings.Contains(savedName, "..") || isHiddenName(savedName) || !strings.Contains(path, savedName) {
+		errInvalidFilename(w, r)
 		return
 	}
-	filePath := filepath.Join(*uploadDir, savedName)
+	filePath := resolveDiskPath(savedName)
 	if err := os.Remove(filePath); err != nil {
 		if os.IsNotExist(err) {
 			// 即使文件不存在也视为成功，保证幂等
@@ -565,20 +822,28 @@ func deleteRealFileHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Printf("真实删除失败 %s: %v", filePath, err)
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		errServer(w, r)
 		return
 	}
 	// 同步内存索引（若存在）
 	filesMu.Lock()
+	info, ok := fileList[savedName]
 	delete(fileList, savedName)
+	delete(fileComments, savedName)
+	delete(fileStars, savedName)
+	retireCode(info.Code)
 	filesMu.Unlock()
+	if !ok {
+		info = FileInfo{Name: savedName, SavedName: savedName, URL: "/files/" + savedName}
+	}
+	recordChange(ChangeDelete, info)
+	saveIndex()
+	tombstoneAttachments(savedName)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func infoHandler(w http.ResponseWriter, r *http.Request) {
-	clientsMu.RLock()
-	online := len(clients)
-	clientsMu.RUnlock()
+	online := clients.Users()
 
 	uptime := time.Since(startTime).Round(time.Second)
 	uptimeStr := fmt.Sprintf("%v", uptime)
@@ -588,12 +853,37 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 		StartTime:   startTime.Format(time.RFC3339),
 		Uptime:      uptimeStr,
 		OnlineUsers: online,
+		Connections: wsSlots.Load(),
+		MaxClients:  int(maxClients.Load()),
+		Protocols:   protocolCounts(),
+		PublicURL:   baseURL(r),
+
+		TransformFailures:   transformFailures.Load(),
+		DroppedFrames:       droppedFrames.Load(),
+		SlowDisconnects:     slowDisconnects.Load(),
+		CompressionDisabled: compressionDisabled.Load(),
+		IdleDisconnects:     idleDisconnects.Load(),
+
+		RateLimitedConnections: rateLimitedConns.Load(),
+		RateLimitDisconnects:   rateLimitDisconnects.Load(),
+		FanoutP99Ms:            float64(fanoutP99().Microseconds()) / 1000,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(info)
 }
 
+// hideIndex 拒绝访问上传目录中的隐藏文件（如文件索引）
+func hideIndex(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hasHiddenSegment(r.URL.Path) {
+			writeError(w, r, http.StatusNotFound, "not_found", "Not found", nil)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 func mustMarshal(v interface{}) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -603,53 +893,154 @@ func mustMarshal(v interface{}) []byte {
 }
 
 func main() {
+	// 子命令：作为客户端运行，不启动服务
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "sync":
+			os.Exit(runSync(os.Args[2:]))
+		case "watch":
+			os.Exit(runWatch(os.Args[2:]))
+		case "service":
+			os.Exit(runService(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		}
+	}
+
 	printLogo()
-	// 解析命令行参数
-	flag.Var(&maxSize, "max-size", "单文件最大大小，支持 100M、2G、0.5G 或字节数（默认 50M）")
+	// 解析命令行参数，再加载 -config 预设中命令行未给出的部分
 	flag.Parse()
+	if err := loadConfigFile(*configPath); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
 
-	// 创建上传目录（使用配置值）
-	if err := os.MkdirAll(*uploadDir, 0755); err != nil {
-		log.Fatalf("❌ 无法创建上传目录 %s: %v", *uploadDir, err)
+	// 由 Windows 服务管理器启动时，交给服务框架控制启停
+	if isWindowsService() {
+		runAsService()
+		return
 	}
+	runServer()
+}
+
+// runServer 初始化并启动 HTTP 服务，阻塞直到进程退出
+func runServer() {
+	results := runChecks()
+	if *checkConfig {
+		fmt.Println("🔍 配置检查：")
+		if !printChecks(results) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	for _, c := range results {
+		switch {
+		case c.ok:
+		case c.fatal:
+			log.Fatalf("❌ %s: %s", c.name, c.detail)
+		default:
+			log.Printf("⚠️  %s: %s", c.name, c.detail)
+		}
+	}
+
+	acquireInstanceLock()
+	configureCompression()
+	loadIndex()
+	loadEmoji()
+	initTransform()
+	initTracing()
 
-	rand.Seed(time.Now().UnixNano())
 	localIP := getLocalIP()
 	addr := fmt.Sprintf(":%d", *port)
 
+	registerRoutes(http.DefaultServeMux)
+
+	handler := traceHTTP(cors.AllowAll().Handler(http.DefaultServeMux))
+
+	// 未指定 -public-url 时按本机地址猜测
+	base := *publicURL
+	if base == "" {
+		base = fmt.Sprintf("http://%s:%d", localIP, *port)
+	}
+	wsBase := "ws" + strings.TrimPrefix(base, "http")
+	fmt.Println("🚀 聊天服务已启动")
+	fmt.Printf("   WebSocket: %s/ws\n", wsBase)
+	fmt.Printf("   发送消息:  POST %s/send\n", base)
+	fmt.Printf("   上传文件:  POST %s/upload\n", base)
+	fmt.Printf("   服务信息:  GET  %s/info\n", base)
+	fmt.Printf("   文件管理:  %s/files.html\n", base)
+	fmt.Printf("   前端页面:   %s/\n", base)
+	fmt.Println("   按 Ctrl+C 停止服务")
+	fmt.Printf("   配置: 端口=%d, 上传目录=%s, 最大大小=%.1f MB\n", *port, *uploadDir, float64(maxSize.Load())/(1<<20))
+
+	ln, err := listen(addr)
+	if err != nil {
+		log.Fatalf("❌ 监听 %s 失败: %v", addr, err)
+	}
+	httpServer = &http.Server{Handler: handler}
+	startFanoutWorkers()
+	go runHub()
+	startRegistrySweeper()
+	startBandwidthFlusher()
+	startAwayTicker()
+	startIdleTicker()
+	startUsersBroadcaster()
+	startWriteWatchdog()
+	watchUpgradeSignal()
+	flushOnExit()
+	notifyReady()
+	if err := httpServer.Serve(countingListener{ln}); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	// 升级交接中：等待 drainAndStop 完成后退出
+	select {}
+}
+
+// registerRoutes 挂载全部 HTTP 路由
+func registerRoutes(mux *http.ServeMux) {
 	// 静态资源
 	publicFS, err := fs.Sub(staticFiles, "public")
 	if err != nil {
 		panic(err)
 	}
-	http.Handle("/", http.FileServer(http.FS(publicFS)))
+	mux.Handle("/", http.FileServer(http.FS(publicFS)))
 
 	// API 路由
-	http.HandleFunc("/ws", wsHandler)
-	http.HandleFunc("/send", sendHandler)
-	http.HandleFunc("/send/private", sendPrivateHandler)
+	mux.HandleFunc("/ws", wsHandler)
+	mux.HandleFunc("/send", sendHandler)
+	mux.HandleFunc("/send/private", sendPrivateHandler)
 	// （保留原上传接口用于兼容），但推荐使用 WebRTC P2P 传输
-	http.HandleFunc("/upload", uploadHandler)
-	http.HandleFunc("/api/files", listFilesHandler)
-	http.HandleFunc("/api/files/all", listAllFilesHandler)
-	http.HandleFunc("/api/files/", deleteFileHandler)
-	http.HandleFunc("/api/files/all/", deleteRealFileHandler)
-	http.HandleFunc("/info", infoHandler)
+	mux.Handle("/upload", instrumentTransfer("upload", http.HandlerFunc(uploadHandler)))
+	mux.HandleFunc("/upload/progress/", uploadProgressHandler)
+	mux.HandleFunc("/api/files", listFilesHandler)
+	mux.HandleFunc("/api/files/all", listAllFilesHandler)
+	mux.HandleFunc("/api/files/changes", fileChangesHandler)
+	mux.HandleFunc("/api/activity", activityHandler)
+	mux.HandleFunc("/api/stats", statsHandler)
+	mux.HandleFunc("/api/files/", fileItemHandler)
+	mux.HandleFunc("/api/files/all/", deleteRealFileHandler)
+	mux.HandleFunc("/info", infoHandler)
+	mux.HandleFunc("/api/capabilities", capabilitiesHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/api/users", usersHandler)
+	mux.HandleFunc("/api/connections", connectionsHandler)
+	mux.HandleFunc("/api/rooms", roomsHandler)
+	mux.HandleFunc("/api/messages/", messageReceiptsHandler)
+	mux.HandleFunc("/api/admin/connections", adminConnectionsHandler)
+	mux.HandleFunc("/api/admin/ip-connections", adminIPConnsHandler)
+	mux.HandleFunc("/api/admin/upgrade", adminUpgradeHandler)
+	mux.HandleFunc("/api/admin/config/import", adminConfigImportHandler)
+	mux.HandleFunc("/api/admin/messages/", adminDeleteMessageHandler)
+
+	// 分享页（OpenGraph 预览）
+	mux.HandleFunc("/share/", shareHandler)
+	mux.HandleFunc("/share/icon/", shareIconHandler)
 
 	// 文件下载服务（使用配置的 uploadDir）
-	http.Handle("/files/", http.StripPrefix("/files/", http.FileServer(http.Dir(*uploadDir))))
-
-	handler := cors.AllowAll().Handler(http.DefaultServeMux)
-
-	fmt.Println("🚀 聊天服务已启动")
-	fmt.Printf("   WebSocket: ws://%s:%d/ws\n", localIP, *port)
-	fmt.Printf("   发送消息:  POST http://%s:%d/send\n", localIP, *port)
-	fmt.Printf("   上传文件:  POST http://%s:%d/upload\n", localIP, *port)
-	fmt.Printf("   服务信息:  GET  http://%s:%d/info\n", localIP, *port)
-	fmt.Printf("   文件管理:  http://%s:%d/files.html\n", localIP, *port)
-	fmt.Printf("   前端页面:   http://%s:%d/\n", localIP, *port)
-	fmt.Println("   按 Ctrl+C 停止服务")
-	fmt.Printf("   配置: 端口=%d, 上传目录=%s, 最大大小=%.1f MB\n", *port, *uploadDir, float64(maxSize)/(1<<20))
-
-	log.Fatal(http.ListenAndServe(addr, handler))
+	mux.Handle("/files/", instrumentTransfer("files", http.StripPrefix("/files/", hideIndex(serveUploads(http.FileServer(http.Dir(*uploadDir)))))))
+	mux.HandleFunc("/f/", shortCodeHandler)
+	mux.HandleFunc("/api/emoji", emojiHandler)
+	mux.HandleFunc("/api/emoji/", deleteEmojiHandler)
+	mux.Handle("/emoji/", http.StripPrefix("/emoji/", http.FileServer(http.Dir(emojiDir()))))
 }
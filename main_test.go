@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 测试共用一个 hub：TestMain 启动 hub 与分发协程，各测试用 newTestServer 建立自己的 HTTP 服务，
+// 连接经 dialWS 建立。断线保留期设为 0，连接关闭即离线，不影响后续测试的 userId
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "go-chat-test")
+	if err != nil {
+		panic(err)
+	}
+	*uploadDir = dir
+	*resumeGrace = 0
+	maxConnsPerIP.Store(0) // 测试连接都来自 127.0.0.1
+	startFanoutWorkers()
+	startUsersBroadcaster()
+	go runHub()
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestServer 只挂载 /ws 与给定的处理器，测试结束时关闭，并等测试中的连接全部注销
+func newTestServer(t *testing.T, routes map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(testMux(routes))
+	startTestServer(t, srv)
+	return srv
+}
+
+func testMux(routes map[string]http.HandlerFunc) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", wsHandler)
+	for path, h := range routes {
+		mux.HandleFunc(path, h)
+	}
+	return mux
+}
+
+func startTestServer(t *testing.T, srv *httptest.Server) {
+	srv.Start()
+	t.Cleanup(func() {
+		srv.Close()
+		waitFor(t, "connections to leave", func() bool { return clients.Count() == 0 })
+	})
+}
+
+// faultConn 服务端一侧的连接，failWrites 置位后所有写都失败，模拟对端已不可写
+type faultConn struct {
+	net.Conn
+	failWrites atomic.Bool
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	if c.failWrites.Load() {
+		return 0, errors.New("injected write error")
+	}
+	return c.Conn.Write(p)
+}
+
+// faultListener 把接受的连接包成 faultConn，按接受顺序放入 accepted
+type faultListener struct {
+	net.Listener
+	accepted chan *faultConn
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	fc := &faultConn{Conn: conn}
+	l.accepted <- fc
+	return fc, nil
+}
+
+// newFaultServer 与 newTestServer 相同，但可以拿到每个连接在服务端的 faultConn
+func newFaultServer(t *testing.T, routes map[string]http.HandlerFunc) (*httptest.Server, chan *faultConn) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(testMux(routes))
+	accepted := make(chan *faultConn, 16)
+	srv.Listener = &faultListener{Listener: srv.Listener, accepted: accepted}
+	startTestServer(t, srv)
+	return srv, accepted
+}
+
+// testConn 测试用的 WebSocket 客户端，init 帧已读出
+type testConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+	init map[string]interface{}
+}
+
+// wsURL srv 的 /ws 地址，query 如 "uid=alice"
+func wsURL(srv *httptest.Server, query string) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?" + query
+}
+
+// dialWS 以 query 连接 srv 的 /ws 并读取 init
+func dialWS(t *testing.T, srv *httptest.Server, query string) *testConn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(srv, query), nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", query, err)
+	}
+	return newTestConn(t, conn)
+}
+
+// newTestConn 包装已建立的连接并读取 init
+func newTestConn(t *testing.T, conn *websocket.Conn) *testConn {
+	t.Helper()
+	tc := &testConn{t: t, conn: conn}
+	t.Cleanup(func() { conn.Close() })
+	tc.init = tc.expect("init")
+	return tc
+}
+
+func (tc *testConn) userID() string {
+	id, _ := tc.init["userId"].(string)
+	return id
+}
+
+// uploadToken init 中下发的上传令牌，HTTP 接口以 X-Upload-Token 携带它证明身份
+func (tc *testConn) uploadToken() string {
+	upload, _ := tc.init["uploadToken"].(map[string]interface{})
+	token, _ := upload["token"].(string)
+	return token
+}
+
+func (tc *testConn) sendJSON(v interface{}) {
+	tc.t.Helper()
+	if err := tc.conn.WriteJSON(v); err != nil {
+		tc.t.Fatalf("write: %v", err)
+	}
+}
+
+// next 读下一帧文本消息，2 秒内没有时返回错误
+func (tc *testConn) next() (map[string]interface{}, error) {
+	tc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := tc.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// expect 跳过其他帧，直到读到 typ 类型的一帧
+func (tc *testConn) expect(typ string) map[string]interface{} {
+	tc.t.Helper()
+	return tc.expectWhere(typ, func(map[string]interface{}) bool { return true })
+}
+
+// expectWhere 跳过其他帧，直到读到 typ 类型且满足 match 的一帧
+func (tc *testConn) expectWhere(typ string, match func(map[string]interface{}) bool) map[string]interface{} {
+	tc.t.Helper()
+	for {
+		m, err := tc.next()
+		if err != nil {
+			tc.t.Fatalf("waiting for %q: %v", typ, err)
+		}
+		if m["type"] == typ && match(m) {
+			return m
+		}
+	}
+}
+
+// closeOf 读到关闭帧为止，返回关闭码与原因
+func (tc *testConn) closeOf() (int, string) {
+	tc.t.Helper()
+	for {
+		_, err := tc.next()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			return ce.Code, ce.Text
+		}
+		if err != nil {
+			tc.t.Fatalf("waiting for close: %v", err)
+		}
+	}
+}
+
+// chatText 聊天消息帧的正文，其他帧为空
+func chatText(m map[string]interface{}) string {
+	if m["type"] != "message" {
+		return ""
+	}
+	data, _ := m["data"].(map[string]interface{})
+	text, _ := data["text"].(string)
+	return text
+}
+
+// waitFor 轮询 cond 直到成立，最多 2 秒
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prometheus 指标：上传/下载的大小与耗时直方图、进行中的传输数、按状态码计数，GET /metrics
+
+var (
+	sizeBuckets     = []float64{1 << 10, 16 << 10, 256 << 10, 1 << 20, 8 << 20, 64 << 20, 512 << 20, 4 << 30}
+	durationBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 15, 60, 300}
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // 与 buckets 一一对应，非累计
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// observe 调用方需持有 metricsMu
+func (h *histogram) observe(v float64) {
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cum uint64
+	for i, b := range h.buckets {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(b, 'g', -1, 64), cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+type transferMetrics struct {
+	inflight atomic.Int64
+	// 以下由 metricsMu 保护
+	size     *histogram
+	duration *histogram
+	bytesIn  uint64
+	bytesOut uint64
+	status   map[int]uint64
+}
+
+var (
+	transfers = make(map[string]*transferMetrics) // handler -> 指标
+	metricsMu sync.Mutex
+)
+
+func transferFor(handler string) *transferMetrics {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	t := transfers[handler]
+	if t == nil {
+		t = &transferMetrics{size: newHistogram(sizeBuckets), duration: newHistogram(durationBuckets), status: make(map[int]uint64)}
+		transfers[handler] = t
+	}
+	return t
+}
+
+// countingReader 统计实际读到的字节，传输中断时同样计入
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter 记录状态码与写出的字节
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (c *countingWriter) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// instrumentTransfer 为传输类接口记录大小、耗时、进行中数量与状态码
+func instrumentTransfer(handler string, next http.Handler) http.Handler {
+	t := transferFor(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		t.inflight.Add(1)
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		cw := &countingWriter{ResponseWriter: w}
+		defer func() {
+			t.inflight.Add(-1)
+			if cw.status == 0 {
+				cw.status = http.StatusOK
+			}
+			metricsMu.Lock()
+			t.size.observe(float64(body.n + cw.n))
+			t.duration.observe(time.Since(start).Seconds())
+			t.bytesIn += uint64(body.n)
+			t.bytesOut += uint64(cw.n)
+			t.status[cw.status]++
+			metricsMu.Unlock()
+			countBandwidth(transferIdentity(r), body.n, cw.n)
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// metricsHandler GET /metrics，Prometheus 文本格式
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	online := clients.Users()
+	filesMu.RLock()
+	files := len(fileList)
+	filesMu.RUnlock()
+	fmt.Fprintf(&b, "# HELP gochat_online_users Distinct users with at least one WebSocket connection.\n# TYPE gochat_online_users gauge\ngochat_online_users %d\n", online)
+	fmt.Fprintf(&b, "# HELP gochat_files Files in the index.\n# TYPE gochat_files gauge\ngochat_files %d\n", files)
+
+	fmt.Fprintf(&b, "# HELP gochat_ws_bytes_total WebSocket frame payload bytes.\n# TYPE gochat_ws_bytes_total counter\ngochat_ws_bytes_total{direction=\"in\"} %d\ngochat_ws_bytes_total{direction=\"out\"} %d\n", wsBytesIn.Load(), wsBytesOut.Load())
+	fmt.Fprintf(&b, "# HELP gochat_ws_forced_closes_total Connections closed by the writer watchdog after a stalled write.\n# TYPE gochat_ws_forced_closes_total counter\ngochat_ws_forced_closes_total %d\n", forcedCloses.Load())
+	_, usage := bandwidthSnapshot()
+	capped := 0
+	for _, u := range usage {
+		if u.Capped {
+			capped++
+		}
+	}
+	fmt.Fprintf(&b, "# HELP gochat_bandwidth_capped_users Users over the daily bandwidth cap.\n# TYPE gochat_bandwidth_capped_users gauge\ngochat_bandwidth_capped_users %d\n", capped)
+	writeRegistryMetrics(&b)
+
+	metricsMu.Lock()
+	names := make([]string, 0, len(transfers))
+	for name := range transfers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.WriteString("# HELP gochat_transfers_in_flight Transfers currently in progress.\n# TYPE gochat_transfers_in_flight gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "gochat_transfers_in_flight{handler=%q} %d\n", name, transfers[name].inflight.Load())
+	}
+	b.WriteString("# HELP gochat_transfer_bytes_total Bytes transferred, including aborted transfers.\n# TYPE gochat_transfer_bytes_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "gochat_transfer_bytes_total{handler=%q,direction=\"in\"} %d\n", name, transfers[name].bytesIn)
+		fmt.Fprintf(&b, "gochat_transfer_bytes_total{handler=%q,direction=\"out\"} %d\n", name, transfers[name].bytesOut)
+	}
+	b.WriteString("# HELP gochat_transfer_requests_total Transfer requests by status code.\n# TYPE gochat_transfer_requests_total counter\n")
+	for _, name := range names {
+		codes := make([]int, 0, len(transfers[name].status))
+		for c := range transfers[name].status {
+			codes = append(codes, c)
+		}
+		sort.Ints(codes)
+		for _, c := range codes {
+			fmt.Fprintf(&b, "gochat_transfer_requests_total{handler=%q,code=\"%d\"} %d\n", name, c, transfers[name].status[c])
+		}
+	}
+	b.WriteString("# HELP gochat_transfer_size_bytes Size of each transfer.\n# TYPE gochat_transfer_size_bytes histogram\n")
+	for _, name := range names {
+		transfers[name].size.write(&b, "gochat_transfer_size_bytes", fmt.Sprintf("handler=%q", name))
+	}
+	b.WriteString("# HELP gochat_transfer_duration_seconds Duration of each transfer.\n# TYPE gochat_transfer_duration_seconds histogram\n")
+	for _, name := range names {
+		transfers[name].duration.write(&b, "gochat_transfer_duration_seconds", fmt.Sprintf("handler=%q", name))
+	}
+	metricsMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// 用户名校验：userId、/send 的 from、机器人名以及房间名共用同一套规则，
+// 防止冒充 system 之类的系统身份，或用超长、含方向控制符的名字扰乱界面
+
+var strictNames = flag.Bool("strict-names", false, "/send 与 /send/private 的 from 也必须是合法且未保留的名字")
+
+const maxNameLen = 32 // 名字最多字符数
+
+var (
+	errNameEmpty    = errors.New("name is empty")
+	errNameTooLong  = errors.New("name is too long")
+	errNameReserved = errors.New("name is reserved")
+	errNameInvalid  = errors.New("name contains invalid characters")
+)
+
+// 保留名，比较时不区分大小写；机器人名在 reservedName 中另行判断
+var reservedUserNames = map[string]bool{"system": true, "server": true, "admin": true, "administrator": true, "root": true}
+
+// bidi 覆盖/隔离控制符，可把后续文字倒序显示
+func isBidiControl(r rune) bool {
+	return r == '\u061c' || r == '\u200e' || r == '\u200f' || (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// normalizeName NFC 规范化，去掉控制符、bidi 控制符与首尾空白
+func normalizeName(name string) string {
+	name = norm.NFC.String(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || isBidiControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.TrimSpace(name)
+}
+
+func reservedName(name string) bool {
+	return reservedUserNames[strings.ToLower(name)] || strings.EqualFold(name, *assistantName)
+}
+
+// validateName 返回规范化后的名字；保留名与长度越界均视为非法
+func validateName(name string) (string, error) {
+	name, err := checkName(name)
+	if err == nil && reservedName(name) {
+		return "", errNameReserved
+	}
+	return name, err
+}
+
+// validateBotName 机器人名：规则同 validateName，但须已是规范形式，且自己的名字不算保留
+func validateBotName(name string) error {
+	n, err := checkName(name)
+	switch {
+	case err != nil:
+		return err
+	case n != name:
+		return errNameInvalid
+	case reservedUserNames[strings.ToLower(n)]:
+		return errNameReserved
+	}
+	return nil
+}
+
+// checkName 规范化并检查长度与字符，不检查保留名
+func checkName(name string) (string, error) {
+	name = normalizeName(name)
+	switch n := utf8.RuneCountInString(name); {
+	case n == 0:
+		return "", errNameEmpty
+	case n > maxNameLen:
+		return "", errNameTooLong
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) && r != ' ' {
+			return "", errNameInvalid
+		}
+	}
+	return name, nil
+}
+
+// nameTaken 在线用户（含断线保留期内的）中是否已有同名者（不区分大小写），调用方需持有 identityMu
+func nameTaken(name string) bool {
+	if clients.Online(name) {
+		return true
+	}
+	if _, ok := lingering[name]; ok {
+		return true
+	}
+	for _, u := range clients.Snapshot() {
+		if strings.EqualFold(u.ID, name) {
+			return true
+		}
+	}
+	for id := range lingering {
+		if strings.EqualFold(id, name) {
+			return true
+		}
+	}
+	return false
+}
+
+var nameToken = flag.String("name-token", "", "非空时，通过 /ws?name= 声明固定身份须同时带 &token=<该值>；为空时任何人都可以声明")
+
+// requestedIdentity 连接请求的身份。?name= 是严格声明（机器人、看板等集成使用）：不合法或未授权时返回关闭码，
+// 被占用由 hub 在注册时判断；否则 ?uid= 尽量沿用，不合法时返回空，由 hub 随机分配
+func requestedIdentity(r *http.Request) (id string, exact bool, closeCode int) {
+	q := r.URL.Query()
+	if !q.Has("name") {
+		id, err := validateName(q.Get("uid"))
+		if err != nil {
+			id = ""
+		}
+		return id, false, 0
+	}
+	if *nameToken != "" && subtle.ConstantTimeCompare([]byte(q.Get("token")), []byte(*nameToken)) != 1 {
+		return "", false, closeNameForbidden
+	}
+	name, err := validateName(q.Get("name"))
+	if err != nil {
+		return "", false, closeInvalidName
+	}
+	return name, true, 0
+}
+
+const userIDAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+const (
+	minUserIDLength = 4
+	maxUserIDLength = maxNameLen
+)
+
+var userIDLength = flag.Int("user-id-length", 6, "随机分配的 userId 长度（4–32），在线人数很多或希望更难猜测时可加长")
+
+// generateUserID 用 crypto/rand 生成随机 userId。字节值落在 36 的最大整数倍（252）以上时丢弃重取，
+// 每个字符在字母表上等概率，不能据此前分配的 ID 推测后续的 ID
+func generateUserID() string {
+	const limit = 256 / len(userIDAlphabet) * len(userIDAlphabet)
+	n := *userIDLength
+	id := make([]byte, 0, n)
+	buf := make([]byte, n+n/8+1) // 平均约 1.6% 的字节被丢弃，多取一些通常一次就够
+	for len(id) < n {
+		rand.Read(buf)
+		for _, b := range buf {
+			if int(b) < limit && len(id) < n {
+				id = append(id, userIDAlphabet[int(b)%len(userIDAlphabet)])
+			}
+		}
+	}
+	return string(id)
+}
+
+// newUserID 生成合法且未被占用的随机 userId，调用方需持有 identityMu，检查与登记在同一把锁下完成；
+// 与在线（含断线保留期内）用户的 userId 或昵称重复时重新生成并记录日志
+func newUserID() string {
+	for {
+		id := generateUserID()
+		switch {
+		case reservedName(id):
+		case nameTaken(id) || nickTaken("", id):
+			log.Printf("⚠️ 随机生成的 userId %s 与在线用户重复，已重新生成", id)
+		default:
+			return id
+		}
+	}
+}
+
+// checkFromName -strict-names 下校验 /send 的 from 并替换为规范化后的名字；返回 false 时已写出错误响应
+func checkFromName(w http.ResponseWriter, r *http.Request, from *string) bool {
+	if !*strictNames {
+		return true
+	}
+	name, err := validateName(*from)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_name", "Invalid 'from': "+err.Error(), map[string]interface{}{"maxLength": maxNameLen})
+		return false
+	}
+	*from = name
+	return true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 名字校验的公共表：同一输入分别经过 ?uid=（不合法时回退为随机分配）、-strict-names 下 /send 的 from、
+// 机器人名（-assistant-name）与房间名。id 为规范化后的 userId/from，空表示拒绝
+var nameCases = []struct {
+	in   string
+	id   string
+	bot  bool
+	room bool
+}{
+	{in: "alice", id: "alice", bot: true, room: true},
+	{in: "  Alice ", id: "Alice", room: true},
+	{in: "张三", id: "张三", bot: true},
+	{in: "e\u0301", id: "\u00e9"},
+	{in: "evil\u202etxt.exe", id: "eviltxt.exe"},
+	{in: "a\x00b", id: "ab"},
+	{in: strings.Repeat("a", 32), id: strings.Repeat("a", 32), bot: true, room: true},
+	{in: strings.Repeat("a", 33)},
+	{in: strings.Repeat("张", 33)},
+	{in: "", room: true}, // 空房间名表示默认房间
+	{in: "\u202e\u200f "},
+	{in: "system"},
+	{in: "SYSTEM"},
+	{in: "Admin"},
+	{in: "root"},
+	{in: "bot", bot: true}, // 助手自己的名字对用户与房间保留
+}
+
+func TestNameValidation(t *testing.T) {
+	setFlag(t, strictNames, true)
+	for _, tt := range nameCases {
+		r := httptest.NewRequest("GET", "/ws?uid="+url.QueryEscape(tt.in), nil)
+		if id, _, _ := requestedIdentity(r); id != tt.id {
+			t.Errorf("?uid=%q: got %q, want %q", tt.in, id, tt.id)
+		}
+
+		from := tt.in
+		w := httptest.NewRecorder()
+		ok := checkFromName(w, httptest.NewRequest("POST", "/send", nil), &from)
+		if ok != (tt.id != "") || (ok && from != tt.id) {
+			t.Errorf("/send from=%q: ok=%v from=%q (status %d), want %q", tt.in, ok, from, w.Code, tt.id)
+		}
+
+		if err := validateBotName(tt.in); (err == nil) != tt.bot {
+			t.Errorf("-assistant-name %q: err=%v, want valid=%v", tt.in, err, tt.bot)
+		}
+
+		if _, ok := normalizeRoom(tt.in); ok != tt.room {
+			t.Errorf("room %q: valid=%v, want %v", tt.in, ok, tt.room)
+		}
+	}
+}
+
+// 长度设为 1 时只有 36 个可能的 ID：占用其中 35 个（含一个断线保留中的），newUserID 只能返回剩下的那个
+func TestNewUserIDSkipsTaken(t *testing.T) {
+	saved := *userIDLength
+	*userIDLength = 1
+	defer func() { *userIDLength = saved }()
+
+	const free = "Q"
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	var seeded []*client
+	for _, r := range userIDAlphabet {
+		id := string(r)
+		switch id {
+		case free:
+		case "Z":
+			lingering[id] = &lingerer{}
+		default:
+			c := testClient()
+			clients.Add(c, id)
+			seeded = append(seeded, c)
+		}
+	}
+	defer func() {
+		delete(lingering, "Z")
+		for _, c := range seeded {
+			clients.Remove(c.conn)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		if id := newUserID(); id != free {
+			t.Fatalf("newUserID() = %q, want the only free ID %q", id, free)
+		}
+	}
+}
+
+// 卡方检验：各字符出现次数与均匀分布的偏差。自由度 35，p = 0.001 的临界值约为 66.6
+func TestGenerateUserIDUniform(t *testing.T) {
+	const ids = 12000
+	counts := make(map[byte]int)
+	total := 0
+	for i := 0; i < ids; i++ {
+		id := generateUserID()
+		if len(id) != *userIDLength {
+			t.Fatalf("len(%q) = %d, want %d", id, len(id), *userIDLength)
+		}
+		for j := 0; j < len(id); j++ {
+			if strings.IndexByte(userIDAlphabet, id[j]) < 0 {
+				t.Fatalf("%q contains %q, not in the alphabet", id, id[j])
+			}
+			counts[id[j]]++
+			total++
+		}
+	}
+	expected := float64(total) / float64(len(userIDAlphabet))
+	chi2 := 0.0
+	for i := 0; i < len(userIDAlphabet); i++ {
+		d := float64(counts[userIDAlphabet[i]]) - expected
+		chi2 += d * d / expected
+	}
+	if chi2 > 66.6 {
+		t.Fatalf("chi-square = %.1f over %d characters, distribution is not uniform (counts %v)", chi2, total, counts)
+	}
+}
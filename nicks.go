@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+)
+
+// 昵称：用户可通过 {"type":"nick","data":{"name":"alice"}} 设置显示用的昵称，身份仍是 userId，
+// 信令、私聊、/send 的 from 等一律按 userId 寻址。昵称与在线（含断线保留期内）用户的昵称或 userId
+// 不区分大小写地重复时拒绝；按 userId 保存，携带相同 uid 或 resume 令牌重连后保留，
+// 重连时若已被他人占用则清除。改名后广播 rename 与新的用户列表
+
+var userNicks = newExpiringMap[string, string]("nicks", colorRetention, registryMaxEntries) // userId -> 昵称
+
+type nickRequest struct {
+	Name string `json:"name"`
+}
+
+func init() {
+	registerWSType(wsType{
+		name:     "nick",
+		payload:  func() interface{} { return new(nickRequest) },
+		rate:     rateControl,
+		minProto: 2,
+		feature:  "nick",
+		handle:   handleNick,
+	})
+}
+
+// nickOf 用户的昵称，未设置时为空
+func nickOf(userID string) string {
+	nick, _ := userNicks.Get(userID)
+	return nick
+}
+
+// displayName 系统提示中显示的名字：有昵称时为昵称，否则为 userId
+func displayName(userID string) string {
+	if nick := nickOf(userID); nick != "" {
+		return nick
+	}
+	return userID
+}
+
+// nickTaken 除 userID 本人外，在线或断线保留期内的用户是否已用该名字作昵称或 userId，调用方需持有 identityMu
+func nickTaken(userID, nick string) bool {
+	taken := func(id string) bool {
+		return id != userID && (strings.EqualFold(id, nick) || strings.EqualFold(nickOf(id), nick))
+	}
+	for _, u := range clients.Snapshot() {
+		if taken(u.ID) {
+			return true
+		}
+	}
+	for id := range lingering {
+		if taken(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimNick 为刚注册的连接续期昵称，已被他人占用时清除，调用方为 hub 协程且持有 identityMu
+func claimNick(userID string) {
+	nick := nickOf(userID)
+	switch {
+	case nick == "":
+	case nickTaken(userID, nick):
+		userNicks.Delete(userID)
+	default:
+		userNicks.Set(userID, nick)
+	}
+}
+
+// handleNick 校验并保存昵称，失败时回 error 帧，成功后广播 rename
+func handleNick(f *wsFrame) {
+	userID := f.c.userID
+	fail := func(code, reason string) {
+		sendWSError(f.c, "nick", code, reason, map[string]interface{}{"maxLength": maxNameLen})
+	}
+	// 去掉控制符、bidi 控制符与首尾空白后再计长度
+	name := normalizeName(f.payload.(*nickRequest).Name)
+	switch n := utf8.RuneCountInString(name); {
+	case n == 0:
+		fail("nick_empty", "nickname is empty")
+		return
+	case n > maxNameLen:
+		fail("nick_too_long", "nickname is too long")
+		return
+	case reservedName(name):
+		fail("nick_reserved", "nickname is reserved")
+		return
+	}
+
+	identityMu.Lock()
+	old := displayName(userID)
+	if nickTaken(userID, name) {
+		identityMu.Unlock()
+		fail("nick_taken", "nickname is already in use")
+		return
+	}
+	changed := nickOf(userID) != name
+	userNicks.Set(userID, name)
+	identityMu.Unlock()
+
+	if !changed {
+		return
+	}
+	broadcastRename(userID, old, name)
+	broadcastUsers()
+}
+
+// broadcastRename {"type":"rename","data":{"userId","old","new"}}，发给所有认识该类消息的连接（含本人）
+func broadcastRename(userID, oldName, newName string) {
+	frame := mustMarshal(map[string]interface{}{
+		"type": "rename",
+		"data": map[string]string{"userId": userID, "old": oldName, "new": newName},
+	})
+	hubOutbound <- outbound{ctx: context.Background(), msg: WSMessage{Type: "rename", Category: catPresence}, encode: func(c *client) []byte {
+		if !c.supports("rename") {
+			return nil
+		}
+		return frame
+	}}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// 出站请求：消息转换、助手接口、追踪上报以及 sync/watch/send 等子命令都经由同一个 Transport，
+// 共享连接池与超时设置。代理默认取 HTTP_PROXY / HTTPS_PROXY / NO_PROXY，
+// -proxy-url / -proxy-no 非空时覆盖对应的环境变量。
+// 不设整体超时：助手流式回复、大文件上传可能持续很久，由各调用方通过 ctx 控制
+
+var (
+	proxyURL = flag.String("proxy-url", "", "出站请求使用的代理（http://、https:// 或 socks5://），覆盖 HTTP_PROXY / HTTPS_PROXY")
+	proxyNo  = flag.String("proxy-no", "", "不走代理的主机列表（逗号分隔，格式同 NO_PROXY），覆盖 NO_PROXY")
+)
+
+var (
+	proxyOnce sync.Once
+	proxyFunc func(*url.URL) (*url.URL, error)
+
+	outboundTransport = &http.Transport{
+		Proxy: outboundProxy,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	outboundClient = &http.Client{Transport: outboundTransport}
+)
+
+// proxyConfig 环境变量叠加命令行覆盖后的代理配置
+func proxyConfig() *httpproxy.Config {
+	cfg := httpproxy.FromEnvironment()
+	if *proxyURL != "" {
+		cfg.HTTPProxy, cfg.HTTPSProxy = *proxyURL, *proxyURL
+	}
+	if *proxyNo != "" {
+		cfg.NoProxy = *proxyNo
+	}
+	return cfg
+}
+
+// outboundProxy 首次请求时按 proxyConfig 生成，之后不再读取环境变量
+func outboundProxy(req *http.Request) (*url.URL, error) {
+	proxyOnce.Do(func() { proxyFunc = proxyConfig().ProxyFunc() })
+	return proxyFunc(req.URL)
+}
+
+// checkProxy 校验配置的代理地址并尝试建立 TCP 连接，返回实际使用的代理（已隐去密码）
+func checkProxy() (string, error) {
+	cfg := proxyConfig()
+	var used []string
+	seen := make(map[string]bool)
+	for _, raw := range []string{cfg.HTTPSProxy, cfg.HTTPProxy} {
+		if raw == "" || seen[raw] {
+			continue
+		}
+		seen[raw] = true
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			// 与 httpproxy 一致：不带协议的地址按 http:// 处理
+			if u, err = url.Parse("http://" + raw); err != nil || u.Host == "" {
+				return "", fmt.Errorf("invalid proxy %q", raw)
+			}
+		}
+		var port string
+		switch u.Scheme {
+		case "http":
+			port = cmp.Or(u.Port(), "80")
+		case "https":
+			port = cmp.Or(u.Port(), "443")
+		case "socks5":
+			port = cmp.Or(u.Port(), "1080")
+		default:
+			return "", fmt.Errorf("unsupported proxy scheme %q (want http, https or socks5)", u.Scheme)
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), 3*time.Second)
+		if err != nil {
+			return "", fmt.Errorf("proxy %s unreachable: %v", u.Redacted(), err)
+		}
+		conn.Close()
+		used = append(used, u.Redacted())
+	}
+	if len(used) == 0 {
+		return "", nil
+	}
+	return strings.Join(used, ", "), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// 在线状态：连接发来任何消息帧都算活跃（心跳 pong 不算，浏览器会自动回复），
+// 超过 -away-after 没有消息的标记为 away，再次发来消息立即恢复 active。
+// 状态随 v2 用户列表下发，变化时重新广播用户列表
+
+var presenceText = flag.Bool("presence-text", true, "上下线时广播文字提示（system 消息）；关闭后只发送结构化的 presence 事件")
+
+var awayAfter = flag.Duration("away-after", 5*time.Minute, "连接多久没有发来任何消息后标记为离开（away）；0 表示不标记")
+
+const (
+	statusActive = "active"
+	statusAway   = "away"
+)
+
+// touch 记录一次入站消息；此前为 away 时立即广播恢复
+func (c *client) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+	if c.away.CompareAndSwap(true, false) {
+		broadcastUsers()
+	}
+}
+
+func (c *client) status() string {
+	if c.away.Load() {
+		return statusAway
+	}
+	return statusActive
+}
+
+// startAwayTicker 定期检查空闲连接，有人转为 away 时广播一次用户列表
+func startAwayTicker() {
+	if *awayAfter <= 0 {
+		return
+	}
+	interval := max(min(*awayAfter/4, 15*time.Second), time.Second)
+	go func() {
+		for range time.Tick(interval) {
+			markAway()
+		}
+	}()
+}
+
+func markAway() {
+	cutoff := time.Now().Add(-*awayAfter).UnixNano()
+	flipped := false
+	for _, c := range clients.Conns() {
+		if c.lastActive.Load() < cutoff && c.away.CompareAndSwap(false, true) {
+			flipped = true
+		}
+	}
+	if flipped {
+		broadcastUsers()
+	}
+}
+
+// presenceChange 一次上线或离线，由 hub 在修改在线列表时记录，online 与映射的修改在同一把锁下取得
+type presenceChange struct {
+	Event  string `json:"event"` // join / leave
+	UserID string `json:"userId"`
+	Nick   string `json:"nick,omitempty"`
+	Online int    `json:"online"`
+}
+
+var hubPresence []presenceChange // 只由 hub 协程读写，每轮处理结束时由 flushPresence 发出
+
+// queuePresence 调用方为 hub 协程且持有 identityMu
+func queuePresence(event, userID string) {
+	hubPresence = append(hubPresence, presenceChange{Event: event, UserID: userID, Nick: nickOf(userID), Online: clients.Users()})
+}
+
+// flushPresence 在 hub 协程中按发生顺序广播上下线：v2 连接收到
+// {"type":"presence","data":{"event","userId","online"}}，-presence-text 开启时所有人另收到文字提示。
+// 投递中断开的连接会追加新的离线，一并发出
+func flushPresence() {
+	for len(hubPresence) > 0 {
+		p := hubPresence[0]
+		hubPresence = hubPresence[1:]
+		frame := mustMarshal(map[string]interface{}{"type": "presence", "data": p})
+		hubDeliver(outbound{ctx: context.Background(), msg: WSMessage{Type: "presence", Category: catPresence}, encode: func(c *client) []byte {
+			if !c.supports("presence") {
+				return nil
+			}
+			return frame
+		}})
+		if !*presenceText {
+			continue
+		}
+		text := fmt.Sprintf("👥 用户 %s 上线，当前在线: %d", displayName(p.UserID), p.Online)
+		if p.Event == "leave" {
+			text = fmt.Sprintf("👋 用户 %s 离线，当前在线: %d", displayName(p.UserID), p.Online)
+		}
+		hubDeliver(outbound{ctx: context.Background(), msg: WSMessage{
+			Type:     "message",
+			Category: catPresence,
+			Data:     Message{Text: text, From: "system", Time: time.Now().Format("15:04:05")},
+		}})
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置预设：gochat config export 把当前生效的全部参数导出为 YAML，-config 启动时加载，
+// POST /api/admin/config/import 在运行中应用其中可热更新的部分。
+// 键名即命令行参数名（不带 -）；命令行显式给出的参数优先于文件。
+// 密钥不写入文件，导出为 ${环境变量名} 的引用，加载时从环境变量读取；导入结果与日志只列键名，不含取值
+
+var configPath = flag.String("config", "", "启动时加载的配置预设（YAML，见 gochat config export），命令行参数优先")
+
+// secretEnv 密钥参数及导出时引用的环境变量；代理地址可能内嵌账号密码
+var secretEnv = map[string]string{
+	"admin-token": "GOCHAT_ADMIN_TOKEN",
+	"name-token":  "GOCHAT_NAME_TOKEN",
+	"proxy-url":   "GOCHAT_PROXY_URL",
+}
+
+// notExported 只对单次运行有意义的参数
+var notExported = map[string]bool{"config": true, "check-config": true, "force-unlock": true}
+
+// reloadable 可在运行中修改的参数及其校验；其余参数修改后需要重启
+var reloadable = map[string]func(flag.Value) error{
+	"max-size":               positiveSize,
+	"per-user-bandwidth-cap": nonNegative,
+	"max-clients":            nonNegative,
+	"max-conns-per-ip":       nonNegative,
+}
+
+// liveInt 可经配置导入在运行中修改的整数参数
+type liveInt struct{ atomic.Int64 }
+
+func (v *liveInt) String() string { return strconv.FormatInt(v.Load(), 10) }
+
+func (v *liveInt) Set(s string) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", s)
+	}
+	v.Store(n)
+	return nil
+}
+
+func nonNegative(v flag.Value) error {
+	if n, _ := strconv.ParseInt(v.String(), 10, 64); n < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	return nil
+}
+
+func positiveSize(v flag.Value) error {
+	if n, _ := strconv.ParseInt(v.String(), 10, 64); n <= 0 {
+		return fmt.Errorf("must be positive")
+	}
+	return nil
+}
+
+// runConfig 子命令：gochat config export [参数...] > preset.yaml
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "export" {
+		fmt.Fprintln(os.Stderr, "用法: gochat config export [-config preset.yaml] [其他参数...] > preset.yaml")
+		return 2
+	}
+	flag.CommandLine.Parse(args[1:])
+	if err := loadConfigFile(*configPath); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	if err := exportConfig(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 导出配置失败: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// exportConfig 按键名排序输出全部参数，密钥替换为环境变量引用
+func exportConfig(w io.Writer) error {
+	values := make(map[string]interface{})
+	flag.VisitAll(func(f *flag.Flag) {
+		if notExported[f.Name] {
+			return
+		}
+		if env, ok := secretEnv[f.Name]; ok {
+			if f.Value.String() == "" {
+				values[f.Name] = ""
+			} else {
+				values[f.Name] = "${" + env + "}"
+			}
+			return
+		}
+		values[f.Name] = typedValue(f.Value)
+	})
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	io.WriteString(w, "# gochat 配置预设，由 gochat config export 生成\n# 使用: gochat -config preset.yaml，或 POST /api/admin/config/import\n# 密钥以 ${环境变量} 引用，加载前请设置对应的环境变量\n")
+	_, err = w.Write(data)
+	return err
+}
+
+// typedValue 布尔与数字按原类型输出，其余（时长、大小、列表）输出字符串
+func typedValue(v flag.Value) interface{} {
+	s := v.String()
+	if g, ok := v.(flag.Getter); ok {
+		switch g.Get().(type) {
+		case bool, int, int64, uint, uint64, float64:
+			return g.Get()
+		}
+	}
+	switch v := v.(type) {
+	case *liveInt:
+		return v.Load()
+	case *ByteSize:
+		return v.Load()
+	}
+	return s
+}
+
+// parsePreset 解析 YAML（JSON 亦可）为 键 -> 字符串取值
+func parsePreset(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid preset: %v", err)
+	}
+	out := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case nil:
+			out[k] = ""
+		case []interface{}:
+			parts := make([]string, len(v))
+			for i, p := range v {
+				parts[i] = fmt.Sprint(p)
+			}
+			out[k] = strings.Join(parts, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("invalid preset: %s must be a scalar or a list", k)
+		default:
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out, nil
+}
+
+// resolveSecret 展开 ${NAME} 形式的环境变量引用
+func resolveSecret(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, "${")
+	if !ok || !strings.HasSuffix(name, "}") {
+		return value, nil
+	}
+	name = strings.TrimSuffix(name, "}")
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return v, nil
+}
+
+// loadConfigFile 启动时加载预设，跳过命令行已给出的参数；任何一项无效都拒绝启动
+func loadConfigFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置预设失败: %v", err)
+	}
+	values, err := parsePreset(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	keys := sortedKeys(values)
+	for _, k := range keys {
+		if explicit[k] {
+			continue
+		}
+		if flag.Lookup(k) == nil || notExported[k] {
+			return fmt.Errorf("%s: unknown option %q", path, k)
+		}
+		v, err := resolveSecret(values[k])
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, k, err)
+		}
+		// 出错信息只含键名，避免密钥进入日志
+		if err := flag.Set(k, v); err != nil {
+			if _, secret := secretEnv[k]; secret {
+				return fmt.Errorf("%s: invalid value for %s", path, k)
+			}
+			return fmt.Errorf("%s: %s: %v", path, k, err)
+		}
+	}
+	log.Printf("⚙️  已加载配置预设 %s（%d 项）", path, len(keys))
+	return nil
+}
+
+// ImportResult 配置导入的结果，只含键名与拒绝原因
+type ImportResult struct {
+	Applied         []string          `json:"applied"`
+	Unchanged       []string          `json:"unchanged"`
+	RestartRequired []string          `json:"restartRequired"`
+	Rejected        map[string]string `json:"rejected"`
+}
+
+// importConfig 校验每一项：可热更新的立即生效，其余与当前值不同的列为需要重启（不修改）
+func importConfig(values map[string]string) ImportResult {
+	res := ImportResult{Applied: []string{}, Unchanged: []string{}, RestartRequired: []string{}, Rejected: map[string]string{}}
+	for _, k := range sortedKeys(values) {
+		f := flag.Lookup(k)
+		if f == nil || notExported[k] {
+			res.Rejected[k] = "unknown option"
+			continue
+		}
+		_, secret := secretEnv[k]
+		v, err := resolveSecret(values[k])
+		if err != nil {
+			res.Rejected[k] = err.Error()
+			continue
+		}
+		// 在同类型的新值上试解析，既校验又得到规范化的取值用于比较
+		scratch := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+		if err := scratch.Set(v); err != nil {
+			if secret {
+				err = fmt.Errorf("invalid value")
+			}
+			res.Rejected[k] = err.Error()
+			continue
+		}
+		if validate := reloadable[k]; validate != nil {
+			if err := validate(scratch); err != nil {
+				res.Rejected[k] = err.Error()
+				continue
+			}
+		}
+		switch {
+		case scratch.String() == f.Value.String():
+			res.Unchanged = append(res.Unchanged, k)
+		case reloadable[k] != nil:
+			f.Value.Set(v)
+			res.Applied = append(res.Applied, k)
+		default:
+			res.RestartRequired = append(res.RestartRequired, k)
+		}
+	}
+	return res
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// adminConfigImportHandler POST /api/admin/config/import，请求体为 YAML 或 JSON 预设
+func adminConfigImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	if !isAdmin(r) {
+		errAdminRequired(w, r)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "preset_too_large", "Preset must be at most 1 MB", nil)
+		return
+	}
+	values, err := parsePreset(bytes.TrimSpace(data))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_preset", err.Error(), nil)
+		return
+	}
+	res := importConfig(values)
+	log.Printf("⚙️  已导入配置：生效 %v，需重启 %v，拒绝 %d 项", res.Applied, res.RestartRequired, len(res.Rejected))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
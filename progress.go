@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 上传进度：POST /upload?progress={token} 时记录已接收字节，
+// 另一个连接可轮询 GET /upload/progress/{token}
+
+const (
+	progressKeepAfterDone = 30 * time.Second // 完成后保留多久供最后一次轮询
+	progressMaxAge        = time.Hour        // 未正常结束的记录最长保留时间
+)
+
+var progressTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+type uploadProgress struct {
+	received atomic.Int64
+	total    int64
+	done     atomic.Bool
+}
+
+var progresses = newExpiringMap[string, *uploadProgress]("upload_progress", progressMaxAge, 10000)
+
+type progressReader struct {
+	io.ReadCloser
+	p *uploadProgress
+}
+
+func (pr progressReader) Read(b []byte) (int, error) {
+	n, err := pr.ReadCloser.Read(b)
+	pr.p.received.Add(int64(n))
+	return n, err
+}
+
+// trackUploadProgress 注册进度记录并包装请求体；返回的函数在上传结束时调用。
+// 令牌格式不对或正在使用时写出错误并返回 ok=false
+func trackUploadProgress(w http.ResponseWriter, r *http.Request, token string) (finish func(), ok bool) {
+	if !progressTokenRe.MatchString(token) {
+		writeError(w, r, http.StatusBadRequest, "invalid_progress_token", "Invalid progress token (8-64 chars of A-Z a-z 0-9 _ -)", nil)
+		return nil, false
+	}
+	p := &uploadProgress{total: r.ContentLength}
+	busy := false
+	progresses.Update(token, func(old *uploadProgress, ok bool) *uploadProgress {
+		if ok && !old.done.Load() {
+			busy = true
+			return old
+		}
+		return p
+	})
+	if busy {
+		writeError(w, r, http.StatusConflict, "progress_token_in_use", "Progress token already in use", nil)
+		return nil, false
+	}
+
+	r.Body = progressReader{ReadCloser: r.Body, p: p}
+	return func() {
+		p.done.Store(true)
+		time.AfterFunc(progressKeepAfterDone, func() {
+			progresses.DeleteIf(token, func(v *uploadProgress) bool { return v == p })
+		})
+	}, true
+}
+
+// uploadProgressHandler GET /upload/progress/{token}
+func uploadProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, "/upload/progress/")
+	p, ok := progresses.Get(token)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "progress_not_found", "Unknown or expired progress token", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"received": p.received.Load(),
+		"total":    p.total, // 请求未带 Content-Length 时为 -1
+		"done":     p.done.Load(),
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket 协议版本：客户端通过子协议 gochat.v1 / gochat.v2（或查询参数 ?proto=N）声明，
+// 协商结果记在连接上。v1 连接只下发 v1 认识的消息类型，用户列表保持逗号分隔字符串；
+// 既没有 ?proto 也没有可识别子协议的连接按 -default-proto 处理，默认为最新版本
+
+const protocolVersion = 2
+
+// httpFeatures 不对应客户端消息类型的能力，其余由 registerWSType 登记
+var httpFeatures = []string{"edit", "emoji", "file_comment", "presence", "upload_token", "users_list"}
+
+// protocolFeatures 随 init 下发，供客户端判断服务端能力，按名称排序
+func protocolFeatures() []string {
+	list := append([]string(nil), httpFeatures...)
+	for _, t := range wsTypes {
+		if t.feature != "" {
+			list = append(list, t.feature)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// v1 前端认识的消息类型
+var v1Types = map[string]bool{"init": true, "message": true, "users": true, "signal": true, "private": true}
+
+var wsSubprotocols = []string{"gochat.v2", "gochat.v1"}
+
+var defaultProto = flag.Int("default-proto", protocolVersion, "未声明协议版本（没有 ?proto，也没有可识别的 gochat.vN 子协议）的连接按哪个版本处理；仍有不声明版本的旧版前端时设为 1")
+
+// negotiateProtocol 查询参数优先，其次是握手协商出的子协议，都没有时为 -default-proto
+func negotiateProtocol(r *http.Request, conn *websocket.Conn) int {
+	if v, err := strconv.Atoi(r.URL.Query().Get("proto")); err == nil && v >= 1 {
+		return min(v, protocolVersion)
+	}
+	if p, ok := strings.CutPrefix(conn.Subprotocol(), "gochat.v"); ok {
+		if v, err := strconv.Atoi(p); err == nil && v >= 1 {
+			return min(v, protocolVersion)
+		}
+	}
+	return *defaultProto
+}
+
+// protocolCounts 各协议版本的在线连接数，键为 v1、v2，见 /info
+func protocolCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, c := range clients.Conns() {
+		counts["v"+strconv.Itoa(c.proto)]++
+	}
+	return counts
+}
+
+// supports 该连接能否理解某类消息：v1 的类型总是可以；发过 hello 的按声明的能力，否则按协议版本
+func (c *client) supports(typ string) bool {
+	if v1Types[typ] {
+		return true
+	}
+	if h := c.hello.Load(); h != nil {
+		return h.events[typ]
+	}
+	return c.proto >= 2
+}
+
+// structuredUsers 该连接是否收结构化的用户列表：发过 hello 的看是否声明了 users_list
+func (c *client) structuredUsers() bool {
+	if h := c.hello.Load(); h != nil {
+		return h.events["users_list"]
+	}
+	return c.proto >= 2 || !*legacyUsersText
+}
+
+// userSupports 按 userId 判断，该用户任一连接能理解即可，不在线时返回 false
+func userSupports(userID, typ string) bool {
+	for _, c := range clients.ByID(userID) {
+		if c.supports(typ) {
+			return true
+		}
+	}
+	return false
+}
+
+var legacyUsersText = flag.Bool("legacy-users-text", true, "向 v1 客户端发送旧格式的在线用户列表（逗号分隔的 text）；关闭后所有客户端都收到结构化列表。兼容选项，将在下个版本移除")
+
+// onlineUser 在线用户列表中的一项
+type onlineUser struct {
+	ID          string    `json:"id"`
+	Nick        string    `json:"nick,omitempty"`
+	Device      string    `json:"device"`
+	Color       string    `json:"color"`
+	Status      string    `json:"status"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// usersEvent {"type":"users","data":{"room":"lobby","users":[...],"count":N}}，按 id 排序，避免客户端看到无意义的重排
+type usersEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		Room  string       `json:"room"`
+		Users []onlineUser `json:"users"`
+		Count int          `json:"count"`
+	} `json:"data"`
+}
+
+var usersDebounce = flag.Duration("users-debounce", 250*time.Millisecond, "合并在线用户列表广播的时间窗：安静一段时间后的第一次变化立即广播，之后窗口内的变化合并为一次；0 表示每次变化都立即广播")
+
+// usersDirty 在线用户列表有变化待广播，容量为 1，多次标记合并为一次
+var usersDirty = make(chan struct{}, 1)
+
+// broadcastUsers 上下线、接管与状态变化时调用，标记在线用户列表需要重新广播，不阻塞
+func broadcastUsers() {
+	if *usersDebounce <= 0 {
+		sendUsers()
+		return
+	}
+	select {
+	case usersDirty <- struct{}{}:
+	default:
+	}
+}
+
+// startUsersBroadcaster 由单个协程发送用户列表：收到标记立即发送当前快照，随后至少间隔 -users-debounce，
+// 间隔内的标记在结束时再合并发送一次，最后一次发送的总是最新状态
+func startUsersBroadcaster() {
+	if *usersDebounce <= 0 {
+		return
+	}
+	go func() {
+		for range usersDirty {
+			sendUsers()
+			time.Sleep(*usersDebounce)
+		}
+	}()
+}
+
+// sendUsers 按房间广播在线用户列表的当前快照，每个连接收到所在各房间的列表，同一用户的多个连接只列一次。
+// v2 收到结构化列表；v1 在 -legacy-users-text 开启时仍收到逗号分隔的 text，且只有默认房间的
+func sendUsers() {
+	byRoom := map[string][]ConnInfo{defaultRoom: nil}
+	for _, c := range distinctUsers(connSnapshot(false, false)) {
+		for _, room := range c.Rooms {
+			byRoom[room] = append(byRoom[room], c)
+		}
+	}
+	rooms := make([]string, 0, len(byRoom))
+	for room := range byRoom {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	for _, room := range rooms {
+		sendRoomUsers(room, byRoom[room])
+	}
+}
+
+// sendRoomUsers 把一个房间的成员列表发给该房间的成员
+func sendRoomUsers(room string, list []ConnInfo) {
+	ev := usersEvent{Type: "users"}
+	ev.Data.Room = room
+	ev.Data.Users = make([]onlineUser, len(list))
+	ids := make([]string, len(list))
+	for i, c := range list {
+		ev.Data.Users[i] = onlineUser{ID: c.UserID, Nick: c.Nick, Device: c.Device, Color: c.Color, Status: c.Status, ConnectedAt: c.ConnectedAt}
+		ids[i] = c.UserID
+	}
+	ev.Data.Count = len(list)
+	structured, _ := json.Marshal(ev)
+	base := WSMessage{Type: "users", Data: Message{ID: newMessageID(), Text: strings.Join(ids, ","), From: "system", Time: time.Now().Format("15:04:05")}}
+	legacy, _ := json.Marshal(base)
+
+	hubOutbound <- outbound{ctx: context.Background(), msg: base, encode: func(c *client) []byte {
+		switch {
+		case !c.inRoom(room):
+			return nil
+		case !c.structuredUsers() && room != defaultRoom:
+			return nil
+		case !c.structuredUsers():
+			return legacy
+		}
+		return structured
+	}}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// v1 前端按字段解析 init、users、message 三种帧，这里逐字节固定它们的输出，
+// 只把每次运行都会变化的值替换为占位符
+
+var goldenVolatile = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`"(id|resumeToken|sessionKey|token)":"[0-9a-f]+"`), `"$1":"<hex>"`},
+	{regexp.MustCompile(`"time":"\d\d:\d\d:\d\d"`), `"time":"<hh:mm:ss>"`},
+	{regexp.MustCompile(`"(at|seq|roomSeq|lobby)":\d+`), `"$1":<n>`},
+	{regexp.MustCompile(`"color":"#[0-9A-F]{6}"`), `"color":"<color>"`},
+}
+
+func normalizeGolden(frame []byte) string {
+	for _, v := range goldenVolatile {
+		frame = v.re.ReplaceAll(frame, []byte(v.repl))
+	}
+	return string(frame)
+}
+
+const (
+	goldenV1Init    = `{"capabilities":{"protocolVersion":2,"features":["color","delete","dnd","edit","emoji","file_comment","file_offer","nick","presence","receipts","relay","resync","rooms","typing","upload_token","users_list"],"messageTypes":[{"type":"color","minProto":2,"relayed":false,"rateClass":"control"},{"type":"delete","minProto":2,"relayed":false,"rateClass":"control","required":["id"]},{"type":"delivered","minProto":2,"relayed":false,"rateClass":"relay"},{"type":"dnd","minProto":1,"relayed":false,"rateClass":"control"},{"type":"file_offer","minProto":1,"relayed":true,"rateClass":"control"},{"type":"file_offer_accept","minProto":1,"relayed":true,"rateClass":"control"},{"type":"file_offer_decline","minProto":1,"relayed":true,"rateClass":"control"},{"type":"hello","minProto":1,"relayed":false,"rateClass":"control"},{"type":"join","minProto":2,"relayed":false,"rateClass":"control","required":["room"]},{"type":"leave","minProto":2,"relayed":false,"rateClass":"control","required":["room"]},{"type":"message","minProto":1,"relayed":false,"rateClass":"chat"},{"type":"nick","minProto":2,"relayed":false,"rateClass":"control"},{"type":"relay_cancel","minProto":2,"relayed":false,"rateClass":"control","required":["sessionId"]},{"type":"relay_start","minProto":2,"relayed":false,"rateClass":"control","required":["sessionId"]},{"type":"resync","minProto":2,"relayed":false,"rateClass":"control"},{"type":"signal","minProto":1,"relayed":true,"rateClass":"relay","required":["type","to"]},{"type":"token_refresh","minProto":1,"relayed":false,"rateClass":"control"},{"type":"typing","minProto":2,"relayed":false,"rateClass":"none"},{"type":"typing_stop","minProto":2,"relayed":false,"rateClass":"none"},{"type":"undelete","minProto":2,"relayed":false,"rateClass":"control","required":["id"]}],"maxUploadSize":52428800,"allowedTypes":["*/*"],"requireExtension":true,"maxMessageLength":4000,"maxCommentLength":500,"uploadTokenRequired":false,"rooms":false,"resumableUploads":false,"fileOffers":true,"deleteGrace":30},"color":"<color>","dnd":{"active":false},"emoji":{},"features":["color","delete","dnd","edit","emoji","file_comment","file_offer","nick","presence","receipts","relay","resync","rooms","typing","upload_token","users_list"],"nick":"","protocolVersion":2,"reconnect":{"baseMs":1000,"maxMs":30000,"jitter":0.5},"resumeToken":"<hex>","resumed":false,"roomSeqs":{"lobby":<n>},"rooms":["lobby"],"seq":<n>,"sessionKey":"<hex>","type":"init","uploadToken":{"expiresIn":600,"token":"<hex>"},"userId":"gold"}`
+	goldenV1Users   = `{"type":"users","data":{"id":"<hex>","text":"gold,other","from":"system","time":"<hh:mm:ss>"}}`
+	goldenV1Message = `{"type":"message","data":{"id":"<hex>","text":"hi","from":"other","time":"<hh:mm:ss>","at":<n>,"color":"<color>","room":"lobby"},"roomSeq":<n>,"category":"chat"}`
+)
+
+func TestV1GoldenFrames(t *testing.T) {
+	srv := newTestServer(t, nil)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(srv, "uid=gold&proto=1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, init, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := normalizeGolden(init); got != goldenV1Init {
+		t.Errorf("v1 init:\n got %s\nwant %s", got, goldenV1Init)
+	}
+
+	other := dialWS(t, srv, "uid=other&proto=1")
+	other.sendJSON(map[string]interface{}{"type": "message", "data": map[string]string{"text": "hi"}})
+
+	var users, message []byte
+	for users == nil || message == nil {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("users %q, message %q: %v", users, message, err)
+		}
+		switch {
+		case bytes.HasPrefix(data, []byte(`{"type":"users"`)) && bytes.Contains(data, []byte(`"text":"gold,other"`)):
+			users = data
+		case bytes.HasPrefix(data, []byte(`{"type":"message"`)) && bytes.Contains(data, []byte(`"from":"other"`)):
+			message = data
+		}
+	}
+	if got := normalizeGolden(users); got != goldenV1Users {
+		t.Errorf("v1 users:\n got %s\nwant %s", got, goldenV1Users)
+	}
+	if got := normalizeGolden(message); got != goldenV1Message {
+		t.Errorf("v1 message:\n got %s\nwant %s", got, goldenV1Message)
+	}
+}
+
+// usersPayload 解析 users 事件，同时保留每一项的原始字段以检查形状
+type usersPayload struct {
+	Type string `json:"type"`
+	Data struct {
+		Room  string                       `json:"room"`
+		Users []map[string]json.RawMessage `json:"users"`
+		Count int                          `json:"count"`
+	} `json:"data"`
+}
+
+func (p usersPayload) ids() []string {
+	var ids []string
+	for _, u := range p.Data.Users {
+		var id string
+		json.Unmarshal(u["id"], &id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// expectUsers 读到满足 match 的 users 事件为止
+func expectUsers(tc *testConn, match func(usersPayload) bool) usersPayload {
+	tc.t.Helper()
+	var p usersPayload
+	tc.expectWhere("users", func(m map[string]interface{}) bool {
+		p = usersPayload{}
+		json.Unmarshal(mustMarshal(m), &p)
+		return match(p)
+	})
+	return p
+}
+
+func usersAre(ids ...string) func(usersPayload) bool {
+	return func(p usersPayload) bool { return slices.Equal(p.ids(), ids) }
+}
+
+// 上线与离线后的 users 事件都是按 id 排序的对象数组，昵称中的逗号原样保留
+func TestUsersPayloadShape(t *testing.T) {
+	userNicks.Delete("amy")
+	t.Cleanup(func() { userNicks.Delete("amy") })
+	srv := newTestServer(t, nil)
+	zed := dialWS(t, srv, "uid=zed")
+	expectUsers(zed, usersAre("zed"))
+	amy := dialWS(t, srv, "uid=amy")
+
+	p := expectUsers(zed, usersAre("amy", "zed"))
+	if p.Data.Room != "lobby" || p.Data.Count != 2 {
+		t.Fatalf("join: %+v", p.Data)
+	}
+	for _, u := range p.Data.Users {
+		for _, key := range []string{"id", "device", "color", "status", "connectedAt"} {
+			if _, ok := u[key]; !ok {
+				t.Fatalf("user entry %v has no %q", u, key)
+			}
+		}
+	}
+
+	amy.sendJSON(map[string]interface{}{"type": "nick", "data": map[string]string{"name": "Amy, the first"}})
+	expectUsers(zed, func(p usersPayload) bool {
+		return len(p.Data.Users) == 2 && string(p.Data.Users[0]["nick"]) == `"Amy, the first"`
+	})
+
+	amy.conn.Close()
+	p = expectUsers(zed, usersAre("zed"))
+	if p.Data.Count != 1 {
+		t.Fatalf("leave: %+v", p.Data)
+	}
+}
+
+// 协议版本协商：?proto 优先，其次子协议，都没有时按 -default-proto；结果出现在 /info 的按版本计数
+// 与 /api/connections 的每连接统计中
+func TestNegotiateProtocol(t *testing.T) {
+	tests := []struct {
+		query        string
+		subprotocols []string
+		defaultProto int
+		want         int
+	}{
+		{query: "proto=1", want: 1},
+		{query: "proto=2", want: 2},
+		{query: "proto=9", want: 2},
+		{query: "proto=0", want: 2},
+		{subprotocols: []string{"gochat.v1"}, want: 1},
+		{subprotocols: []string{"gochat.v2", "gochat.v1"}, want: 2},
+		{query: "proto=1", subprotocols: []string{"gochat.v2"}, want: 1},
+		{subprotocols: []string{"chat"}, want: 2},
+		{want: 2},
+		{defaultProto: 1, want: 1},
+		{defaultProto: 1, subprotocols: []string{"gochat.v2"}, want: 2},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d_%s_%v", i, tt.query, tt.subprotocols), func(t *testing.T) {
+			if tt.defaultProto != 0 {
+				setFlag(t, defaultProto, tt.defaultProto)
+			}
+			srv := newTestServer(t, map[string]http.HandlerFunc{"/info": infoHandler, "/api/connections": connectionsHandler})
+			uid := fmt.Sprintf("proto%d", i)
+			dialer := websocket.Dialer{Subprotocols: tt.subprotocols}
+			conn, _, err := dialer.Dial(wsURL(srv, "uid="+uid+"&"+tt.query), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			newTestConn(t, conn)
+
+			var info ServiceInfo
+			getJSON(t, srv.URL+"/info", &info)
+			if want := map[string]int{fmt.Sprintf("v%d", tt.want): 1}; fmt.Sprint(info.Protocols) != fmt.Sprint(want) {
+				t.Errorf("/info protocols %v, want %v", info.Protocols, want)
+			}
+			var conns []ConnInfo
+			getJSON(t, srv.URL+"/api/connections", &conns)
+			if len(conns) != 1 || conns[0].UserID != uid || conns[0].Proto != tt.want {
+				t.Errorf("/api/connections %+v, want %s on v%d", conns, uid, tt.want)
+			}
+		})
+	}
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+}
+
+// 连续上线合并为少数几次 users 广播；安静一段时间后的第一次变化不等窗口，立即广播
+func TestUsersBroadcastDebounce(t *testing.T) {
+	srv := newTestServer(t, nil)
+	watcher := dialWS(t, srv, "uid=debounce-watcher")
+	expectUsers(watcher, usersAre("debounce-watcher"))
+
+	const n = 20
+	frames := 0
+	for i := 0; i < n; i++ {
+		dialWS(t, srv, fmt.Sprintf("uid=debounce-%02d", i))
+	}
+	expectUsers(watcher, func(p usersPayload) bool {
+		frames++
+		return p.Data.Count == n+1
+	})
+	// 等广播协程空闲超过一个窗口；其间可能还有一次合并后的广播，在下面读 late 时一并计数
+	time.Sleep(2 * *usersDebounce)
+	start := time.Now()
+	dialWS(t, srv, "uid=debounce-late")
+	expectUsers(watcher, func(p usersPayload) bool {
+		if slices.Contains(p.ids(), "debounce-late") {
+			return true
+		}
+		frames++
+		return false
+	})
+	if d := time.Since(start); d > *usersDebounce/2 {
+		t.Errorf("first broadcast after a quiet period took %v, debounce window %v", d, *usersDebounce)
+	}
+	if frames > 4 {
+		t.Errorf("%d joins produced %d users broadcasts", n, frames)
+	}
+}
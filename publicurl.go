@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 对外访问地址：-public-url 指定时原样使用，否则按请求推断；
+// 只有来自受信任代理的请求才采信 X-Forwarded-* 头
+
+var (
+	publicURL      = flag.String("public-url", "", "对外访问地址，如 https://chat.example.com/chat；用于启动横幅、/info、分享链接与上传返回的地址")
+	trustedProxies = flag.String("trusted-proxies", "", "受信任的反向代理地址，逗号分隔的 IP 或 CIDR，如 127.0.0.1,10.0.0.0/8")
+)
+
+var trustedNets []*net.IPNet
+
+// initPublicURL 校验并解析相关参数
+func initPublicURL() error {
+	if *publicURL != "" {
+		u, err := url.Parse(*publicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -public-url %q", *publicURL)
+		}
+		*publicURL = strings.TrimRight(*publicURL, "/")
+	}
+	for _, s := range strings.Split(*trustedProxies, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("invalid -trusted-proxies entry %q", s)
+		}
+		trustedNets = append(trustedNets, n)
+	}
+	return nil
+}
+
+// fromTrustedProxy 请求的直接来源是否为受信任代理
+func fromTrustedProxy(r *http.Request) bool {
+	return trustedIP(remoteHost(r))
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func trustedIP(s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 客户端地址：直接来源是受信任代理时，取 X-Forwarded-For 中从右往左第一个不受信任的地址
+// （更靠左的值可由客户端任意伪造）
+func clientIP(r *http.Request) string {
+	ip := remoteHost(r)
+	if !trustedIP(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !trustedIP(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// firstHeader 代理链上的头取第一个（最靠近客户端的）值
+func firstHeader(r *http.Request, name string) string {
+	v, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(v)
+}
+
+// baseURL 对外访问地址（不含末尾 /），用于生成绝对链接
+func baseURL(r *http.Request) string {
+	if *publicURL != "" {
+		return *publicURL
+	}
+	scheme, host, prefix := "http", r.Host, ""
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if fromTrustedProxy(r) {
+		if p := firstHeader(r, "X-Forwarded-Proto"); p == "http" || p == "https" {
+			scheme = p
+		}
+		if h := firstHeader(r, "X-Forwarded-Host"); h != "" {
+			host = h
+		}
+		prefix = strings.TrimRight(firstHeader(r, "X-Forwarded-Prefix"), "/")
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			prefix = "/" + prefix
+		}
+	}
+	return scheme + "://" + host + prefix
+}
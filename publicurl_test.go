@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+// 直接访问、经反向代理（X-Forwarded-*）与带路径前缀部署时生成的绝对地址
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		publicURL string
+		trusted   string
+		remote    string
+		host      string
+		tls       bool
+		headers   map[string]string
+		want      string
+	}{
+		{name: "bare", remote: "192.0.2.7:5000", host: "10.0.0.5:3027", want: "http://10.0.0.5:3027"},
+		{name: "bare tls", remote: "192.0.2.7:5000", host: "chat.example.com", tls: true, want: "https://chat.example.com"},
+		{
+			name: "untrusted forwarded headers ignored", remote: "192.0.2.7:5000", host: "10.0.0.5:3027",
+			headers: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example", "X-Forwarded-Prefix": "/x"},
+			want:    "http://10.0.0.5:3027",
+		},
+		{
+			name: "trusted proxy", trusted: "127.0.0.1", remote: "127.0.0.1:5000", host: "127.0.0.1:3027",
+			headers: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "chat.example.com"},
+			want:    "https://chat.example.com",
+		},
+		{
+			name: "proxy chain takes the first value", trusted: "10.0.0.0/8", remote: "10.1.2.3:5000", host: "backend:3027",
+			headers: map[string]string{"X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "chat.example.com, lb.internal"},
+			want:    "https://chat.example.com",
+		},
+		{
+			name: "bogus proto ignored", trusted: "127.0.0.1", remote: "127.0.0.1:5000", host: "127.0.0.1:3027",
+			headers: map[string]string{"X-Forwarded-Proto": "gopher"},
+			want:    "http://127.0.0.1:3027",
+		},
+		{
+			name: "base path from proxy", trusted: "127.0.0.1", remote: "127.0.0.1:5000", host: "127.0.0.1:3027",
+			headers: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com", "X-Forwarded-Prefix": "chat/"},
+			want:    "https://example.com/chat",
+		},
+		{
+			name: "public url with base path", publicURL: "https://example.com/chat/", remote: "127.0.0.1:5000", host: "127.0.0.1:3027",
+			headers: map[string]string{"X-Forwarded-Host": "ignored.example"},
+			want:    "https://example.com/chat",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, publicURL, tt.publicURL)
+			setFlag(t, trustedProxies, tt.trusted)
+			setFlag(t, &trustedNets, nil)
+			if err := initPublicURL(); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("GET", "/upload", nil)
+			r.RemoteAddr, r.Host = tt.remote, tt.host
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := baseURL(r); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitPublicURLRejectsInvalid(t *testing.T) {
+	for _, u := range []string{"chat.example.com", "ftp://example.com", "https://"} {
+		setFlag(t, publicURL, u)
+		if err := initPublicURL(); err == nil {
+			t.Errorf("-public-url %q accepted", u)
+		}
+	}
+}
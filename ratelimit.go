@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// 连接级入站限速：每个连接发来的文本消息（信令、聊天、控制消息，以及无法解析的帧）共用一个令牌桶，
+// 在各类型自己的档位（见 wstypes.go）之前检查；心跳与中继的二进制数据帧不计入。
+// 超出时丢弃该帧，每秒至多回一帧 rate_limited；持续超限 -ws-rate-grace 后以关闭码 4012 断开
+
+var (
+	wsRate      = flag.Float64("ws-rate", 20, "每个连接每秒可发来的消息数（令牌桶速率），0 表示不限")
+	wsBurst     = flag.Int("ws-burst", 40, "每个连接可瞬时发来的消息数（令牌桶容量）")
+	wsRateGrace = flag.Duration("ws-rate-grace", 5*time.Second, "持续超出 -ws-rate 多久后断开连接；期间有一秒以上未超限即重新计时")
+)
+
+var (
+	rateLimitedConns     atomic.Int64 // 至少被限速过一次的连接数，供 /info
+	rateLimitDisconnects atomic.Int64 // 因持续超限断开的连接数
+)
+
+// inboundLimit 连接级限速状态，只由读循环访问
+type inboundLimit struct {
+	bucket       tokenBucket
+	limitedSince time.Time // 这一轮持续超限的开始时间
+	lastRejected time.Time
+	lastNotified time.Time
+	counted      bool
+}
+
+// allowInbound 在读循环中对每条文本消息调用；返回 false 时丢弃该帧，disconnect 为 true 时应断开连接
+func (c *client) allowInbound(now time.Time) (ok, disconnect bool) {
+	l := &c.inbound
+	if *wsRate <= 0 || l.bucket.take(float64(*wsBurst), *wsRate, now) {
+		return true, false
+	}
+	if !l.counted {
+		l.counted = true
+		rateLimitedConns.Add(1)
+	}
+	if l.limitedSince.IsZero() || now.Sub(l.lastRejected) > time.Second {
+		l.limitedSince = now
+	}
+	l.lastRejected = now
+	if now.Sub(l.limitedSince) >= *wsRateGrace {
+		rateLimitDisconnects.Add(1)
+		log.Printf("🚦 用户 %s 持续超过每秒 %g 条消息的限制，断开连接", c.userID, *wsRate)
+		return false, true
+	}
+	if now.Sub(l.lastNotified) >= time.Second {
+		l.lastNotified = now
+		sendWSError(c, "", "rate_limited", "too many messages, slow down", map[string]interface{}{"rateClass": "connection", "rate": *wsRate, "burst": *wsBurst})
+	}
+	return false, false
+}
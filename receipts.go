@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 送达回执：群聊或私聊消息带 "receipt":true 时，收到的客户端回 {"type":"delivered","id":"<消息 ID>"}，
+// 服务端汇总后把 {"type":"receipt","id":...,"deliveredTo":["A","B"]} 发给发送者（每多一人送达发一次），
+// /send 的调用方可查询 GET /api/messages/{id}/receipts。只接受该消息的接收方（私聊的对方、群聊所在房间的成员）
+// 回执，发送者自己的回执忽略。汇总记录在 -receipt-ttl 后失效，总条数受登记表上限约束
+
+var receiptTTL = flag.Duration("receipt-ttl", 5*time.Minute, "送达回执的保留时长，过期后不再接受回执、也查询不到")
+
+// receiptState 一条要求回执的消息，可变部分由 mu 保护
+type receiptState struct {
+	From string
+	To   string // 私聊的接收方，群聊为空
+	Room string
+
+	mu          sync.Mutex
+	deliveredTo []string // 按送达先后
+}
+
+var receipts = newExpiringMap[string, *receiptState]("receipts", 0, registryMaxEntries) // 消息 ID -> 回执
+
+func init() {
+	registerWSType(wsType{name: "delivered", rate: rateRelay, minProto: 2, feature: "receipts", handle: func(f *wsFrame) { handleDelivered(f.c, f.id) }})
+}
+
+// trackReceipt 在广播前登记，保证接收方的回执不会早于登记到达
+func trackReceipt(m Message) {
+	receipts.SetTTL(m.ID, &receiptState{From: m.From, To: m.To, Room: m.Room}, *receiptTTL)
+}
+
+// delivered 记录 userID 已收到，返回当前的送达列表；重复回执时 added 为 false
+func (s *receiptState) delivered(userID string) (list []string, added bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range s.deliveredTo {
+		if id == userID {
+			return nil, false
+		}
+	}
+	s.deliveredTo = append(s.deliveredTo, userID)
+	return append([]string(nil), s.deliveredTo...), true
+}
+
+func (s *receiptState) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.deliveredTo...)
+}
+
+// handleDelivered 处理接收方的回执并通知发送者
+func handleDelivered(c *client, id string) {
+	s, ok := receipts.Get(id)
+	switch {
+	case ok && s.From == c.userID:
+		// 客户端可能对所有带 receipt 的消息统一回执，包括自己发出的
+		return
+	case !ok || (s.To != "" && s.To != c.userID) || (s.To == "" && s.Room != "" && !c.inRoom(s.Room)):
+		sendWSError(c, "delivered", "receipt_not_found", "message not found, not addressed to you, or receipt expired", map[string]interface{}{"id": id})
+		return
+	}
+	list, added := s.delivered(c.userID)
+	if !added {
+		return
+	}
+	// 发送者不在线（如 /send 的调用方）时只能查询
+	forwardSignal(s.From, map[string]interface{}{"type": "receipt", "id": id, "deliveredTo": list})
+}
+
+// messageReceiptsHandler GET /api/messages/{id}/receipts
+func messageReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/messages/"), "/receipts")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	s, ok := receipts.Get(id)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "receipt_not_found", "Message not found, sent without receipt, or receipt expired", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "from": s.From, "deliveredTo": s.list()})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// 三个接收方中一个已离线：发送者依次收到在线两人的回执，查询接口只列出这两人
+func TestReceiptsThreeClients(t *testing.T) {
+	srv := newTestServer(t, map[string]http.HandlerFunc{"/send": sendHandler, "/api/messages/": messageReceiptsHandler})
+	sender := dialWS(t, srv, "uid=sender")
+	alice := dialWS(t, srv, "uid=alice")
+	bob := dialWS(t, srv, "uid=bob")
+	carol := dialWS(t, srv, "uid=carol")
+	carol.conn.Close()
+	waitFor(t, "carol to go offline", func() bool { return !clients.Online("carol") })
+
+	sender.sendJSON(map[string]interface{}{"type": "message", "data": map[string]interface{}{"text": "disk full", "receipt": true}})
+	id, _ := sender.expect("ack")["id"].(string)
+	if id == "" {
+		t.Fatal("ack without message id")
+	}
+	for _, tc := range []*testConn{alice, bob} {
+		m := tc.expectWhere("message", func(m map[string]interface{}) bool { return chatText(m) == "disk full" })
+		if data := m["data"].(map[string]interface{}); data["receipt"] != true || data["id"] != id {
+			t.Fatalf("message data = %v", data)
+		}
+	}
+
+	deliveredTo := func() []string {
+		m := sender.expect("receipt")
+		if m["id"] != id {
+			t.Fatalf("receipt for %v, want %s", m["id"], id)
+		}
+		var list []string
+		for _, u := range m["deliveredTo"].([]interface{}) {
+			list = append(list, u.(string))
+		}
+		return list
+	}
+	alice.sendJSON(map[string]string{"type": "delivered", "id": id})
+	if got := deliveredTo(); !slices.Equal(got, []string{"alice"}) {
+		t.Fatalf("deliveredTo = %v, want [alice]", got)
+	}
+	alice.sendJSON(map[string]string{"type": "delivered", "id": id}) // 重复回执不再通知
+	bob.sendJSON(map[string]string{"type": "delivered", "id": id})
+	if got := deliveredTo(); !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Fatalf("deliveredTo = %v, want [alice bob]", got)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/messages/" + id + "/receipts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		From        string   `json:"from"`
+		DeliveredTo []string `json:"deliveredTo"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if body.From != "sender" || !slices.Equal(body.DeliveredTo, []string{"alice", "bob"}) {
+		t.Fatalf("receipts = %+v, want from sender to [alice bob]", body)
+	}
+}
+
+// /send 的调用方没有连接，只能查询；未知 ID 的回执返回 receipt_not_found
+func TestReceiptsViaSend(t *testing.T) {
+	srv := newTestServer(t, map[string]http.HandlerFunc{"/send": sendHandler, "/api/messages/": messageReceiptsHandler})
+	alice := dialWS(t, srv, "uid=alice")
+
+	resp, err := http.Post(srv.URL+"/send", "application/json", strings.NewReader(`{"message":"deploy done","from":"ci","receipt":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent struct{ ID string }
+	json.NewDecoder(resp.Body).Decode(&sent)
+	resp.Body.Close()
+
+	alice.expectWhere("message", func(m map[string]interface{}) bool { return chatText(m) == "deploy done" })
+	alice.sendJSON(map[string]string{"type": "delivered", "id": sent.ID})
+	alice.sendJSON(map[string]string{"type": "delivered", "id": "unknown"})
+	alice.expectWSError("receipt_not_found")
+
+	resp, err = http.Get(srv.URL + "/api/messages/" + sent.ID + "/receipts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct{ DeliveredTo []string }
+	json.NewDecoder(resp.Body).Decode(&body)
+	if !slices.Equal(body.DeliveredTo, []string{"alice"}) {
+		t.Fatalf("deliveredTo = %v, want [alice]", body.DeliveredTo)
+	}
+}
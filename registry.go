@@ -0,0 +1,129 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 在线连接表：按连接与按 userId 的两张映射只能经 Registry 一起修改，每个方法自行加锁。
+// 一个 userId 可以同时有多个连接（如同一浏览器的多个标签页，见 tabs.go），按 userId 的映射保存其全部连接，
+// 最后一个连接移除时才删除该 userId。只有 hub 协程登记与移除连接。
+// 查重名、分配 userId、改昵称、断线保留期等需要“检查后修改”的身份操作另由 identityMu 串行，
+// 持有 identityMu 时可以调用 Registry 的方法，反之不行
+
+// Registry 在线连接及其 userId 索引
+type Registry struct {
+	mu     sync.RWMutex
+	byConn map[*websocket.Conn]*client
+	byID   map[string][]*client // 按登记先后
+}
+
+func newRegistry() *Registry {
+	return &Registry{byConn: make(map[*websocket.Conn]*client), byID: make(map[string][]*client)}
+}
+
+var clients = newRegistry()
+
+// identityMu 串行化身份相关的检查与修改：登记与注销连接、断线保留期（lingering）、昵称与颜色分配，
+// 以及 client.superseded、client.departedCount
+var identityMu sync.Mutex
+
+// UserInfo 在线用户的视图，同一 userId 的多个连接合为一项
+type UserInfo struct {
+	ID          string    `json:"id"`
+	Conns       int       `json:"conns"`
+	Device      string    `json:"device"`      // 最近登记的连接
+	ConnectedAt time.Time `json:"connectedAt"` // 最早登记的连接
+}
+
+// Add 以 id 登记连接（写入 c.userID），加入该 userId 的连接集合；已登记的连接不变
+func (r *Registry) Add(c *client, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byConn[c.conn] != nil {
+		return
+	}
+	c.userID = id
+	r.byConn[c.conn] = c
+	r.byID[id] = append(r.byID[id], c)
+}
+
+// Remove 移除连接，返回其 userId；连接未登记时 ok 为 false
+func (r *Registry) Remove(conn *websocket.Conn) (id string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.byConn[conn]
+	if c == nil {
+		return "", false
+	}
+	delete(r.byConn, conn)
+	conns := slices.DeleteFunc(r.byID[c.userID], func(o *client) bool { return o == c })
+	if len(conns) == 0 {
+		delete(r.byID, c.userID)
+	} else {
+		r.byID[c.userID] = conns
+	}
+	return c.userID, true
+}
+
+// ByID 该 userId 当前的全部连接（副本），不在线时为空
+func (r *Registry) ByID(id string) []*client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.byID[id])
+}
+
+// Online 该 userId 是否至少有一个连接
+func (r *Registry) Online(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byID[id]) > 0
+}
+
+// ByConn 已登记的连接，未登记或已移除时为 nil
+func (r *Registry) ByConn(conn *websocket.Conn) *client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byConn[conn]
+}
+
+// Snapshot 当前在线用户，按 id 排序
+func (r *Registry) Snapshot() []UserInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]UserInfo, 0, len(r.byID))
+	for id, conns := range r.byID {
+		list = append(list, UserInfo{ID: id, Conns: len(conns), Device: conns[len(conns)-1].device, ConnectedAt: conns[0].connectedAt})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Conns 当前所有连接的副本，顺序不定
+func (r *Registry) Conns() []*client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*client, 0, len(r.byConn))
+	for _, c := range r.byConn {
+		list = append(list, c)
+	}
+	return list
+}
+
+// Count 在线连接数
+func (r *Registry) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byConn)
+}
+
+// Users 在线用户数，同一 userId 的多个连接只算一次
+func (r *Registry) Users() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byID)
+}
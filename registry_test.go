@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func testClient() *client {
+	return &client{conn: &websocket.Conn{}}
+}
+
+func TestRegistryAddRemove(t *testing.T) {
+	r := newRegistry()
+	a, b := testClient(), testClient()
+	r.Add(a, "alice")
+	r.Add(b, "bob")
+	r.Add(a, "carol") // 重复登记不改变状态
+	if r.Count() != 2 || r.Users() != 2 || a.userID != "alice" {
+		t.Fatalf("Count = %d, Users = %d, a = %q, want 2, 2, alice", r.Count(), r.Users(), a.userID)
+	}
+
+	id, ok := r.Remove(a.conn)
+	if !ok || id != "alice" {
+		t.Fatalf("Remove = %q, %v, want alice, true", id, ok)
+	}
+	if _, ok := r.Remove(a.conn); ok {
+		t.Fatal("second Remove of the same conn reported ok")
+	}
+	if r.Online("alice") || r.ByConn(a.conn) != nil || len(r.ByID("alice")) != 0 {
+		t.Fatal("alice still present after Remove")
+	}
+	if r.Count() != 1 || r.Users() != 1 {
+		t.Fatalf("Count = %d, Users = %d, want 1, 1", r.Count(), r.Users())
+	}
+}
+
+func TestRegistryLookup(t *testing.T) {
+	r := newRegistry()
+	tab1, tab2, other := testClient(), testClient(), testClient()
+	tab1.device, tab1.connectedAt = "desktop", time.Unix(100, 0)
+	tab2.device, tab2.connectedAt = "mobile", time.Unix(200, 0)
+	r.Add(tab1, "alice")
+	r.Add(tab2, "alice")
+	r.Add(other, "bob")
+
+	if r.ByConn(tab2.conn) != tab2 {
+		t.Fatal("ByConn returned the wrong client")
+	}
+	conns := r.ByID("alice")
+	if len(conns) != 2 || conns[0] != tab1 || conns[1] != tab2 {
+		t.Fatalf("ByID(alice) = %v, want both tabs in order", conns)
+	}
+	conns[0] = nil // ByID 返回副本
+	if r.ByID("alice")[0] != tab1 {
+		t.Fatal("modifying the ByID result changed the registry")
+	}
+	if r.Count() != 3 || r.Users() != 2 || len(r.Conns()) != 3 {
+		t.Fatalf("Count = %d, Users = %d, Conns = %d", r.Count(), r.Users(), len(r.Conns()))
+	}
+	// 用户视图按 id 排序，多个连接合为一项
+	users := r.Snapshot()
+	want := UserInfo{ID: "alice", Conns: 2, Device: "mobile", ConnectedAt: time.Unix(100, 0)}
+	if len(users) != 2 || users[0] != want || users[1].ID != "bob" || users[1].Conns != 1 {
+		t.Fatalf("Snapshot = %+v", users)
+	}
+
+	r.Remove(tab1.conn)
+	if !r.Online("alice") || len(r.ByID("alice")) != 1 {
+		t.Fatal("closing one tab took alice offline")
+	}
+	r.Remove(tab2.conn)
+	if r.Online("alice") {
+		t.Fatal("alice online after the last connection was removed")
+	}
+}
+
+// 在 -race 下运行：并发登记、查询与移除
+func TestRegistryConcurrent(t *testing.T) {
+	r := newRegistry()
+	const workers, perWorker = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("user%d", w)
+			for i := 0; i < perWorker; i++ {
+				c := testClient()
+				r.Add(c, id)
+				r.ByID(id)
+				r.Online(id)
+				r.Snapshot()
+				r.Conns()
+				r.Count()
+				if i%2 == 0 {
+					if got, ok := r.Remove(c.conn); !ok || got != id {
+						t.Errorf("Remove = %q, %v", got, ok)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if r.Count() != workers*perWorker/2 || r.Users() != workers {
+		t.Fatalf("Count = %d, Users = %d, want %d, %d", r.Count(), r.Users(), workers*perWorker/2, workers)
+	}
+}
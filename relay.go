@@ -0,0 +1,246 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 服务端中继传输：双方都在对称 NAT 后、WebRTC 连不通时，接受 file_offer 得到的 file_session
+// 可改由服务端转发。发送方发 {"type":"relay_start","data":{"sessionId":"..."}}，随后以二进制帧
+// 发送文件内容（每帧不超过 -ws-read-limit），服务端原样排入接收方的发送队列，
+// 收满 size 字节后向双方发 relay_done。每个连接同一时间只能发送、接收各一个中继。
+// 流量控制：每个中继最多 relayWindow 帧在接收方队列中尚未写出，窗口满时发送方的读循环等待
+// （TCP 背压传回发送方），超过 -write-timeout 仍无进展则中止。任一方断开或发 relay_cancel 时
+// 双方收到 relay_aborted；未使用的会话在 -file-offer-timeout 后失效
+
+const relayWindow = 32 // 每个中继在接收方队列中未写出的帧数上限
+
+type relaySession struct {
+	ID   string
+	From string
+	To   string
+	Name string
+	Size int64
+
+	timer *time.Timer // 未开始时的失效计时
+
+	// 以下在 relay_start 后设置
+	src, dst *client
+	received int64         // 只由发送方的读循环访问
+	window   chan struct{} // 未写出的帧各占一格
+	closed   chan struct{} // 完成或中止时关闭
+	once     sync.Once
+}
+
+var (
+	relays   = make(map[string]*relaySession) // sessionId -> 会话
+	relaysMu sync.Mutex
+)
+
+type relayRequest struct {
+	SessionID string `json:"sessionId"`
+}
+
+func init() {
+	registerWSType(wsType{
+		name:     "relay_start",
+		payload:  func() interface{} { return new(relayRequest) },
+		rate:     rateControl,
+		minProto: 2,
+		required: []string{"sessionId"},
+		feature:  "relay",
+		handle:   func(f *wsFrame) { startRelay(f.c, f.payload.(*relayRequest).SessionID) },
+	})
+	registerWSType(wsType{
+		name:     "relay_cancel",
+		payload:  func() interface{} { return new(relayRequest) },
+		rate:     rateControl,
+		minProto: 2,
+		required: []string{"sessionId"},
+		handle:   func(f *wsFrame) { cancelRelay(f.c, f.payload.(*relayRequest).SessionID) },
+	})
+}
+
+// offerRelay 接受邀请时登记可供中继的会话
+func offerRelay(id string, o *fileOffer) {
+	s := &relaySession{ID: id, From: o.From, To: o.To, Name: o.Name, Size: o.Size}
+	relaysMu.Lock()
+	s.timer = time.AfterFunc(*fileOfferTimeout, func() {
+		relaysMu.Lock()
+		if relays[id] == s && s.src == nil {
+			delete(relays, id)
+		}
+		relaysMu.Unlock()
+	})
+	relays[id] = s
+	relaysMu.Unlock()
+}
+
+func relayEvent(s *relaySession, extra map[string]interface{}) map[string]interface{} {
+	data := map[string]interface{}{"sessionId": s.ID, "from": s.From, "to": s.To, "name": s.Name, "size": s.Size}
+	for k, v := range extra {
+		data[k] = v
+	}
+	return data
+}
+
+// startRelay 由发送方的读循环调用，绑定双方当前的连接；接收方有多个连接时选最近活跃的那个，
+// 通常就是刚点了接受的页面
+func startRelay(c *client, id string) {
+	fail := func(code, reason string) {
+		sendWSError(c, "relay_start", code, reason, map[string]interface{}{"sessionId": id})
+	}
+	relaysMu.Lock()
+	s := relays[id]
+	switch {
+	case s == nil || s.From != c.userID:
+		relaysMu.Unlock()
+		fail("relay_not_found", "relay session not found or expired")
+		return
+	case s.src != nil:
+		relaysMu.Unlock()
+		fail("relay_started", "relay already started")
+		return
+	case s.Size > maxSize.Load():
+		// -max-size 可能在邀请之后调低
+		delete(relays, id)
+		relaysMu.Unlock()
+		fail("relay_too_large", "file too large")
+		return
+	}
+	dst := latestActive(clients.ByID(s.To))
+	busy := false
+	for _, r := range relays {
+		if r.src == c || (dst != nil && r.dst == dst) {
+			busy = true
+		}
+	}
+	switch {
+	case dst == nil:
+		delete(relays, id)
+		relaysMu.Unlock()
+		fail("relay_peer_offline", "target user not online")
+		return
+	case busy:
+		relaysMu.Unlock()
+		fail("relay_busy", "sender or receiver already has a relay in progress")
+		return
+	}
+	s.timer.Stop()
+	s.src, s.dst = c, dst
+	s.window = make(chan struct{}, relayWindow)
+	s.closed = make(chan struct{})
+	relaysMu.Unlock()
+
+	c.relayOut = s
+	// 先于第一帧数据排入接收方队列，接收方据此知道之后的二进制帧属于哪个文件
+	ev := mustMarshal(map[string]interface{}{"type": "relay_start", "data": relayEvent(s, nil)})
+	dst.send(ev)
+	c.send(ev)
+	log.Printf("📦 开始中继 %s -> %s: %s (%s)", s.From, s.To, s.Name, humanSize(s.Size))
+}
+
+// relayChunk 读循环收到的二进制帧，转发给当前中继的接收方；窗口满时在此等待
+func (c *client) relayChunk(data []byte) {
+	s := c.relayOut
+	if s == nil {
+		sendWSError(c, "relay", "relay_not_started", "no relay in progress", nil)
+		return
+	}
+	select {
+	case <-s.closed:
+		// 已结束：中止前发送方已发出的帧可能仍在路上，静默丢弃
+		return
+	default:
+	}
+	if s.received+int64(len(data)) > s.Size {
+		s.abort("file is larger than announced")
+		return
+	}
+	select {
+	case s.window <- struct{}{}:
+	case <-s.closed:
+		return
+	case <-time.After(*writeTimeout):
+		s.abort("receiver is too slow")
+		return
+	}
+	if err := s.dst.enqueue(frame{typ: websocket.BinaryMessage, data: data, written: func() { <-s.window }}); err != nil {
+		<-s.window
+		s.abort("receiver disconnected")
+		return
+	}
+	s.received += int64(len(data))
+	if s.received == s.Size {
+		s.finish()
+	}
+}
+
+// finish 收满后通知双方，relay_done 排在最后一帧数据之后
+func (s *relaySession) finish() {
+	s.once.Do(func() {
+		s.remove()
+		close(s.closed)
+		ev := mustMarshal(map[string]interface{}{"type": "relay_done", "data": relayEvent(s, nil)})
+		s.dst.send(ev)
+		s.src.send(ev)
+		log.Printf("📦 中继完成 %s -> %s: %s", s.From, s.To, s.Name)
+	})
+}
+
+// abort 中止传输并通知双方，可重复调用、可由任意协程调用
+func (s *relaySession) abort(reason string) {
+	s.once.Do(func() {
+		s.remove()
+		close(s.closed)
+		ev := mustMarshal(map[string]interface{}{"type": "relay_aborted", "data": relayEvent(s, map[string]interface{}{"reason": reason})})
+		s.dst.send(ev)
+		s.src.send(ev)
+		log.Printf("📦 中继中止 %s -> %s: %s（%s）", s.From, s.To, s.Name, reason)
+	})
+}
+
+func (s *relaySession) remove() {
+	relaysMu.Lock()
+	if relays[s.ID] == s {
+		delete(relays, s.ID)
+	}
+	relaysMu.Unlock()
+}
+
+// cancelRelay 任一方取消：已开始的中止，未开始的直接失效
+func cancelRelay(c *client, id string) {
+	relaysMu.Lock()
+	s := relays[id]
+	if s == nil || (s.From != c.userID && s.To != c.userID) {
+		relaysMu.Unlock()
+		sendWSError(c, "relay_cancel", "relay_not_found", "relay session not found or expired", map[string]interface{}{"sessionId": id})
+		return
+	}
+	if s.src == nil {
+		s.timer.Stop()
+		delete(relays, id)
+		relaysMu.Unlock()
+		return
+	}
+	relaysMu.Unlock()
+	s.abort("cancelled by " + c.userID)
+}
+
+// abortRelaysFor 连接结束时中止其参与的中继
+func abortRelaysFor(c *client) {
+	var active []*relaySession
+	relaysMu.Lock()
+	for _, s := range relays {
+		if s.src == c || s.dst == c {
+			active = append(active, s)
+		}
+	}
+	relaysMu.Unlock()
+	for _, s := range active {
+		s.abort("peer disconnected")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 服务端中继：三个客户端中 alice 经中继发给 bob，carol 不是任何一方；
+// 传完一个文件后再开一个中继并让 bob 断线，alice 应收到 relay_aborted
+func TestRelay(t *testing.T) {
+	srv := newTestServer(t, nil)
+	alice := dialWS(t, srv, "uid=relay-alice")
+	bob := dialWS(t, srv, "uid=relay-bob")
+	carol := dialWS(t, srv, "uid=relay-carol")
+
+	content := bytes.Repeat([]byte("0123456789"), 300)
+	session := openRelay(t, alice, bob, len(content))
+	for off := 0; off < len(content); off += 1000 {
+		if err := alice.conn.WriteMessage(websocket.BinaryMessage, content[off:off+1000]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []byte
+	for {
+		typ, m, data := nextFrame(t, bob)
+		if typ == websocket.BinaryMessage {
+			got = append(got, data...)
+			continue
+		}
+		if m["type"] == "relay_done" {
+			break
+		}
+		if strings.HasPrefix(m["type"].(string), "relay_") {
+			t.Fatalf("bob got %v before relay_done", m)
+		}
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("bob received %d bytes, want %d intact", len(got), len(content))
+	}
+	alice.expectWhere("relay_done", relayOf(session))
+
+	// 第二个中继传到一半时接收方断线
+	session = openRelay(t, alice, bob, len(content))
+	if err := alice.conn.WriteMessage(websocket.BinaryMessage, content[:1000]); err != nil {
+		t.Fatal(err)
+	}
+	bob.conn.Close()
+	aborted := alice.expectWhere("relay_aborted", relayOf(session))
+	if reason := aborted["data"].(map[string]interface{})["reason"]; reason != "peer disconnected" {
+		t.Errorf("abort reason %v, want peer disconnected", reason)
+	}
+	relaysMu.Lock()
+	left := len(relays)
+	relaysMu.Unlock()
+	if left != 0 {
+		t.Errorf("%d relay sessions left after abort", left)
+	}
+
+	// carol 既收不到邀请、会话事件，也收不到任何中继数据
+	carol.conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	for {
+		typ, data, err := carol.conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if typ == websocket.BinaryMessage {
+			t.Fatalf("carol received %d bytes of relay data", len(data))
+		}
+		var m struct{ Type string }
+		json.Unmarshal(data, &m)
+		if strings.HasPrefix(m.Type, "relay_") || strings.HasPrefix(m.Type, "file_") {
+			t.Fatalf("carol received %s", data)
+		}
+	}
+}
+
+// openRelay from 向 to 发出邀请、to 接受、from 开始中继，返回 sessionId；
+// to 读到 relay_start 后，之后的二进制帧即属于该中继
+func openRelay(t *testing.T, from, to *testConn, size int) string {
+	t.Helper()
+	from.sendJSON(map[string]interface{}{"type": "file_offer", "data": map[string]interface{}{"to": to.userID(), "name": "relay.bin", "size": size}})
+	offer := to.expect("file_offer")["data"].(map[string]interface{})
+	to.sendJSON(map[string]interface{}{"type": "file_offer_accept", "data": map[string]interface{}{"offerId": offer["offerId"]}})
+	session := from.expect("file_session")["data"].(map[string]interface{})["sessionId"].(string)
+	from.sendJSON(map[string]interface{}{"type": "relay_start", "data": map[string]interface{}{"sessionId": session}})
+	from.expectWhere("relay_start", relayOf(session))
+	to.expectWhere("relay_start", relayOf(session))
+	return session
+}
+
+func relayOf(session string) func(map[string]interface{}) bool {
+	return func(m map[string]interface{}) bool {
+		data, _ := m["data"].(map[string]interface{})
+		return data["sessionId"] == session
+	}
+}
+
+// nextFrame 读下一帧，二进制帧原样返回，文本帧解码为 m
+func nextFrame(t *testing.T, tc *testConn) (typ int, m map[string]interface{}, data []byte) {
+	t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	typ, data, err := tc.conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if typ == websocket.TextMessage {
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return typ, m, data
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+)
+
+// 断线补发：hub 保留最近 -replay-buffer 条已编号的广播，init 中下发当前最新 seq 与所在各房间的 roomSeq。
+// 客户端发现 seq 有空缺（或重连后 init.seq 大于自己收到的最后一条）时发送
+// {"type":"resync","from":<最后收到的 seq>}，服务端按顺序补发之后的广播，
+// 最后回一帧 resync 汇总；一次最多补发 maxReplayBatch 条，more 为 true 时从 to 继续请求。
+// 房间内的消息按 roomSeq 编号，补发时带上房间：{"type":"resync","room":"dev","from":<最后收到的 roomSeq>}
+
+var replayBufferSize = flag.Int("replay-buffer", 500, "为断线补发（resync）保留的最近广播条数，0 表示不保留")
+
+const (
+	maxReplayBuffer = 100000            // -replay-buffer 的上限
+	maxReplayBatch  = sendQueueSize / 2 // 单次补发上限，保证补发帧不会占满发送队列
+)
+
+var replayBuffer []WSMessage // 按 seq 递增，只由 hub 协程读写
+
+type resyncRequest struct {
+	c    *client
+	room string // 为空时补发不属于房间的广播
+	from uint64
+}
+
+var hubResync = make(chan resyncRequest)
+
+// rememberReplay 记录一条已编号的广播，在 hub 协程中调用
+func rememberReplay(msg WSMessage) {
+	if *replayBufferSize <= 0 {
+		return
+	}
+	// 删除后缓冲中同一消息的原文与此前的 edit 一并改为墓碑，补发时不再泄露
+	if msg.Type == "edit" && msg.Data.Deleted {
+		for i := range replayBuffer {
+			if m := &replayBuffer[i]; m.Data.ID == msg.Data.ID && (m.Type == "message" || m.Type == "edit") {
+				tombstone(&m.Data)
+			}
+		}
+	}
+	replayBuffer = append(replayBuffer, msg)
+	if over := len(replayBuffer) - *replayBufferSize; over > 0 {
+		replayBuffer = append([]WSMessage(nil), replayBuffer[over:]...)
+	}
+}
+
+func init() {
+	registerWSType(wsType{name: "resync", rate: rateControl, minProto: 2, feature: "resync", handle: func(f *wsFrame) { requestResync(f.c, f.room, f.from) }})
+}
+
+// requestResync 由读循环调用，交给 hub 按顺序补发
+func requestResync(c *client, room string, from uint64) {
+	if room != "" {
+		name, ok := normalizeRoom(room)
+		if !ok || !c.inRoom(name) {
+			sendWSError(c, "resync", "not_in_room", "not a member of this room", map[string]interface{}{"room": room})
+			return
+		}
+		room = name
+	}
+	hubResync <- resyncRequest{c: c, room: room, from: from}
+}
+
+// roomSeqsFor 连接所在各房间最新的 roomSeq，在 hub 协程中调用
+func roomSeqsFor(c *client) map[string]uint64 {
+	seqs := make(map[string]uint64)
+	for _, room := range c.roomList() {
+		seqs[room] = hubRoomSeq[room]
+	}
+	return seqs
+}
+
+// replaySeq 补发时比较的序号：房间内的消息为 roomSeq，其余为 seq；不属于 room 的返回 0
+func replaySeq(msg WSMessage, room string) uint64 {
+	if msg.Data.Room != room {
+		return 0
+	}
+	if room != "" {
+		return msg.RoomSeq
+	}
+	return msg.Seq
+}
+
+// hubReplay 在 hub 协程中执行：补发的帧与实时广播一样按接收方能力与显示格式编码，
+// 补发完成前 hub 不会投递新的广播，因此接收方看到的顺序与 seq 一致
+func hubReplay(r resyncRequest) {
+	c := r.c
+	if clients.ByConn(c.conn) != c {
+		return
+	}
+	latest := hubSeq
+	if r.room != "" {
+		latest = hubRoomSeq[r.room]
+	}
+	oldest := latest + 1
+	for _, msg := range replayBuffer {
+		if seq := replaySeq(msg, r.room); seq > 0 {
+			oldest = seq
+			break
+		}
+	}
+	to, count, more := r.from, 0, false
+	for _, msg := range replayBuffer {
+		seq := replaySeq(msg, r.room)
+		if seq <= r.from {
+			continue
+		}
+		if count == maxReplayBatch {
+			more = true
+			break
+		}
+		if data := broadcastEncoder(msg)(c); data != nil {
+			c.send(data)
+		}
+		to = seq
+		count++
+	}
+	if !more {
+		// 服务端重启后 seq 从头计数，to 可能小于 from，客户端据此重置
+		to = latest
+	}
+	data := map[string]interface{}{
+		"from":   r.from,
+		"to":     to,
+		"latest": latest,
+		"count":  count,
+		"more":   more,
+		// 缓冲中已没有 from 之后的部分消息，需要时改用 /api/activity 补齐
+		"truncated": r.from+1 < oldest,
+	}
+	if r.room != "" {
+		data["room"] = r.room
+	}
+	c.send(mustMarshal(map[string]interface{}{"type": "resync", "data": data}))
+}
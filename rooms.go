@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 房间：一个连接可同时加入多个房间，通过 {"type":"join","data":{"room":"dev"}} 与 leave 进出。
+// 群聊消息带 room 字段，hub 只投递给该房间的成员；消息的编辑、删除沿用原消息的房间。
+// 连接建立时位于默认房间 lobby，从不 join 的客户端行为与以前一致；不带 room 的广播
+// （系统提示、上下线、文件事件等）仍发给所有连接。用户列表按房间分别广播，
+// 信令与私聊按 userId 寻址，不受房间影响。房间成员关系属于连接，接管或在断线保留期内恢复时保留
+
+const (
+	defaultRoom     = "lobby"
+	maxRoomsPerConn = 16
+)
+
+var roomName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// roomsMu 保护各连接的 rooms
+var roomsMu sync.RWMutex
+
+type roomRequest struct {
+	Room string `json:"room"`
+}
+
+func init() {
+	registerWSType(wsType{
+		name:     "join",
+		payload:  func() interface{} { return new(roomRequest) },
+		rate:     rateControl,
+		minProto: 2,
+		required: []string{"room"},
+		feature:  "rooms",
+		handle:   func(f *wsFrame) { handleRoom(f.c, "join", f.payload.(*roomRequest).Room) },
+	})
+	registerWSType(wsType{
+		name:     "leave",
+		payload:  func() interface{} { return new(roomRequest) },
+		rate:     rateControl,
+		minProto: 2,
+		required: []string{"room"},
+		handle:   func(f *wsFrame) { handleRoom(f.c, "leave", f.payload.(*roomRequest).Room) },
+	})
+}
+
+// normalizeRoom 去掉首尾空白并转为小写，空字符串表示默认房间；与用户名一样不能使用保留名
+func normalizeRoom(room string) (string, bool) {
+	room = strings.ToLower(strings.TrimSpace(room))
+	if room == "" {
+		return defaultRoom, true
+	}
+	return room, roomName.MatchString(room) && !reservedName(room)
+}
+
+// inRoom 连接是否在该房间中
+func (c *client) inRoom(room string) bool {
+	roomsMu.RLock()
+	defer roomsMu.RUnlock()
+	return c.rooms[room]
+}
+
+// roomList 连接所在的房间，按名称排序
+func (c *client) roomList() []string {
+	roomsMu.RLock()
+	defer roomsMu.RUnlock()
+	list := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		list = append(list, room)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// setRooms 替换连接所在的房间：新连接为默认房间，接管或恢复时沿用旧连接的
+func (c *client) setRooms(rooms []string) {
+	set := make(map[string]bool, len(rooms))
+	for _, room := range rooms {
+		set[room] = true
+	}
+	roomsMu.Lock()
+	c.rooms = set
+	roomsMu.Unlock()
+}
+
+// handleRoom 进出房间，成功时回一帧同类型的确认并广播用户列表
+func handleRoom(c *client, typ, name string) {
+	room, ok := normalizeRoom(name)
+	if !ok {
+		sendWSError(c, typ, "invalid_room", "room name must be 1-32 lowercase letters, digits, '_' or '-'", map[string]interface{}{"room": name})
+		return
+	}
+	roomsMu.Lock()
+	switch {
+	case typ == "join" && !c.rooms[room] && len(c.rooms) >= maxRoomsPerConn:
+		roomsMu.Unlock()
+		sendWSError(c, typ, "too_many_rooms", "too many rooms joined", map[string]interface{}{"room": room, "max": maxRoomsPerConn})
+		return
+	case typ == "leave" && !c.rooms[room]:
+		roomsMu.Unlock()
+		sendWSError(c, typ, "not_in_room", "not a member of this room", map[string]interface{}{"room": room})
+		return
+	}
+	changed := c.rooms[room] != (typ == "join")
+	if typ == "join" {
+		c.rooms[room] = true
+	} else {
+		delete(c.rooms, room)
+	}
+	roomsMu.Unlock()
+
+	c.send(mustMarshal(map[string]interface{}{
+		"type": typ,
+		"data": map[string]interface{}{"room": room, "rooms": c.roomList()},
+	}))
+	if changed {
+		broadcastUsers()
+	}
+}
+
+// RoomInfo /api/rooms 中的一项
+type RoomInfo struct {
+	Room    string `json:"room"`
+	Members int    `json:"members"`
+}
+
+// roomCounts 各房间的在线成员数（同一用户的多个连接只算一次），默认房间总是列出，按名称排序
+func roomCounts() []RoomInfo {
+	members := map[string]map[string]bool{defaultRoom: {}}
+	conns := clients.Conns()
+	roomsMu.RLock()
+	for _, c := range conns {
+		for room := range c.rooms {
+			if members[room] == nil {
+				members[room] = make(map[string]bool)
+			}
+			members[room][c.userID] = true
+		}
+	}
+	roomsMu.RUnlock()
+	list := make([]RoomInfo, 0, len(members))
+	for room, users := range members {
+		list = append(list, RoomInfo{Room: room, Members: len(users)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Room < list[j].Room })
+	return list
+}
+
+// roomsHandler GET /api/rooms：有成员的房间及成员数
+func roomsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roomCounts())
+}
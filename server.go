@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// 服务的停止与交接：停止接收新请求、等待进行中的上传完成、通知客户端重连
+
+var drainTimeout = flag.Duration("drain-timeout", 30*time.Second, "升级或停止服务时等待进行中请求完成的最长时间")
+
+var httpServer *http.Server
+
+// reconnectPolicy 客户端断线重连的退避策略，随 init 下发：
+// 第 n 次重试等待 min(maxMs, baseMs*2^n)，再随机减去至多 jitter 比例
+type reconnectPolicy struct {
+	BaseMs int     `json:"baseMs"`
+	MaxMs  int     `json:"maxMs"`
+	Jitter float64 `json:"jitter"`
+}
+
+var reconnectBackoff = reconnectPolicy{BaseMs: 1000, MaxMs: 30000, Jitter: 0.5}
+
+// 服务端主动断开时，重连时间窗按在线人数放大，避免所有客户端同时涌入
+const reconnectSpreadPerClient = 20 * time.Millisecond
+
+// closeHandshakeWait 发出关闭帧后等待客户端回应的最长时间
+const closeHandshakeWait = 3 * time.Second
+
+// closeHint 关闭帧的 reason，JSON 编码（关闭帧 reason 最长 123 字节）
+type closeHint struct {
+	Reason           string `json:"reason"`
+	ReconnectAfterMs int64  `json:"reconnectAfterMs"`
+}
+
+// closeAllClients 在每个 WebSocket 客户端的发送队列末尾排一个关闭帧（排在此前的广播之后），
+// 每个客户端拿到不同的随机重连延迟
+func closeAllClients(reason string) {
+	if len(reason) > 64 {
+		reason = reason[:64]
+	}
+	hubSync()
+	conns := clients.Conns()
+	window := time.Duration(len(conns)) * reconnectSpreadPerClient
+	window = min(max(window, time.Second), time.Duration(reconnectBackoff.MaxMs)*time.Millisecond)
+	for _, c := range conns {
+		hint, _ := json.Marshal(closeHint{Reason: reason, ReconnectAfterMs: rand.Int63n(window.Milliseconds())})
+		c.sendClose(closeShuttingDown, string(hint))
+	}
+}
+
+// waitClientsGone 等待客户端回应关闭帧后断开，至多等到 ctx 结束
+func waitClientsGone(ctx context.Context) {
+	for {
+		n := clients.Count()
+		if n == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("⚠️  仍有 %d 个连接未断开，直接退出", n)
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// drainAndStop 停止接收新连接，通知客户端重连，等待进行中的请求（如上传）结束
+// 与客户端断开（至多 -drain-timeout），最后写回索引
+func drainAndStop(reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	// Shutdown 先关闭监听，再等待进行中的请求；已升级的 WebSocket 连接不在其等待范围内
+	stopped := make(chan error, 1)
+	go func() { stopped <- httpServer.Shutdown(ctx) }()
+	closeAllClients(reason)
+	if err := <-stopped; err != nil {
+		log.Printf("⚠️  等待进行中的请求超时: %v", err)
+	}
+	wctx, wcancel := context.WithTimeout(ctx, closeHandshakeWait)
+	waitClientsGone(wctx)
+	wcancel()
+	flushIndex()
+	shutdownTracing()
+	releaseInstanceLock()
+}
+
+// flushOnExit Ctrl+C / SIGTERM 时广播停机提示，通知客户端错峰重连，
+// 等待进行中的上传完成后写回尚未落盘的索引再退出
+func flushOnExit() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ch
+		log.Printf("🛑 正在停止服务，最多等待 %s", *drainTimeout)
+		broadcast(WSMessage{Type: "message", Data: Message{Text: "🛑 服务器即将关闭，请稍后重连", From: "system", Time: time.Now().Format("15:04:05")}})
+		drainAndStop("server shutting down")
+		os.Exit(0)
+	}()
+}
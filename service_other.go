@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func isWindowsService() bool { return false }
+
+func runAsService() {}
+
+// runService gochat service install|start|stop|uninstall 仅在 Windows 上可用
+func runService(args []string) int {
+	fmt.Fprintln(os.Stderr, "ℹ️  gochat service 仅支持 Windows；其他系统请使用 systemd、launchd 等托管服务")
+	return 1
+}
@@ -0,0 +1,145 @@
+//go:build windows
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Windows 服务：gochat service install|start|stop|uninstall，
+// 以服务运行时日志写入文件，数据默认放在 %ProgramData%\gochat 下
+
+const serviceName = "gochat"
+
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// dataDir 服务模式下的数据目录，工作目录通常是 System32，不能使用相对路径
+func dataDir() string {
+	base := os.Getenv("ProgramData")
+	if base == "" {
+		base = `C:\ProgramData`
+	}
+	return filepath.Join(base, "gochat")
+}
+
+type gochatService struct{}
+
+func (gochatService) Execute(args []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	go runServer()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for c := range req {
+		switch c.Cmd {
+		case svc.Interrogate:
+			status <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending, WaitHint: uint32((*drainTimeout + 5*time.Second).Milliseconds())}
+			log.Printf("🛑 收到服务停止请求，等待进行中的请求完成")
+			if httpServer != nil {
+				drainAndStop("server stopping")
+			}
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// runAsService 设置服务模式下的默认目录与日志，然后交给服务控制管理器
+func runAsService() {
+	dir := dataDir()
+	os.MkdirAll(dir, 0755)
+
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "upload-dir" {
+			explicit = true
+		}
+	})
+	if !explicit || !filepath.IsAbs(*uploadDir) {
+		if explicit {
+			*uploadDir = filepath.Join(dir, *uploadDir)
+		} else {
+			*uploadDir = filepath.Join(dir, "uploads")
+		}
+	}
+
+	if f, err := os.OpenFile(filepath.Join(dir, "gochat.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+		log.SetOutput(f)
+		os.Stdout, os.Stderr = f, f
+	}
+	if err := svc.Run(serviceName, gochatService{}); err != nil {
+		log.Fatalf("❌ 服务运行失败: %v", err)
+	}
+}
+
+// runService 管理 Windows 服务；install 后的其余参数会作为服务启动参数，如
+// gochat service install -port 3027 -max-size 200M
+func runService(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "用法: gochat service install|start|stop|uninstall [服务参数...]")
+		return 2
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 无法连接服务管理器（需要管理员权限）: %v\n", err)
+		return 1
+	}
+	defer m.Disconnect()
+
+	if args[0] == "install" {
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+		s, err := m.CreateService(serviceName, exe, mgr.Config{
+			DisplayName: "GoChat",
+			Description: "局域网聊天与文件分享服务",
+			StartType:   mgr.StartAutomatic,
+		}, args[1:]...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 安装服务失败: %v\n", err)
+			return 1
+		}
+		s.Close()
+		fmt.Printf("✅ 已安装服务 %s，数据目录 %s\n", serviceName, dataDir())
+		return 0
+	}
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 找不到服务 %s: %v\n", serviceName, err)
+		return 1
+	}
+	defer s.Close()
+
+	switch args[0] {
+	case "start":
+		err = s.Start()
+	case "stop":
+		_, err = s.Control(svc.Stop)
+	case "uninstall":
+		err = s.Delete()
+	default:
+		fmt.Fprintf(os.Stderr, "❌ 未知操作 %s\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %s 失败: %v\n", args[0], err)
+		return 1
+	}
+	fmt.Printf("✅ 服务 %s 已%s\n", serviceName, map[string]string{"start": "启动", "stop": "停止", "uninstall": "卸载"}[args[0]])
+	return 0
+}
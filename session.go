@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 一个 WebSocket 连接的生命周期：wsHandler 经 admit 准入、完成握手并由 claimIdentity 确定身份后用 newClient 建立连接状态，
+// register 交给 hub 登记并启动 writePump，readPump 在处理请求的协程中读取并分发客户端消息，
+// 返回后由 leave 注销。连接上的所有消息帧都经发送队列由 writePump 写出（见 writer.go），
+// 读循环只读不写；被拒绝或心跳超时时的关闭帧用 WriteControl 直接发送，gorilla/websocket 允许它与其他写并发
+
+// admit 握手前的准入：按来源 IP 与全局上限各占一个名额，满了以对应关闭码拒绝；
+// ok 时连接结束后须调用 release 归还名额
+func admit(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	ip := clientIP(r)
+	if !acquireIPSlot(ip) {
+		rejectWS(w, r, closeTooManyConns, errTooManyConns)
+		return nil, false
+	}
+	if !acquireSlot() {
+		releaseIPSlot(ip)
+		rejectWS(w, r, closeServerFull, errServerFull)
+		return nil, false
+	}
+	return func() {
+		releaseSlot()
+		releaseIPSlot(ip)
+	}, true
+}
+
+// claimIdentity 握手后确定 userId：?name= 严格声明身份，?uid= 尽量沿用，见 names.go；
+// 声明的名字不可用时以对应关闭码关闭连接并返回 false
+func claimIdentity(conn *websocket.Conn, r *http.Request) (userID string, exact bool, ok bool) {
+	userID, exact, code := requestedIdentity(r)
+	if code != 0 {
+		log.Printf("🚫 拒绝声明名字 %q: %s", r.URL.Query().Get("name"), closeReasons[code])
+		closeNow(conn, code)
+		return "", false, false
+	}
+	return userID, exact, true
+}
+
+// newClient 按握手请求建立连接状态，尚未登记到 hub
+func newClient(conn *websocket.Conn, r *http.Request, userID string) *client {
+	ua := r.UserAgent()
+	c := &client{
+		conn:        conn,
+		userID:      userID,
+		userAgent:   ua,
+		device:      classifyUserAgent(ua),
+		remoteAddr:  r.RemoteAddr,
+		connectedAt: time.Now(),
+		proto:       negotiateProtocol(r, conn),
+		locale:      localeFor(r),
+		caps:        parseCaps(r),
+		queue:       newSendQueue(),
+		done:        make(chan struct{}),
+	}
+	c.lastActive.Store(c.connectedAt.UnixNano())
+	c.setRooms([]string{defaultRoom})
+	c.initCompression(r)
+	return c
+}
+
+// welcome 注册后的第一帧 init，在 hub 协程中调用
+func welcome(c *client, resumed bool) {
+	c.send(mustMarshal(map[string]interface{}{
+		"type":            "init",
+		"userId":          c.userID,
+		"color":           colorOf(c.userID),
+		"nick":            nickOf(c.userID),
+		"emoji":           emojiURLs(),
+		"uploadToken":     issueUploadToken(c.conn, c.userID),
+		"resumeToken":     issueResumeToken(c),
+		"sessionKey":      issueSessionKey(c.userID),
+		"seq":             hubSeq,
+		"roomSeqs":        roomSeqsFor(c),
+		"protocolVersion": protocolVersion,
+		"features":        protocolFeatures(),
+		"dnd":             dndStatus(c.userID),
+		"resumed":         resumed,
+		"capabilities":    capabilitiesFor(c.userID),
+		"reconnect":       reconnectBackoff,
+		"rooms":           c.roomList(),
+	}))
+}
+
+// register 交给 hub 登记并启动 writePump；exact 声明的名字已被占用时关闭连接并返回 false。
+// 携带有效 resume 令牌时由 hub 顶替同一身份的旧连接，携带有效 sessionKey 时加入该用户已有的连接，
+// 否则若已存在同名在线用户（不区分大小写），改为随机分配
+func (c *client) register(r *http.Request, exact bool) bool {
+	reg := registration{
+		c:       c,
+		resume:  resumeConn(r.URL.Query().Get("resume"), c.userID),
+		session: r.URL.Query().Get("session"),
+		welcome: welcome,
+		exact:   exact,
+		reply:   make(chan registered),
+	}
+	hubRegister <- reg
+	joined := <-reg.reply
+	if joined.taken {
+		log.Printf("🚫 名字 %s 已被占用，拒绝连接", c.userID)
+		closeNow(c.conn, closeNameTaken)
+		return false
+	}
+	go c.writePump()
+	if joined.old != nil {
+		dropSuperseded(joined.old)
+	}
+	broadcastUsers()
+
+	// 接管、在保留期内恢复或加入已有连接时身份不变，hub 不发上线提示（见 presence.go）
+	switch {
+	case joined.attached:
+		log.Printf("🗂️ 用户 %s 新增一个连接，共 %d 个", c.userID, len(clients.ByID(c.userID)))
+	case joined.old != nil || joined.resumed:
+		log.Printf("🔁 用户 %s 重新连接，当前在线: %d", c.userID, joined.count)
+	default:
+		log.Printf("👥 用户 %s 上线，当前在线: %d", c.userID, joined.count)
+	}
+	return true
+}
+
+// leave 读循环结束后注销连接，身份已由新连接继承、仍有其他连接或仍在断线保留期内时不算离线
+func (c *client) leave() {
+	c.kill()
+	c.flushBandwidth()
+	abortRelaysFor(c)
+	u := unregistration{c: c, reply: make(chan departed)}
+	hubUnregister <- u
+	left := <-u.reply
+	revokeUploadTokens(c.conn)
+	switch {
+	case left.superseded:
+	case left.remaining:
+		// 该连接可能是用户在某个房间中的唯一连接
+		broadcastUsers()
+		log.Printf("🗂️ 用户 %s 关闭了一个连接，仍有其他连接在线", c.userID)
+	case left.lingering:
+		// 已由 startGrace 记录
+	default:
+		announceLeave(c.userID, left.count)
+	}
+}
+
+// readPump 读取客户端消息直到连接断开或被服务端关闭
+func (c *client) readPump() {
+	c.typing = newTypingState(c.userID)
+	defer c.typing.stop()
+
+	c.startKeepalive()
+	for {
+		mt, data, err := c.readFrame()
+		if err == errMessageTooLarge {
+			log.Printf("⚠️ 用户 %s 发送的消息超过 %s，断开连接", c.userID, humanSize(int64(wsReadLimit)))
+			sendWSError(c, "", "message_too_large", "message exceeds the size limit", map[string]interface{}{"maxBytes": int64(wsReadLimit)})
+			c.closeWith(closeMessageTooLarge)
+			return
+		}
+		if err != nil {
+			if isTimeout(err) {
+				log.Printf("⏱️ 用户 %s 心跳超时，断开连接", c.userID)
+				closeNow(c.conn, closeHeartbeatTimeout)
+			}
+			return
+		}
+		c.received(len(data))
+		c.touch()
+		if mt == websocket.BinaryMessage {
+			c.relayChunk(data)
+			continue
+		}
+		if ok, disconnect := c.allowInbound(time.Now()); disconnect {
+			c.closeWith(closeRateLimited)
+			return
+		} else if !ok {
+			continue
+		}
+		// 解析消息封装，按类型分发见 wstypes.go
+		var envelope wsEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			sendWSError(c, "", "invalid_json", "message is not a valid JSON object", nil)
+			continue
+		}
+		dispatchWS(c, envelope)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 分享页：为文件链接提供 OpenGraph 元信息，粘贴到 IM 中可以展开预览
+
+var shareTmpl = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+  <title>{{.Name}}</title>
+  <meta property="og:type" content="website">
+  <meta property="og:site_name" content="GoChat">
+  <meta property="og:title" content="{{.Name}}">
+  <meta property="og:description" content="{{.Description}}">
+  <meta property="og:image" content="{{.Image}}">
+  <meta property="og:url" content="{{.ShareURL}}">
+  <meta name="twitter:card" content="{{if .IsImage}}summary_large_image{{else}}summary{{end}}">
+  <link rel="icon" href="/gochat.ico">
+  <style>
+    body { font-family: -apple-system, BlinkMacSystemFont, sans-serif; background: #fafafa; margin: 0; padding: 40px 20px; text-align: center; color: #333; }
+    .card { max-width: 560px; margin: 0 auto; background: white; border-radius: 12px; padding: 32px 24px; box-shadow: 0 1px 3px rgba(0,0,0,0.1); }
+    h1 { font-size: 20px; word-break: break-all; }
+    .meta { color: #666; margin-bottom: 24px; }
+    img { max-width: 100%; border-radius: 8px; margin-bottom: 24px; }
+    a.download { display: inline-block; background: #0084ff; color: white; text-decoration: none; padding: 14px 32px; border-radius: 24px; font-size: 18px; }
+    .code { max-width: 960px; margin: 24px auto 0; }
+    {{.CSS}}
+  </style>
+</head>
+<body>
+  <div class="card">
+    {{if .IsImage}}<img src="{{.FileURL}}" alt="{{.Name}}">{{end}}
+    <h1>📎 {{.Name}}</h1>
+    <div class="meta">{{.Description}}{{if .Code}} · 提取码 <b>{{.Code}}</b>{{end}}</div>
+    <a class="download" href="{{.FileURL}}" download="{{.Name}}">⬇️ 下载文件</a>
+  </div>
+  {{if .Highlighted}}<div class="code">{{.Highlighted}}</div>{{end}}
+</body>
+</html>
+`))
+
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true}
+
+// 非图片文件按类型给一个色块图标
+var iconColors = map[string]color.RGBA{
+	"pdf":     {0xd3, 0x2f, 0x2f, 0xff},
+	"video":   {0x7b, 0x1f, 0xa2, 0xff},
+	"audio":   {0xf5, 0x7c, 0x00, 0xff},
+	"archive": {0x79, 0x55, 0x48, 0xff},
+	"doc":     {0x19, 0x76, 0xd2, 0xff},
+	"file":    {0x75, 0x75, 0x75, 0xff},
+}
+
+var iconCache sync.Map // kind -> []byte
+
+func fileKind(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pdf":
+		return "pdf"
+	case ".mp4", ".mov", ".mkv", ".webm", ".avi":
+		return "video"
+	case ".mp3", ".wav", ".flac", ".ogg", ".m4a":
+		return "audio"
+	case ".zip", ".rar", ".7z", ".tar", ".gz", ".tgz":
+		return "archive"
+	case ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".txt", ".md":
+		return "doc"
+	}
+	return "file"
+}
+
+// shareHandler GET /share/{savedName}
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	savedName := filepath.Base(strings.TrimPrefix(r.URL.Path, "/share/"))
+	if savedName == "" || isHiddenName(savedName) || strings.Contains(savedName, "..") {
+		errInvalidFilename(w, r)
+		return
+	}
+
+	info, ok := lookupFile(savedName)
+	if !ok {
+		errFileNotFound(w, r)
+		return
+	}
+
+	base := baseURL(r)
+	isImage := imageExts[strings.ToLower(filepath.Ext(info.Name))]
+	ogImage := base + "/share/icon/" + fileKind(info.Name) + ".png"
+	if isImage {
+		ogImage = base + info.URL
+	}
+
+	// 认识语言的文本文件直接嵌入高亮后的内容
+	var code template.HTML
+	if lang := languageFor(info.Name, ""); lang != nil {
+		if text, truncated, ok := readTextPreview(info.diskPath()); ok {
+			code = renderHighlight(text, lang, truncated)
+		}
+	}
+
+	// 匿名上传（未带上传令牌）没有上传者
+	uploader := "匿名用户"
+	if info.Owner != "" {
+		uploader = displayName(info.Owner)
+	}
+
+	var buf bytes.Buffer
+	err := shareTmpl.Execute(&buf, map[string]interface{}{
+		"CSS":         template.CSS(highlightCSS),
+		"Highlighted": code,
+		"Name":        info.Name,
+		"Uploader":    uploader,
+		"Description": fmt.Sprintf("%s · %s 上传于 %s", localeFor(r).size(info.Size), uploader, info.Uploaded.Format("2006-01-02 15:04")),
+		"Code":        info.Code,
+		"Image":       ogImage,
+		"IsImage":     isImage,
+		"FileURL":     base + info.URL,
+		"ShareURL":    base + "/share/" + info.SavedName,
+	})
+	if err != nil {
+		errServer(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// lookupFile 按 savedName 查找文件；不在内存索引中（如重启后）时退回到上传目录下的同名文件，
+// 此时名字必须是单独一段，不能含路径分隔符或 ..
+func lookupFile(savedName string) (FileInfo, bool) {
+	filesMu.RLock()
+	info, ok := fileList[savedName]
+	filesMu.RUnlock()
+	if ok {
+		return info, true
+	}
+	if !plainFileName(savedName) {
+		return FileInfo{}, false
+	}
+	st, err := os.Stat(filepath.Join(*uploadDir, savedName))
+	if err != nil || st.IsDir() {
+		return FileInfo{}, false
+	}
+	return FileInfo{Name: savedName, SavedName: savedName, Size: st.Size(), Uploaded: st.ModTime(), URL: "/files/" + savedName}, true
+}
+
+// plainFileName 名字是上传目录下的一个普通文件名：非隐藏、不含路径分隔符与 ..
+func plainFileName(name string) bool {
+	return name != "" && !isHiddenName(name) && !strings.Contains(name, "..") &&
+		!strings.ContainsAny(name, `/\`) && filepath.Base(name) == name
+}
+
+// shareIconHandler GET /share/icon/{kind}.png，生成纯色文件图标
+func shareIconHandler(w http.ResponseWriter, r *http.Request) {
+	kind := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/share/icon/"), ".png")
+	c, ok := iconColors[kind]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "not_found", "Not found", nil)
+		return
+	}
+
+	data, ok := iconCache.Load(kind)
+	if !ok {
+		const size = 256
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				// 右上角折角
+				if x-y > size-64 {
+					img.Set(x, y, color.RGBA{0xee, 0xee, 0xee, 0xff})
+				} else {
+					img.Set(x, y, c)
+				}
+			}
+		}
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+		data, _ = iconCache.LoadOrStore(kind, buf.Bytes())
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data.([]byte))
+}
+
+// fileCardText 文件卡片（text 为 {"type":"file",...} 的群聊/私聊消息）的纯文本版本
+func fileCardText(msg WSMessage, l *locale) (WSMessage, bool) {
+	if (msg.Type != "message" && msg.Type != "private") || !strings.HasPrefix(msg.Data.Text, "{") {
+		return msg, false
+	}
+	var card struct {
+		Type     string `json:"type"`
+		URL      string `json:"url"`
+		ShareURL string `json:"shareUrl"`
+		Name     string `json:"name"`
+		Size     int64  `json:"size"`
+	}
+	if json.Unmarshal([]byte(msg.Data.Text), &card) != nil || card.Type != "file" {
+		return msg, false
+	}
+	link := card.ShareURL
+	if link == "" {
+		link = card.URL
+	}
+	msg.Data.Text = fmt.Sprintf("📎 %s shared %s (%s): %s", msg.Data.From, card.Name, l.size(card.Size), absoluteLink(link))
+	return msg, true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// 文件卡片的纯文本版本：优先分享链接，配置了 -public-url 时补全为绝对地址，大小按接收方语言格式化
+func TestFileCardText(t *testing.T) {
+	zh, de := locales[0], locales[2]
+	tests := []struct {
+		name      string
+		msg       WSMessage
+		publicURL string
+		l         *locale
+		want      string
+		ok        bool
+	}{
+		{
+			name:      "share link made absolute",
+			msg:       WSMessage{Type: "message", Data: Message{From: "alice", Text: `{"type":"file","url":"/uploads/a.png","shareUrl":"/s/abc","name":"a.png","size":1536}`}},
+			publicURL: "https://chat.example.com", l: zh,
+			want: "📎 alice shared a.png (1.5 KB): https://chat.example.com/s/abc", ok: true,
+		},
+		{
+			name: "download link without public url", msg: WSMessage{Type: "private", Data: Message{From: "bob", Text: `{"type":"file","url":"/uploads/b.zip","name":"b.zip","size":3145728}`}},
+			l: de, want: "📎 bob shared b.zip (3,0 MB): /uploads/b.zip", ok: true,
+		},
+		{name: "plain chat", msg: WSMessage{Type: "message", Data: Message{From: "alice", Text: "hello"}}, l: zh},
+		{name: "other json", msg: WSMessage{Type: "message", Data: Message{From: "alice", Text: `{"type":"poll"}`}}, l: zh},
+		{name: "other type", msg: WSMessage{Type: "file_comment", Data: Message{Text: `{"type":"file"}`}}, l: zh},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, publicURL, tt.publicURL)
+			got, ok := fileCardText(tt.msg, tt.l)
+			if ok != tt.ok || ok && got.Data.Text != tt.want {
+				t.Fatalf("got %q, %v; want %q, %v", got.Data.Text, ok, tt.want, tt.ok)
+			}
+			if ok && got.Type != tt.msg.Type {
+				t.Fatalf("type changed to %q", got.Type)
+			}
+		})
+	}
+}
+
+// 未声明 file_card 的连接收到纯文本，其余连接收到原样的卡片
+func TestFileCardFallback(t *testing.T) {
+	srv := newTestServer(t, nil)
+	web := dialWS(t, srv, "uid=web")
+	irc := dialWS(t, srv, "uid=irc&caps=file_comment")
+
+	card := `{"type":"file","url":"/uploads/a.txt","name":"a.txt","size":12}`
+	web.sendJSON(map[string]interface{}{"type": "message", "data": map[string]string{"text": card}})
+
+	if got := chatText(irc.expectWhere("message", func(m map[string]interface{}) bool { return strings.HasPrefix(chatText(m), "📎") })); got != "📎 web shared a.txt (12 B): /uploads/a.txt" {
+		t.Fatalf("irc got %q", got)
+	}
+	if got := chatText(web.expectWhere("message", func(m map[string]interface{}) bool { return strings.HasPrefix(chatText(m), "{") })); got != card {
+		t.Fatalf("web got %q, want the card unchanged", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// 文件收藏：每个用户自己的收藏清单，收藏人数对所有人可见
+
+// savedName -> 收藏者 userId 集合，与 fileList 一起由 filesMu 保护
+var fileStars = make(map[string]map[string]bool)
+
+// starIdentity 收藏需要可识别的用户：以 X-Upload-Token 对应的在线用户为准，不接受只给出 from
+func starIdentity(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := tokenUser(r)
+	if userID == "" {
+		writeError(w, r, http.StatusUnauthorized, "identity_required", "Starring requires a user identity: connect over WebSocket and send the upload token from init as X-Upload-Token", nil)
+		return "", false
+	}
+	return userID, true
+}
+
+// starredBy 返回文件的收藏者列表，供持久化使用，调用方需持有 filesMu
+func starredBy(savedName string) []string {
+	users := make([]string, 0, len(fileStars[savedName]))
+	for u := range fileStars[savedName] {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// fileStarHandler PUT/DELETE /api/files/{savedName}/star
+func fileStarHandler(w http.ResponseWriter, r *http.Request, savedName string) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	userID, ok := starIdentity(w, r)
+	if !ok {
+		return
+	}
+
+	filesMu.Lock()
+	if _, exists := fileList[savedName]; !exists {
+		filesMu.Unlock()
+		errFileNotFound(w, r)
+		return
+	}
+	if r.Method == http.MethodPut {
+		if fileStars[savedName] == nil {
+			fileStars[savedName] = make(map[string]bool)
+		}
+		fileStars[savedName][userID] = true
+	} else {
+		delete(fileStars[savedName], userID)
+		if len(fileStars[savedName]) == 0 {
+			delete(fileStars, savedName)
+		}
+	}
+	count := len(fileStars[savedName])
+	filesMu.Unlock()
+	saveIndex()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"starred": r.Method == http.MethodPut, "starredBy": count})
+}
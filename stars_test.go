@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 收藏的身份只认上传令牌：只给出 from 既不能替别人收藏，也看不到别人的收藏清单
+func TestStarIdentity(t *testing.T) {
+	srv := newTestServer(t, map[string]http.HandlerFunc{
+		"/api/files":  listFilesHandler,
+		"/api/files/": fileItemHandler,
+	})
+	alice := dialWS(t, srv, "uid=alice")
+	bob := dialWS(t, srv, "uid=bob")
+
+	filesMu.Lock()
+	fileList["star-test.txt"] = FileInfo{Name: "star-test.txt", SavedName: "star-test.txt", Uploaded: time.Now()}
+	filesMu.Unlock()
+	t.Cleanup(func() {
+		filesMu.Lock()
+		delete(fileList, "star-test.txt")
+		delete(fileStars, "star-test.txt")
+		filesMu.Unlock()
+	})
+
+	do := func(method, path, token string) (int, string, []FileInfo) {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("X-Upload-Token", token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			var body struct {
+				Error apiErrorBody `json:"error"`
+			}
+			json.NewDecoder(resp.Body).Decode(&body)
+			return resp.StatusCode, body.Error.Code, nil
+		}
+		var list []FileInfo
+		if method == http.MethodGet {
+			json.NewDecoder(resp.Body).Decode(&list)
+		}
+		return resp.StatusCode, "", list
+	}
+
+	if status, code, _ := do("PUT", "/api/files/star-test.txt/star?from=alice", ""); status != 401 || code != "identity_required" {
+		t.Fatalf("star with from only: %d %q, want 401 identity_required", status, code)
+	}
+	if status, code, _ := do("PUT", "/api/files/star-test.txt/star", alice.uploadToken()); status != 200 {
+		t.Fatalf("star with alice's token: %d %q", status, code)
+	}
+	if status, code, _ := do("GET", "/api/files?starred=1&from=alice", ""); status != 401 || code != "identity_required" {
+		t.Fatalf("list alice's stars with from only: %d %q, want 401 identity_required", status, code)
+	}
+	// bob 带着自己的令牌冒充 alice，拿到的仍是自己的（空）清单
+	if _, _, list := do("GET", "/api/files?starred=1&from=alice", bob.uploadToken()); len(list) != 0 {
+		t.Fatalf("bob sees %d starred files, want 0", len(list))
+	}
+	if _, _, list := do("GET", "/api/files?starred=1", alice.uploadToken()); len(list) != 1 || list[0].StarredBy != 1 {
+		t.Fatalf("alice's stars: %+v", list)
+	}
+	if status, code, _ := do("DELETE", "/api/files/star-test.txt/star?from=alice", bob.uploadToken()); status != 200 {
+		t.Fatalf("bob unstar: %d %q", status, code)
+	}
+	if _, _, list := do("GET", "/api/files?starred=1", alice.uploadToken()); len(list) != 1 {
+		t.Fatal("bob's unstar with from=alice removed alice's star")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 统计：按小时聚合的消息数、上传量与活跃用户，GET /api/stats?range=7d。
+// 热路径只做原子自增，由定时器每隔 statsFlushInterval 汇总进小时桶；数据只保存在内存中
+
+var statsAdminOnly = flag.Bool("stats-admin-only", false, "仅管理员可访问 /api/stats")
+
+const (
+	statsFlushInterval = 10 * time.Second
+	statsRetention     = 31 * 24 * time.Hour
+	statsTopUsers      = 10
+)
+
+type statBucket struct {
+	Messages    int64
+	Uploads     int64
+	UploadBytes int64
+	Users       map[string]int64
+}
+
+var (
+	pendingUploads     atomic.Int64
+	pendingUploadBytes atomic.Int64
+	pendingUserMsgs    sync.Map // userId -> *atomic.Int64
+
+	statBuckets = make(map[time.Time]*statBucket) // 整点 -> 桶
+	statsMu     sync.Mutex
+	statsOnce   sync.Once
+)
+
+func countMessageStat(from string) {
+	statsOnce.Do(startStatsFlusher)
+	v, ok := pendingUserMsgs.Load(from)
+	if !ok {
+		v, _ = pendingUserMsgs.LoadOrStore(from, new(atomic.Int64))
+	}
+	v.(*atomic.Int64).Add(1)
+}
+
+func countUploadStat(size int64) {
+	statsOnce.Do(startStatsFlusher)
+	pendingUploads.Add(1)
+	pendingUploadBytes.Add(size)
+}
+
+func startStatsFlusher() {
+	go func() {
+		for range time.Tick(statsFlushInterval) {
+			flushStats()
+		}
+	}()
+}
+
+// flushStats 把待汇总的计数并入当前小时桶，并丢弃超出保留期的桶
+func flushStats() {
+	now := time.Now()
+	hour := now.Truncate(time.Hour)
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	b := statBuckets[hour]
+	if b == nil {
+		b = &statBucket{Users: make(map[string]int64)}
+		statBuckets[hour] = b
+	}
+	b.Uploads += pendingUploads.Swap(0)
+	b.UploadBytes += pendingUploadBytes.Swap(0)
+	pendingUserMsgs.Range(func(k, v interface{}) bool {
+		if n := v.(*atomic.Int64).Swap(0); n > 0 {
+			b.Messages += n
+			b.Users[k.(string)] += n
+		}
+		return true
+	})
+	for t := range statBuckets {
+		if now.Sub(t) > statsRetention {
+			delete(statBuckets, t)
+		}
+	}
+}
+
+// parseStatsRange 支持 24h、7d 等写法，最长为保留期
+func parseStatsRange(s string) (time.Duration, bool) {
+	if s == "" {
+		return 7 * 24 * time.Hour, true
+	}
+	var d time.Duration
+	if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && strings.HasSuffix(s, "d") {
+		d = time.Duration(n) * 24 * time.Hour
+	} else if v, err := time.ParseDuration(s); err == nil {
+		d = v
+	}
+	if d <= 0 || d > statsRetention {
+		return 0, false
+	}
+	return d, true
+}
+
+type statsPoint struct {
+	Start       time.Time `json:"start"`
+	Messages    int64     `json:"messages"`
+	Uploads     int64     `json:"uploads"`
+	UploadBytes int64     `json:"uploadBytes"`
+}
+
+// statsHandler GET /api/stats?range=7d，不超过 2 天按小时分桶，否则按天
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed(w, r)
+		return
+	}
+	if *statsAdminOnly && !isAdmin(r) {
+		errAdminRequired(w, r)
+		return
+	}
+	span, ok := parseStatsRange(r.URL.Query().Get("range"))
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_range", "Invalid range (e.g. 24h, 7d)", map[string]interface{}{"maxDays": int(statsRetention.Hours() / 24)})
+		return
+	}
+	step := 24 * time.Hour
+	if span <= 48*time.Hour {
+		step = time.Hour
+	}
+	flushStats()
+
+	now := time.Now()
+	since := now.Add(-span)
+	points := make(map[time.Time]*statsPoint)
+	users := make(map[string]int64)
+	var hours [24]int64
+
+	statsMu.Lock()
+	for t, b := range statBuckets {
+		if t.Add(time.Hour).Before(since) {
+			continue
+		}
+		start := t.Truncate(step)
+		if step == 24*time.Hour {
+			// 按本地日期分桶
+			start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		}
+		p := points[start]
+		if p == nil {
+			p = &statsPoint{Start: start}
+			points[start] = p
+		}
+		p.Messages += b.Messages
+		p.Uploads += b.Uploads
+		p.UploadBytes += b.UploadBytes
+		hours[t.Hour()] += b.Messages
+		for u, n := range b.Users {
+			users[u] += n
+		}
+	}
+	statsMu.Unlock()
+
+	series := make([]statsPoint, 0, len(points))
+	for _, p := range points {
+		series = append(series, *p)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Start.Before(series[j].Start) })
+
+	type userCount struct {
+		UserID   string `json:"userId"`
+		Messages int64  `json:"messages"`
+	}
+	top := make([]userCount, 0, len(users))
+	for u, n := range users {
+		top = append(top, userCount{u, n})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Messages != top[j].Messages {
+			return top[i].Messages > top[j].Messages
+		}
+		return top[i].UserID < top[j].UserID
+	})
+	if len(top) > statsTopUsers {
+		top = top[:statsTopUsers]
+	}
+
+	period, usage := bandwidthSnapshot()
+	if len(usage) > statsTopUsers {
+		usage = usage[:statsTopUsers]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bandwidth": map[string]interface{}{
+			"periodStart": period,
+			"wsBytesIn":   wsBytesIn.Load(),
+			"wsBytesOut":  wsBytesOut.Load(),
+			"topUsers":    usage,
+		},
+		"since":        since,
+		"bucket":       map[bool]string{true: "hour", false: "day"}[step == time.Hour],
+		"series":       series,
+		"topUsers":     top,
+		"busiestHours": hours, // 下标为本地时间的小时
+	})
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// gochat sync：把本地目录与服务端共享文件做镜像
+
+const (
+	syncStateFile  = ".gochat-sync.json"
+	syncIgnoreFile = ".gochatignore"
+	syncPartSuffix = ".gochat-part"
+)
+
+// syncState 保存在同步目录中，记录变更游标、服务端视图与上次同步一致的文件
+type syncState struct {
+	Cursor string              `json:"cursor"`
+	Remote map[string]FileInfo `json:"remote"` // savedName -> 服务端文件
+	Synced map[string]string   `json:"synced"` // 本地文件名 -> 上次同步时的 SHA-256
+}
+
+type localFile struct {
+	SHA256  string
+	ModTime time.Time
+}
+
+type syncClient struct {
+	server string
+	dir    string
+	dryRun bool
+	ignore []string
+	state  syncState
+
+	downloaded, uploaded, deleted, failed int
+}
+
+func runSync(args []string) int {
+	set := flag.NewFlagSet("sync", flag.ExitOnError)
+	server := set.String("server", "http://127.0.0.1:3027", "服务端地址")
+	dir := set.String("dir", ".", "本地同步目录")
+	mode := set.String("mode", "pull", "同步模式：pull | push | two-way")
+	dryRun := set.Bool("dry-run", false, "只列出将要执行的操作，不实际传输")
+	set.Parse(args)
+
+	if *mode != "pull" && *mode != "push" && *mode != "two-way" {
+		fmt.Fprintf(os.Stderr, "❌ 未知同步模式: %s（可选 pull | push | two-way）\n", *mode)
+		return 2
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 无法创建同步目录 %s: %v\n", *dir, err)
+		return 1
+	}
+
+	c := &syncClient{server: strings.TrimRight(*server, "/"), dir: *dir, dryRun: *dryRun}
+	c.loadIgnore()
+	c.loadState()
+
+	if err := c.refreshRemote(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 获取服务端文件列表失败: %v\n", err)
+		return 1
+	}
+	local, err := c.scanLocal()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 扫描本地目录失败: %v\n", err)
+		return 1
+	}
+
+	c.reconcile(c.remoteByName(), local, *mode != "push", *mode != "pull")
+
+	if !c.dryRun {
+		if err := c.saveState(); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  保存同步状态失败: %v\n", err)
+		}
+	}
+	fmt.Printf("✅ 同步完成：下载 %d，上传 %d，删除 %d，失败 %d\n", c.downloaded, c.uploaded, c.deleted, c.failed)
+	if c.failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (c *syncClient) loadIgnore() {
+	data, err := os.ReadFile(filepath.Join(c.dir, syncIgnoreFile))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			c.ignore = append(c.ignore, line)
+		}
+	}
+}
+
+func (c *syncClient) ignored(name string) bool {
+	if name == syncStateFile || name == syncIgnoreFile || strings.HasSuffix(name, syncPartSuffix) {
+		return true
+	}
+	for _, p := range c.ignore {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *syncClient) loadState() {
+	if data, err := os.ReadFile(filepath.Join(c.dir, syncStateFile)); err == nil {
+		json.Unmarshal(data, &c.state)
+	}
+	if c.state.Remote == nil {
+		c.state.Remote = make(map[string]FileInfo)
+	}
+	if c.state.Synced == nil {
+		c.state.Synced = make(map[string]string)
+	}
+}
+
+func (c *syncClient) saveState() error {
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.dir, syncStateFile), data, 0644)
+}
+
+func (c *syncClient) getJSON(path string, v interface{}) (int, error) {
+	resp, err := outboundClient.Get(c.server + path)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// refreshRemote 有有效游标时按变更日志增量更新服务端视图，否则全量拉取
+func (c *syncClient) refreshRemote() error {
+	var feed struct {
+		Changes    []FileChange `json:"changes"`
+		NextCursor string       `json:"nextCursor"`
+	}
+	if c.state.Cursor != "" {
+		status, err := c.getJSON("/api/files/changes?since="+url.QueryEscape(c.state.Cursor), &feed)
+		if err == nil {
+			for _, ch := range feed.Changes {
+				switch ch.Op {
+				case ChangeAdd:
+					c.state.Remote[ch.File.SavedName] = ch.File
+				case ChangeDelete:
+					delete(c.state.Remote, ch.File.SavedName)
+				}
+			}
+			c.state.Cursor = feed.NextCursor
+			return nil
+		}
+		if status != http.StatusGone {
+			return err
+		}
+		fmt.Println("ℹ️  游标已过期，执行全量同步")
+	}
+
+	// 先取游标再列文件，二者之间发生的变更会在下次增量中补上
+	if _, err := c.getJSON("/api/files/changes", &feed); err != nil {
+		return err
+	}
+	var list []FileInfo
+	if _, err := c.getJSON("/api/files", &list); err != nil {
+		return err
+	}
+	c.state.Remote = make(map[string]FileInfo, len(list))
+	for _, f := range list {
+		c.state.Remote[f.SavedName] = f
+	}
+	c.state.Cursor = feed.NextCursor
+	return nil
+}
+
+// remoteByName 按原始文件名归并服务端文件，同名时取最新上传的一份
+func (c *syncClient) remoteByName() map[string]FileInfo {
+	byName := make(map[string]FileInfo)
+	for _, f := range c.state.Remote {
+		name := filepath.Base(f.Name)
+		if name == "." || name == ".." || name == "/" || c.ignored(name) {
+			continue
+		}
+		if cur, ok := byName[name]; !ok || f.Uploaded.After(cur.Uploaded) {
+			byName[name] = f
+		}
+	}
+	return byName
+}
+
+func (c *syncClient) scanLocal() (map[string]localFile, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	local := make(map[string]localFile)
+	for _, e := range entries {
+		if !e.Type().IsRegular() || c.ignored(e.Name()) {
+			continue
+		}
+		st, err := e.Info()
+		if err != nil {
+			continue
+		}
+		sum, err := fileSHA256(filepath.Join(c.dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		local[e.Name()] = localFile{SHA256: sum, ModTime: st.ModTime()}
+	}
+	return local, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reconcile 比较两端并执行传输；双向模式下真正的冲突按“最后写入者胜出”处理
+func (c *syncClient) reconcile(remote map[string]FileInfo, local map[string]localFile, pull, push bool) {
+	names := make(map[string]bool)
+	for n := range remote {
+		names[n] = true
+	}
+	for n := range local {
+		names[n] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for n := range names {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		r, inRemote := remote[name]
+		l, inLocal := local[name]
+		prev := c.state.Synced[name]
+
+		switch {
+		case inRemote && inLocal:
+			if r.SHA256 != "" && r.SHA256 == l.SHA256 {
+				c.state.Synced[name] = l.SHA256
+				continue
+			}
+			switch {
+			case pull && !push:
+				c.download(r, name)
+			case push && !pull:
+				c.upload(name, l)
+			case prev == l.SHA256:
+				// 本地未改动，只有服务端变了
+				c.download(r, name)
+			case prev != "" && prev == r.SHA256:
+				c.upload(name, l)
+			case l.ModTime.After(r.Uploaded):
+				c.upload(name, l)
+			default:
+				c.download(r, name)
+			}
+
+		case inRemote:
+			if !pull {
+				continue
+			}
+			if push && prev != "" && prev == r.SHA256 {
+				// 上次同步后本地删除了该文件
+				c.deleteRemote(r, name)
+				continue
+			}
+			c.download(r, name)
+
+		case inLocal:
+			if prev != "" && prev == l.SHA256 && pull {
+				// 上次同步后服务端删除了该文件
+				c.deleteLocal(name)
+				continue
+			}
+			if push {
+				c.upload(name, l)
+			}
+		}
+	}
+}
+
+func (c *syncClient) report(action, name string, err error) bool {
+	if c.dryRun {
+		fmt.Printf("[dry-run] %s %s\n", action, name)
+		return false
+	}
+	if err != nil {
+		c.failed++
+		fmt.Printf("❌ %s %s 失败: %v\n", action, name, err)
+		return false
+	}
+	fmt.Printf("%s %s\n", action, name)
+	return true
+}
+
+func (c *syncClient) download(f FileInfo, name string) {
+	var err error
+	if !c.dryRun {
+		err = c.fetchFile(f, name)
+	}
+	if c.report("⬇️  下载", name, err) {
+		c.downloaded++
+		c.state.Synced[name] = f.SHA256
+	}
+}
+
+// fetchFile 先写入以 savedName 命名的临时文件，中断后用 Range 请求续传，校验通过再改名
+func (c *syncClient) fetchFile(f FileInfo, name string) error {
+	part := filepath.Join(c.dir, f.SavedName+syncPartSuffix)
+	var offset int64
+	if st, err := os.Stat(part); err == nil {
+		offset = st.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.server+f.URL, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// 服务端未按 Range 返回，从头下载
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 临时文件已完整，直接进入校验
+		flags = 0
+	default:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if flags != 0 {
+		out, err := os.OpenFile(part, flags, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, resp.Body)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.SHA256 != "" {
+		sum, err := fileSHA256(part)
+		if err != nil {
+			return err
+		}
+		if sum != f.SHA256 {
+			os.Remove(part)
+			return fmt.Errorf("SHA-256 mismatch (got %s, want %s)", sum, f.SHA256)
+		}
+	}
+	final := filepath.Join(c.dir, name)
+	if err := os.Rename(part, final); err != nil {
+		return err
+	}
+	// 本地修改时间对齐服务端上传时间，供双向同步比较新旧
+	return os.Chtimes(final, f.Uploaded, f.Uploaded)
+}
+
+func (c *syncClient) upload(name string, l localFile) {
+	var err error
+	if !c.dryRun {
+		_, err = uploadFile(c.server, filepath.Join(c.dir, name))
+	}
+	if c.report("⬆️  上传", name, err) {
+		c.uploaded++
+		c.state.Synced[name] = l.SHA256
+	}
+}
+
+func (c *syncClient) deleteLocal(name string) {
+	var err error
+	if !c.dryRun {
+		err = os.Remove(filepath.Join(c.dir, name))
+	}
+	if c.report("🗑️  删除本地", name, err) {
+		c.deleted++
+		delete(c.state.Synced, name)
+	}
+}
+
+func (c *syncClient) deleteRemote(f FileInfo, name string) {
+	var err error
+	if !c.dryRun {
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodDelete, c.server+"/api/files/"+url.PathEscape(f.SavedName), nil)
+		if err == nil {
+			var resp *http.Response
+			resp, err = outboundClient.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+					err = fmt.Errorf("unexpected status %s", resp.Status)
+				}
+			}
+		}
+	}
+	if c.report("🗑️  删除服务端", name, err) {
+		c.deleted++
+		delete(c.state.Synced, name)
+		delete(c.state.Remote, f.SavedName)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+)
+
+// 多标签页：同一用户可以同时有多个连接。init 中下发 sessionKey，同一用户的所有连接拿到同一个；
+// 新连接带上 /ws?uid=<userId>&session=<sessionKey> 即加入该用户已有的连接，而不是被分配新的 userId。
+// 信令、私聊等定向消息发给该用户的全部连接，上下线提示与在线人数按用户计，
+// 最后一个连接关闭（且断线保留期结束）时才算离线。sessionKey 在用户离线后作废，下次上线重新生成
+
+var maxConnsPerUser = flag.Int("max-conns-per-user", 8, "同一用户可同时保持的连接数（如多个标签页），超出时新连接分配新的 userId；1 表示不允许多个连接")
+
+var userSessions = newExpiringMap[string, string]("user_sessions", 0, registryMaxEntries) // userId -> sessionKey
+
+// issueSessionKey 该用户的 sessionKey，没有时生成；在 hub 协程中调用
+func issueSessionKey(userID string) string {
+	if *noTakeover || *maxConnsPerUser <= 1 {
+		return ""
+	}
+	if key, ok := userSessions.Get(userID); ok {
+		return key
+	}
+	key := newMessageID() + newMessageID()
+	userSessions.Set(userID, key)
+	return key
+}
+
+// validSessionKey key 是否为该用户当前的 sessionKey，调用方为 hub 协程
+func validSessionKey(userID, key string) bool {
+	if key == "" || *noTakeover || *maxConnsPerUser <= 1 {
+		return false
+	}
+	want, ok := userSessions.Get(userID)
+	return ok && subtle.ConstantTimeCompare([]byte(key), []byte(want)) == 1
+}
+
+// latestActive 最近收到过消息帧的连接，没有连接时为 nil
+func latestActive(conns []*client) *client {
+	var latest *client
+	for _, c := range conns {
+		if latest == nil || c.lastActive.Load() > latest.lastActive.Load() {
+			latest = c
+		}
+	}
+	return latest
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+// dialTabs 打开一个旁观者连接，以及同一 uid 的两个标签页，第二个带上第一个的 sessionKey
+func dialTabs(t *testing.T, uid string) (observer, tab1, tab2 *testConn) {
+	t.Helper()
+	srv := newTestServer(t, nil)
+	observer = dialWS(t, srv, "uid=observer")
+	tab1 = dialWS(t, srv, "uid="+uid)
+	key, _ := tab1.init["sessionKey"].(string)
+	if key == "" {
+		t.Fatalf("init has no sessionKey: %v", tab1.init)
+	}
+	tab2 = dialWS(t, srv, "uid="+uid+"&session="+key)
+	if tab2.userID() != uid {
+		t.Fatalf("second tab got userId %q, want %q", tab2.userID(), uid)
+	}
+	return observer, tab1, tab2
+}
+
+func lobbyMembers() int {
+	for _, r := range roomCounts() {
+		if r.Room == defaultRoom {
+			return r.Members
+		}
+	}
+	return 0
+}
+
+func TestSecondTabReceivesMessages(t *testing.T) {
+	observer, tab1, tab2 := dialTabs(t, "tabs")
+	observer.sendJSON(map[string]interface{}{"type": "message", "data": map[string]string{"text": "to all tabs"}})
+	for _, tab := range []*testConn{tab1, tab2} {
+		tab.expectWhere("message", func(m map[string]interface{}) bool { return chatText(m) == "to all tabs" })
+	}
+}
+
+func TestClosingOneTabKeepsUserOnline(t *testing.T) {
+	_, tab1, tab2 := dialTabs(t, "tabs")
+	if n := lobbyMembers(); n != 2 {
+		t.Fatalf("lobby members = %d with two tabs open, want 2", n)
+	}
+	tab1.conn.Close()
+	waitFor(t, "first tab to be removed", func() bool { return len(clients.ByID("tabs")) == 1 })
+	if !clients.Online("tabs") || lobbyMembers() != 2 {
+		t.Fatalf("after closing one tab: online = %v, lobby members = %d", clients.Online("tabs"), lobbyMembers())
+	}
+	// 剩下的标签页仍正常收发
+	tab2.sendJSON(map[string]interface{}{"type": "message", "data": map[string]string{"text": "still here"}})
+	tab2.expectWhere("message", func(m map[string]interface{}) bool { return chatText(m) == "still here" })
+}
+
+func TestSignalReachesEveryTab(t *testing.T) {
+	observer, tab1, tab2 := dialTabs(t, "tabs")
+	observer.sendJSON(map[string]interface{}{
+		"type": "signal",
+		"data": map[string]interface{}{"type": "offer", "to": "tabs", "payload": map[string]string{"sdp": "v=0"}},
+	})
+	for _, tab := range []*testConn{tab1, tab2} {
+		m := tab.expect("signal")
+		data, _ := m["data"].(map[string]interface{})
+		if data["from"] != "observer" || data["type"] != "offer" {
+			t.Fatalf("signal = %v", m)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 会话恢复：init 中下发 resumeToken，重连时携带（?resume=<token>）即可证明身份。
+// 若旧连接仍挂着（如笔记本休眠后的半开连接），新连接直接顶替它；
+// 若旧连接已断开但未超过 -resume-grace，新连接取回原 userId，期间不广播离线与上线，
+// 发给该用户的信令、私聊等定向消息暂存，恢复后补发。
+// 邀请、免打扰等状态按 userId 保存，恢复后自然延续
+
+var (
+	noTakeover  = flag.Bool("no-takeover", false, "禁止新连接接管同一身份的旧连接（改为分配新 userId），适合公用终端")
+	resumeGrace = flag.Duration("resume-grace", 30*time.Second, "连接断开后为其保留 userId 的时长，期间携带 resume 令牌重连不算离线；0 表示不保留")
+)
+
+// maxPendingDirect 断线保留期间每个用户最多暂存的定向消息
+const maxPendingDirect = sendQueueSize
+
+type resumeTicket struct {
+	userID string
+	conn   *websocket.Conn
+}
+
+// resumeTokens 连接在线期间有效；断开后进入保留期的，保留期结束即过期
+var resumeTokens = newExpiringMap[string, resumeTicket]("resume_tokens", 0, registryMaxEntries)
+
+// lingerer 断线后仍在保留期内的身份，由 hub 协程维护（修改时持 identityMu）
+type lingerer struct {
+	conn    *websocket.Conn
+	pending []func(*client) []byte // 保留期内收到的定向消息
+	rooms   []string               // 断线时所在的房间，恢复后沿用
+	timer   *time.Timer
+}
+
+var lingering = make(map[string]*lingerer) // userId -> 保留中的身份
+
+// graceExpiry 保留期结束，由 hub 确认该身份仍未恢复
+type graceExpiry struct {
+	userID string
+	conn   *websocket.Conn
+	reply  chan departed
+}
+
+var hubExpire = make(chan graceExpiry)
+
+// issueResumeToken 为连接签发 resume 令牌，在 hub 协程中调用
+func issueResumeToken(c *client) string {
+	if *noTakeover {
+		return ""
+	}
+	c.resumeToken = newMessageID() + newMessageID()
+	resumeTokens.SetTTL(c.resumeToken, resumeTicket{userID: c.userID, conn: c.conn}, 0)
+	return c.resumeToken
+}
+
+// resumeConn 若 token 是 userID 的 resume 令牌，返回签发它的连接（可能仍在线，也可能处于保留期），
+// 由 hub 注册时顶替或恢复；否则返回 nil。令牌使用后即作废。
+// 兼容旧客户端：上一个连接的上传令牌也可用于接管仍在线的旧连接
+func resumeConn(token, userID string) *websocket.Conn {
+	if *noTakeover || token == "" {
+		return nil
+	}
+	var r resumeTicket
+	if resumeTokens.DeleteIf(token, func(v resumeTicket) bool { r = v; return v.userID == userID }) {
+		return r.conn
+	}
+	var t uploadToken
+	if !uploadTokens.DeleteIf(token, func(v uploadToken) bool { t = v; return v.userID == userID }) {
+		return nil
+	}
+	return t.conn
+}
+
+// startGrace 连接断开后为其保留身份，调用方为 hub 协程且持有 identityMu
+func startGrace(c *client) bool {
+	if *noTakeover || *resumeGrace <= 0 || c.resumeToken == "" {
+		return false
+	}
+	userID, conn := c.userID, c.conn
+	resumeTokens.SetTTL(c.resumeToken, resumeTicket{userID: userID, conn: conn}, *resumeGrace)
+	lingering[userID] = &lingerer{conn: conn, rooms: c.roomList(), timer: time.AfterFunc(*resumeGrace, func() { expireGrace(userID, conn) })}
+	log.Printf("📴 用户 %s 断线，保留身份 %s 等待重连", userID, *resumeGrace)
+	return true
+}
+
+// expireGrace 保留期结束仍未恢复，按正常离线处理
+func expireGrace(userID string, conn *websocket.Conn) {
+	e := graceExpiry{userID: userID, conn: conn, reply: make(chan departed)}
+	hubExpire <- e
+	left := <-e.reply
+	if left.superseded {
+		return
+	}
+	announceLeave(userID, left.count)
+}
+
+// hubExpireGrace 在 hub 协程中执行；身份已被新连接恢复时返回 superseded
+func hubExpireGrace(e graceExpiry) departed {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	l := lingering[e.userID]
+	if l == nil || l.conn != e.conn {
+		return departed{superseded: true}
+	}
+	delete(lingering, e.userID)
+	userSessions.Delete(e.userID)
+	queuePresence("leave", e.userID)
+	return departed{count: clients.Users()}
+}
+
+// inheritQueue 接管时把旧连接还没写出的文本帧转给新连接，在 hub 协程中调用，此时旧连接已移出在线列表，
+// 不会再收到广播。中继数据与关闭帧属于旧连接本身，不转移
+func inheritQueue(old, c *client) {
+	for _, f := range old.queue.drain() {
+		if f.typ == websocket.TextMessage && c.enqueue(f) == nil {
+			continue
+		}
+		if f.written != nil {
+			f.written()
+		}
+	}
+}
+
+// dropSuperseded 通知并关闭被顶替的旧连接，其读循环随之退出
+func dropSuperseded(old *client) {
+	closeNow(old.conn, closeSuperseded)
+	log.Printf("🔁 用户 %s 的旧连接已被新连接接管", old.userID)
+}
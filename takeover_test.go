@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestInheritQueue(t *testing.T) {
+	old := &client{userID: "old", queue: newSendQueue(), done: make(chan struct{})}
+	c := &client{userID: "new", queue: newSendQueue(), done: make(chan struct{})}
+	released := 0
+	old.send([]byte("direct"))
+	old.sendBroadcast([]byte("broadcast"))
+	old.enqueue(frame{typ: websocket.BinaryMessage, data: []byte("chunk"), written: func() { released++ }})
+	old.enqueue(frame{typ: websocket.CloseMessage, data: closeFrame(closeIdle)})
+
+	inheritQueue(old, c)
+
+	if n := old.queue.len(); n != 0 {
+		t.Fatalf("old queue still holds %d frames", n)
+	}
+	var got []string
+	for {
+		f, ok := c.queue.next()
+		if !ok {
+			break
+		}
+		got = append(got, string(f.data))
+	}
+	if len(got) != 2 || got[0] != "direct" || got[1] != "broadcast" {
+		t.Fatalf("new queue = %q, want [direct broadcast]", got)
+	}
+	if released != 1 {
+		t.Fatalf("relay chunk written callback ran %d times, want 1", released)
+	}
+}
+
+// 多个携带同一 resume 令牌的连接同时接管：只有一个取得原身份，旧连接以 closeSuperseded 关闭
+func TestConcurrentTakeover(t *testing.T) {
+	srv := newTestServer(t, nil)
+	first := dialWS(t, srv, "uid=takeover")
+	token, _ := first.init["resumeToken"].(string)
+	if first.userID() != "takeover" || token == "" {
+		t.Fatalf("init = %v", first.init)
+	}
+
+	const n = 8
+	raw := make([]*websocket.Conn, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range raw {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			raw[i], _, errs[i] = websocket.DefaultDialer.Dial(wsURL(srv, "uid=takeover&resume="+token), nil)
+		}()
+	}
+	wg.Wait()
+
+	winners := 0
+	for i, conn := range raw {
+		if errs[i] != nil {
+			t.Fatalf("dial: %v", errs[i])
+		}
+		tc := newTestConn(t, conn)
+		if tc.userID() == "takeover" {
+			winners++
+			if tc.init["resumed"] != true {
+				t.Errorf("winner init.resumed = %v", tc.init["resumed"])
+			}
+		}
+	}
+	if winners != 1 {
+		t.Fatalf("%d connections took over the identity, want 1", winners)
+	}
+	if code, _ := first.closeOf(); code != closeSuperseded {
+		t.Fatalf("old connection closed with %d, want %d", code, closeSuperseded)
+	}
+	waitFor(t, "single registered connection", func() bool { return len(clients.ByID("takeover")) == 1 })
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// 链路追踪：配置 -otel-endpoint 后通过 OTLP/HTTP 上报，未配置时所有 span 均为空操作
+
+var (
+	otelEndpoint    = flag.String("otel-endpoint", "", "OTLP/HTTP 采集端地址，如 http://localhost:4318，留空则不启用追踪")
+	otelSampleRatio = flag.Float64("otel-sample-ratio", 1, "追踪采样比例 0~1（上游已采样的请求始终跟随）")
+)
+
+var (
+	tracer        = otel.Tracer("go-chat")
+	tracerStop    = func(context.Context) error { return nil }
+	exportErrOnce sync.Once
+)
+
+// initTracing 初始化导出器；采集端不可用时只记录一次日志，不影响服务
+func initTracing() {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if *otelEndpoint == "" {
+		return
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		exportErrOnce.Do(func() { log.Printf("⚠️  追踪数据上报失败（后续错误不再提示）: %v", err) })
+	}))
+
+	exp, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(*otelEndpoint), otlptracehttp.WithProxy(outboundProxy))
+	if err != nil {
+		log.Printf("⚠️  初始化追踪导出器失败，已禁用追踪: %v", err)
+		return
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*otelSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("gochat"), semconv.ServiceVersion(Version))),
+	)
+	otel.SetTracerProvider(tp)
+	tracer = tp.Tracer("go-chat")
+	tracerStop = tp.Shutdown
+	log.Printf("🔭 已启用链路追踪，上报到 %s（采样比例 %.2f）", *otelEndpoint, *otelSampleRatio)
+}
+
+// shutdownTracing 退出前把缓冲的 span 发出去
+func shutdownTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tracerStop(ctx)
+}
+
+// traceHTTP 为每个 HTTP 请求创建 span，并接续请求头中的 W3C traceparent
+func traceHTTP(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "http", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method + " " + spanRoute(r.URL.Path)
+	}))
+}
+
+// spanRoute 只保留路径的固定前缀，避免文件名等进入 span 名称
+func spanRoute(path string) string {
+	for _, prefix := range []string{"/api/files/all/", "/api/files/", "/api/emoji/", "/files/", "/share/icon/", "/share/", "/emoji/", "/f/"} {
+		if strings.HasPrefix(path, prefix) && len(path) > len(prefix) {
+			return prefix + "{name}"
+		}
+	}
+	return path
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// 消息转换钩子：在消息收到后、广播前调用（如翻译服务），超时或出错时原样发送
+
+var (
+	transformURL     = flag.String("transform-url", "", "消息转换服务地址，POST 消息 JSON 并返回增强后的消息（如附带 translations）")
+	transformTimeout = flag.Duration("transform-timeout", 800*time.Millisecond, "消息转换的最长等待时间")
+)
+
+type transformFunc func(ctx context.Context, m Message) (Message, error)
+
+var (
+	transformHook     transformFunc
+	transformFailures atomic.Int64
+)
+
+func initTransform() {
+	if *transformURL != "" {
+		transformHook = httpTransform(*transformURL)
+	}
+}
+
+func httpTransform(url string) transformFunc {
+	return func(ctx context.Context, m Message) (Message, error) {
+		body, _ := json.Marshal(m)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return m, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := outboundClient.Do(req)
+		if err != nil {
+			return m, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return m, fmt.Errorf("hook returned %s", resp.Status)
+		}
+		var out Message
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return m, err
+		}
+		return out, nil
+	}
+}
+
+// applyTransform 调用钩子；只采纳文本与附加字段，发送者、接收者与时间保持原样
+func applyTransform(m Message, skip bool) Message {
+	if transformHook == nil || skip {
+		return m
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *transformTimeout)
+	defer cancel()
+
+	type result struct {
+		msg Message
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := transformHook(ctx, m)
+		done <- result{out, err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = ctx.Err()
+	}
+	if res.err == nil && res.msg.Text == "" {
+		res.err = errors.New("hook returned empty text")
+	}
+	if res.err != nil {
+		transformFailures.Add(1)
+		log.Printf("消息转换失败，按原文发送: %v", res.err)
+		return m
+	}
+
+	m.Text = res.msg.Text
+	m.Translations = res.msg.Translations
+	return m
+}
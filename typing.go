@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 正在输入提示：连接发来 typing 后转发给其他所有人（不含自己），每人每秒最多转发一次；
+// 收到 typing_stop、发出聊天消息、断开连接或 5 秒内没有新的 typing 时广播 typing_stop。
+// 提示是瞬时状态，不编号、不进最近消息
+
+const typingInterval = time.Second // 同一用户两次转发的最小间隔，期间的 typing 直接丢弃
+
+// typingTimeout 超过该时长没有新的 typing 视为停止输入
+var typingTimeout = 5 * time.Second
+
+// typingState 单个连接的输入状态，由 readPump 创建并挂在 client 上；读循环与计时器回调并发访问
+type typingState struct {
+	userID string
+	mu     sync.Mutex
+	active bool
+	last   time.Time // 最近一次转发 typing 的时间
+	timer  *time.Timer
+}
+
+func newTypingState(userID string) *typingState {
+	return &typingState{userID: userID}
+}
+
+// typing 收到一次 typing：重置静默计时，距上次转发不足 typingInterval 时不转发
+func (t *typingState) typing() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer == nil {
+		t.timer = time.AfterFunc(typingTimeout, t.stop)
+	} else {
+		t.timer.Reset(typingTimeout)
+	}
+	t.active = true
+	if time.Since(t.last) < typingInterval {
+		return
+	}
+	t.last = time.Now()
+	broadcastTyping("typing", t.userID)
+}
+
+// stop 结束输入状态；本来就未在输入时不广播
+func (t *typingState) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if !t.active {
+		return
+	}
+	t.active = false
+	// 停止后重新开始输入应立即提示
+	t.last = time.Time{}
+	broadcastTyping("typing_stop", t.userID)
+}
+
+func init() {
+	registerWSType(wsType{name: "typing", minProto: 2, feature: "typing", handle: func(f *wsFrame) { f.c.typing.typing() }})
+	registerWSType(wsType{name: "typing_stop", minProto: 2, handle: func(f *wsFrame) { f.c.typing.stop() }})
+}
+
+// broadcastTyping 发给除 from 以外认识该类消息的连接
+func broadcastTyping(typ, from string) {
+	frame := mustMarshal(map[string]interface{}{
+		"type": typ,
+		"data": map[string]string{"from": from},
+	})
+	hubOutbound <- outbound{ctx: context.Background(), msg: WSMessage{Type: typ, Category: catPresence}, encode: func(c *client) []byte {
+		if c.userID == from || !c.supports(typ) {
+			return nil
+		}
+		return frame
+	}}
+}
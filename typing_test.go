@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// nextTyping 跳过其他帧，返回下一帧 typing 或 typing_stop 的类型与 from
+func nextTyping(tc *testConn) (string, string) {
+	tc.t.Helper()
+	for {
+		m, err := tc.next()
+		if err != nil {
+			tc.t.Fatalf("waiting for typing: %v", err)
+		}
+		if m["type"] == "typing" || m["type"] == "typing_stop" {
+			data, _ := m["data"].(map[string]interface{})
+			from, _ := data["from"].(string)
+			return m["type"].(string), from
+		}
+	}
+}
+
+// 两个客户端：typing 转发给对方而不回给自己，一秒内的重复 typing 丢弃，
+// typing_stop 与静默超时都会广播 typing_stop
+func TestTyping(t *testing.T) {
+	setFlag(t, &typingTimeout, 200*time.Millisecond)
+	srv := newTestServer(t, nil)
+	alice := dialWS(t, srv, "uid=alice")
+	bob := dialWS(t, srv, "uid=bob")
+
+	alice.sendJSON(map[string]interface{}{"type": "typing", "data": map[string]string{}})
+	if typ, from := nextTyping(bob); typ != "typing" || from != "alice" {
+		t.Fatalf("bob got %s from %q, want typing from alice", typ, from)
+	}
+	alice.sendJSON(map[string]interface{}{"type": "typing", "data": map[string]string{}})
+	alice.sendJSON(map[string]interface{}{"type": "typing_stop", "data": map[string]string{}})
+	if typ, _ := nextTyping(bob); typ != "typing_stop" {
+		t.Fatalf("bob got %s, want the repeated typing dropped and then typing_stop", typ)
+	}
+
+	// 停止后重新输入立即提示，静默超时后自动停止
+	alice.sendJSON(map[string]interface{}{"type": "typing", "data": map[string]string{}})
+	if typ, _ := nextTyping(bob); typ != "typing" {
+		t.Fatalf("bob got %s, want typing after a stop", typ)
+	}
+	start := time.Now()
+	if typ, from := nextTyping(bob); typ != "typing_stop" || from != "alice" {
+		t.Fatalf("bob got %s from %q, want typing_stop from alice", typ, from)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("typing_stop after %v, timeout is %v", waited, typingTimeout)
+	}
+
+	// 发送者自己收不到任何输入提示
+	alice.sendJSON(map[string]interface{}{"type": "message", "data": map[string]string{"text": "done"}})
+	for {
+		m, err := alice.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m["type"] == "typing" || m["type"] == "typing_stop" {
+			t.Fatalf("alice received her own %s", m["type"])
+		}
+		if chatText(m) == "done" {
+			break
+		}
+	}
+}
@@ -0,0 +1,168 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 平滑升级：收到 SIGUSR2 或管理接口请求后，启动新的可执行文件并把监听 socket 交给它，
+// 新进程就绪后旧进程停止接收、等待上传完成、通知客户端重连后退出。
+//
+// 继承的文件描述符：3 = 监听 socket，4 = 旧进程存活管道（EOF 即旧进程已退出），5 = 就绪通知管道，
+// 6 = 实例锁文件（见 instancelock.go）
+
+const (
+	listenFDEnv  = "GOCHAT_LISTEN_FD"
+	readyTimeout = 10 * time.Second
+)
+
+var (
+	upgrading   atomic.Bool
+	listener    net.Listener
+	readyPipe   *os.File // 作为新进程时，就绪后写入一个字节
+	parentAlive *os.File // 作为新进程时，读到 EOF 说明旧进程已退出
+	// 作为旧进程时持有存活管道写端直到退出；必须保持引用，否则会被 GC 提前关闭
+	successorAlive *os.File
+)
+
+// listen 若由旧进程交接则复用继承的 socket，否则新建监听
+func listen(addr string) (net.Listener, error) {
+	if os.Getenv(listenFDEnv) == "" {
+		ln, err := net.Listen("tcp", addr)
+		listener = ln
+		return ln, err
+	}
+	os.Unsetenv(listenFDEnv)
+	ln, err := net.FileListener(os.NewFile(3, "listener"))
+	if err != nil {
+		return nil, fmt.Errorf("继承监听 socket 失败: %w", err)
+	}
+	listener = ln
+	parentAlive = os.NewFile(4, "parent-alive")
+	readyPipe = os.NewFile(5, "ready")
+	// 旧进程退出前仍可能写索引，期间本进程暂不落盘
+	holdIndex()
+	return ln, nil
+}
+
+// notifyReady 作为新进程开始接收连接后通知旧进程，并在旧进程退出后接管索引
+func notifyReady() {
+	if readyPipe == nil {
+		return
+	}
+	readyPipe.Write([]byte{1})
+	readyPipe.Close()
+	go func() {
+		io.Copy(io.Discard, parentAlive)
+		parentAlive.Close()
+		adoptIndex()
+		log.Printf("🔁 旧进程已退出，已接管文件索引")
+	}()
+}
+
+// watchUpgradeSignal SIGUSR2 触发平滑升级
+func watchUpgradeSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+	go func() {
+		for range ch {
+			if err := startUpgrade(); err != nil {
+				log.Printf("❌ 平滑升级失败: %v", err)
+			}
+		}
+	}()
+}
+
+// startUpgrade 启动新进程并等待其就绪，成功后在后台排空本进程并退出
+func startUpgrade() error {
+	if !upgrading.CompareAndSwap(false, true) {
+		return errors.New("upgrade already in progress")
+	}
+	if err := spawnSuccessor(); err != nil {
+		upgrading.Store(false)
+		return err
+	}
+	go func() {
+		log.Printf("🔁 新进程已就绪，停止接收新连接并等待进行中的请求完成")
+		drainAndStop("server upgrading, please reconnect")
+		log.Printf("👋 旧进程退出")
+		os.Exit(0)
+	}()
+	return nil
+}
+
+func spawnSuccessor() error {
+	tl, ok := listener.(*net.TCPListener)
+	if !ok {
+		return errors.New("listener is not a TCP listener")
+	}
+	lnFile, err := tl.File()
+	if err != nil {
+		return err
+	}
+	defer lnFile.Close()
+
+	// 存活管道的写端留在本进程，进程退出时由内核关闭
+	aliveR, aliveW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer aliveR.Close()
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		aliveW.Close()
+		return err
+	}
+	defer readyR.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		aliveW.Close()
+		readyW.Close()
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), listenFDEnv+"=3", lockFDEnv+"=6")
+	cmd.ExtraFiles = []*os.File{lnFile, aliveR, readyW, instanceLock}
+	if err := cmd.Start(); err != nil {
+		aliveW.Close()
+		readyW.Close()
+		return err
+	}
+	readyW.Close()
+
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := readyR.Read(b)
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(readyTimeout):
+		err = errors.New("timed out waiting for the new process")
+	}
+	if err != nil {
+		// 新进程启动失败，继续由本进程服务
+		cmd.Process.Kill()
+		cmd.Wait()
+		aliveW.Close()
+		return fmt.Errorf("new process not ready: %w", err)
+	}
+	successorAlive = aliveW
+	go cmd.Wait()
+	log.Printf("🔁 已启动新进程 pid=%d", cmd.Process.Pid)
+	return nil
+}
@@ -0,0 +1,132 @@
+//go:build !windows
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 平滑升级交接：测试进程扮演旧进程，以 fd 3/4/5 把监听 socket 与两根管道交给重新执行的测试二进制。
+// 新进程就绪后暂不落盘；旧进程在交接期间完成一次上传、删掉一个文件后退出，新进程接管时应合并这些变化
+
+const successorEnv = "GOCHAT_TEST_SUCCESSOR"
+
+func TestUpgradeAdoptsIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeTestIndex(t, dir, "kept.txt", "gone.txt")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnFile, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	aliveR, aliveW, _ := os.Pipe()
+	readyR, readyW, _ := os.Pipe()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestUpgradeSuccessor$")
+	cmd.Env = append(os.Environ(), successorEnv+"="+dir, listenFDEnv+"=3")
+	cmd.ExtraFiles = []*os.File{lnFile, aliveR, readyW}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	lnFile.Close()
+	aliveR.Close()
+	readyW.Close()
+
+	readyR.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := readyR.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("successor never became ready: %v", err)
+	}
+
+	// 新进程经继承的 socket 服务，文件列表来自旧进程写下的索引
+	if got := listedFiles(t, addr); len(got) != 2 {
+		t.Fatalf("before adoption: %v, want kept.txt and gone.txt", got)
+	}
+
+	// 旧进程在交接期间完成上传、删除文件，并写下最后的索引，然后退出
+	os.Remove(filepath.Join(dir, "gone.txt"))
+	writeTestIndex(t, dir, "kept.txt", "late.txt")
+	aliveW.Close()
+
+	waitFor(t, "the successor to adopt the index", func() bool {
+		got := listedFiles(t, addr)
+		return len(got) == 2 && got["kept.txt"] && got["late.txt"]
+	})
+	// 接管后恢复落盘，索引文件反映合并结果
+	waitFor(t, "the adopted index to be saved", func() bool {
+		data, _ := os.ReadFile(filepath.Join(dir, indexFileName))
+		var idx indexData
+		json.Unmarshal(data, &idx)
+		_, late := idx.Files["late.txt"]
+		_, gone := idx.Files["gone.txt"]
+		return late && !gone
+	})
+}
+
+// TestUpgradeSuccessor 仅作为 TestUpgradeAdoptsIndex 启动的新进程运行
+func TestUpgradeSuccessor(t *testing.T) {
+	dir := os.Getenv(successorEnv)
+	if dir == "" {
+		t.Skip("only runs as the successor process")
+	}
+	*uploadDir = dir
+	loadIndex()
+	ln, err := listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/files", listFilesHandler)
+	go http.Serve(ln, mux)
+	notifyReady()
+	select {}
+}
+
+// writeTestIndex 在 dir 下创建文件并写入只含这些文件的索引
+func writeTestIndex(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	idx := indexData{Files: make(map[string]FileInfo)}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			os.WriteFile(path, []byte(name), 0644)
+		}
+		idx.Files[name] = FileInfo{Name: name, SavedName: name, Size: int64(len(name)), Uploaded: time.Now()}
+	}
+	data, _ := json.Marshal(idx)
+	if err := os.WriteFile(filepath.Join(dir, indexFileName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listedFiles(t *testing.T, addr string) map[string]bool {
+	t.Helper()
+	resp, err := http.Get("http://" + addr + "/api/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var list []FileInfo
+	json.NewDecoder(resp.Body).Decode(&list)
+	got := make(map[string]bool)
+	for _, f := range list {
+		got[f.Name] = true
+	}
+	return got
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// Windows 不支持继承监听 socket，平滑升级不可用
+
+func listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func notifyReady() {}
+
+func watchUpgradeSignal() {}
+
+func startUpgrade() error {
+	return errors.New("graceful upgrade is not supported on Windows")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 上传令牌：把 /upload 与 WebSocket 身份绑定，用于记录文件归属
+
+const uploadTokenTTL = 10 * time.Minute
+
+var allowAnonymousUploads = flag.Bool("allow-anonymous-uploads", true, "允许不带上传令牌的匿名上传（如 curl）")
+
+type uploadToken struct {
+	userID string
+	conn   *websocket.Conn
+}
+
+var uploadTokens = newExpiringMap[string, uploadToken]("upload_tokens", uploadTokenTTL, registryMaxEntries)
+
+// issueUploadToken 为连接签发新令牌，uploadTokenTTL 后过期
+func issueUploadToken(conn *websocket.Conn, userID string) map[string]interface{} {
+	token := newMessageID() + newMessageID()
+	uploadTokens.Set(token, uploadToken{userID: userID, conn: conn})
+	return map[string]interface{}{"token": token, "expiresIn": int(uploadTokenTTL.Seconds())}
+}
+
+// revokeUploadTokens 连接断开时作废其全部令牌
+func revokeUploadTokens(conn *websocket.Conn) {
+	uploadTokens.DeleteFunc(func(_ string, t uploadToken) bool { return t.conn == conn })
+}
+
+// requestOwner 解析 X-Upload-Token 对应的用户；令牌必须未过期且其连接仍在线。
+// 无令牌时返回空字符串，是否放行由 -allow-anonymous-uploads 决定；
+// ok 为 false 时已写出错误响应。
+func requestOwner(w http.ResponseWriter, r *http.Request) (owner string, ok bool) {
+	token := r.Header.Get("X-Upload-Token")
+	if token == "" {
+		if !*allowAnonymousUploads {
+			writeError(w, r, http.StatusUnauthorized, "upload_token_required", "Upload token required", nil)
+			return "", false
+		}
+		return "", true
+	}
+
+	owner = tokenUser(r)
+	if owner == "" {
+		writeError(w, r, http.StatusUnauthorized, "upload_token_invalid", "Invalid or expired upload token", nil)
+		return "", false
+	}
+	return owner, true
+}
+
+// tokenUser X-Upload-Token 对应的在线用户；没有令牌、令牌无效或其连接已断开时返回空字符串
+func tokenUser(r *http.Request) string {
+	t, found := uploadTokens.Get(r.Header.Get("X-Upload-Token"))
+	if !found || clients.ByConn(t.conn) == nil {
+		return ""
+	}
+	return t.userID
+}
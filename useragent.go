@@ -0,0 +1,55 @@
+package main
+
+import "strings"
+
+// classifyUserAgent 把 User-Agent 粗略归类为“平台 · 浏览器”，无法识别时返回 unknown
+func classifyUserAgent(ua string) string {
+	if ua == "" {
+		return "unknown"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		platform = "iOS"
+	case strings.Contains(ua, "CrOS"):
+		platform = "ChromeOS"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Macintosh"), strings.Contains(ua, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+
+	// 顺序有讲究：Edge/Opera 的 UA 同时包含 Chrome，Chrome 的 UA 同时包含 Safari
+	browser := ""
+	switch {
+	case strings.Contains(ua, "Edg/"), strings.Contains(ua, "EdgA/"), strings.Contains(ua, "EdgiOS/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"), strings.Contains(ua, "Opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"), strings.Contains(ua, "FxiOS/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"), strings.Contains(ua, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(ua, "Go-http-client"):
+		browser = "Go"
+	case strings.HasPrefix(ua, "curl/"):
+		browser = "curl"
+	}
+
+	switch {
+	case platform != "" && browser != "":
+		return platform + " · " + browser
+	case platform != "":
+		return platform
+	case browser != "":
+		return browser
+	}
+	return "unknown"
+}
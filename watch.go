@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// gochat watch：监视目录，新文件写完后自动上传并在群里发出链接
+
+const (
+	watchSentDir    = "sent"
+	watchMaxBackoff = 5 * time.Minute
+	watchMinSettle  = 2 * time.Millisecond // 检查间隔为 settle 的一半，不能为 0
+)
+
+// watchItem 记录一个待上传文件的最近状态
+type watchItem struct {
+	size      int64
+	modTime   time.Time
+	seen      time.Time // 最近一次观察到大小/时间变化的时刻
+	retryAt   time.Time
+	backoff   time.Duration
+	result    *uploadResult // 已上传但后续步骤失败时保留结果，重试时不再重复上传
+	announced bool
+}
+
+type watcher struct {
+	server   string
+	dir      string
+	from     string
+	settle   time.Duration
+	moveSent bool
+
+	pending map[string]*watchItem
+	done    map[string]time.Time // 已上传文件 -> 上传时的修改时间，未移走时避免重复处理
+}
+
+func runWatch(args []string) int {
+	set := flag.NewFlagSet("watch", flag.ExitOnError)
+	server := set.String("server", "http://127.0.0.1:3027", "服务端地址")
+	dir := set.String("dir", ".", "监视的目录")
+	from := set.String("from", "watch", "在聊天中发布链接时使用的发送者名称")
+	settle := set.Duration("settle", 2*time.Second, "文件停止增长多久后视为写入完成")
+	moveSent := set.Bool("move-sent", false, "上传成功后把文件移动到 sent/ 子目录")
+	set.Parse(args)
+	if *settle < watchMinSettle {
+		fmt.Fprintf(os.Stderr, "❌ -settle 不能小于 %v\n", watchMinSettle)
+		return 2
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 无法创建文件监视器: %v\n", err)
+		return 1
+	}
+	defer fw.Close()
+	if err := fw.Add(*dir); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 无法监视目录 %s: %v\n", *dir, err)
+		return 1
+	}
+
+	w := &watcher{
+		server:   strings.TrimRight(*server, "/"),
+		dir:      *dir,
+		from:     *from,
+		settle:   *settle,
+		moveSent: *moveSent,
+		pending:  make(map[string]*watchItem),
+		done:     make(map[string]time.Time),
+	}
+
+	// 启动前已在目录中的文件同样处理，重复内容由服务端去重
+	if entries, err := os.ReadDir(*dir); err == nil {
+		for _, e := range entries {
+			w.touch(e.Name())
+		}
+	}
+
+	fmt.Printf("👀 正在监视 %s，新文件将上传到 %s\n", *dir, w.server)
+	tick := time.NewTicker(w.settle / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case ev, ok := <-fw.Events:
+			if !ok {
+				return 0
+			}
+			if ev.Has(fsnotify.Create) || ev.Has(fsnotify.Write) {
+				w.touch(filepath.Base(ev.Name))
+			}
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "⚠️  监视出错: %v\n", err)
+		case <-tick.C:
+			w.flush()
+		}
+	}
+}
+
+// touch 记录文件的最新大小；隐藏文件、目录以及未变化的已上传文件忽略
+func (w *watcher) touch(name string) {
+	if strings.HasPrefix(name, ".") {
+		return
+	}
+	st, err := os.Stat(filepath.Join(w.dir, name))
+	if err != nil || !st.Mode().IsRegular() {
+		return
+	}
+	if t, ok := w.done[name]; ok && t.Equal(st.ModTime()) {
+		return
+	}
+	it := w.pending[name]
+	if it == nil {
+		it = &watchItem{}
+		w.pending[name] = it
+	}
+	if it.size != st.Size() || !it.modTime.Equal(st.ModTime()) {
+		it.size, it.modTime, it.seen = st.Size(), st.ModTime(), time.Now()
+		it.result, it.announced = nil, false
+	}
+}
+
+// flush 上传已稳定的文件，失败的按指数退避稍后重试
+func (w *watcher) flush() {
+	now := time.Now()
+	for name, it := range w.pending {
+		w.touch(name)
+		if _, err := os.Stat(filepath.Join(w.dir, name)); err != nil {
+			delete(w.pending, name)
+			continue
+		}
+		if now.Sub(it.seen) < w.settle || now.Before(it.retryAt) {
+			continue
+		}
+
+		if err := w.send(name, it); err != nil {
+			if it.backoff == 0 {
+				it.backoff = time.Second
+			} else if it.backoff *= 2; it.backoff > watchMaxBackoff {
+				it.backoff = watchMaxBackoff
+			}
+			it.retryAt = now.Add(it.backoff)
+			fmt.Fprintf(os.Stderr, "❌ 上传 %s 失败，%v 后重试: %v\n", name, it.backoff, err)
+			continue
+		}
+		delete(w.pending, name)
+		if !w.moveSent {
+			w.done[name] = it.modTime
+		}
+	}
+}
+
+func (w *watcher) send(name string, it *watchItem) error {
+	if it.result == nil {
+		res, err := uploadFile(w.server, filepath.Join(w.dir, name))
+		if err != nil {
+			return err
+		}
+		it.result = &res
+	}
+	res := *it.result
+
+	switch {
+	case res.Duplicate:
+		fmt.Printf("♻️  %s 与已有文件内容相同，不再重复发布: %s\n", name, res.FileURL)
+	case !it.announced:
+		// 与网页端相同的文件消息格式，前端会渲染成链接或图片
+		text, _ := json.Marshal(map[string]interface{}{
+			"type":     "file",
+			"url":      res.FileURL,
+			"shareUrl": res.ShareURL,
+			"name":     name,
+			"size":     res.FileSize,
+		})
+		if err := postMessage(w.server, w.from, string(text)); err != nil {
+			return fmt.Errorf("announce: %w", err)
+		}
+		it.announced = true
+		fmt.Printf("⬆️  已上传并发布 %s: %s\n", name, res.FileURL)
+	}
+
+	if w.moveSent {
+		return moveToSent(w.dir, name)
+	}
+	return nil
+}
+
+// moveToSent 移动到 sent/ 子目录，重名时追加时间戳
+func moveToSent(dir, name string) error {
+	sent := filepath.Join(dir, watchSentDir)
+	if err := os.MkdirAll(sent, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(sent, name)
+	if _, err := os.Stat(dst); err == nil {
+		ext := filepath.Ext(name)
+		dst = filepath.Join(sent, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), time.Now().Unix(), ext))
+	}
+	return os.Rename(filepath.Join(dir, name), dst)
+}
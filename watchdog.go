@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// 写协程看门狗：每次写帧（含 ping）前后记录进度，单次写超过 -write-stall-timeout 仍未返回的连接强制关闭。
+// 正常情况下写超时（-write-timeout）会先让写失败；看门狗兜底对端消失且写超时未能生效的情况，
+// 避免卡住的连接长期占着在线列表。强制关闭次数按用户累计，见 /metrics 与 /api/admin/connections
+
+var writeStallTimeout = flag.Duration("write-stall-timeout", 30*time.Second, "单次 WebSocket 写阻塞超过该时长即强制断开连接，通常应大于 -write-timeout；0 表示关闭看门狗")
+
+var (
+	forcedCloses     atomic.Int64                                                            // 看门狗强制关闭的连接总数
+	userForcedCloses = newExpiringMap[string, int64]("forced_closes", 0, registryMaxEntries) // userId -> 本次运行中被强制关闭的次数
+)
+
+// writing 标记一次写开始，返回的函数在写结束时调用
+func (c *client) writing() func() {
+	c.writeStarted.Store(time.Now().UnixNano())
+	return func() { c.writeStarted.Store(0) }
+}
+
+// writeBlocked 当前这次写已阻塞的时长，没有在写时为 0
+func (c *client) writeBlocked(now time.Time) time.Duration {
+	started := c.writeStarted.Load()
+	if started == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, started))
+}
+
+// startWriteWatchdog 定期检查所有连接的写协程
+func startWriteWatchdog() {
+	if *writeStallTimeout <= 0 {
+		return
+	}
+	interval := max(min(*writeStallTimeout/4, 5*time.Second), 100*time.Millisecond)
+	go func() {
+		for range time.Tick(interval) {
+			killStalledWriters()
+		}
+	}()
+}
+
+func killStalledWriters() {
+	now := time.Now()
+	var stalled []*client
+	for _, c := range clients.Conns() {
+		if c.writeBlocked(now) > *writeStallTimeout {
+			stalled = append(stalled, c)
+		}
+	}
+
+	for _, c := range stalled {
+		log.Printf("🐕 用户 %s 的写操作已阻塞 %s，强制断开连接", c.userID, c.writeBlocked(now).Round(time.Second))
+		forcedCloses.Add(1)
+		userForcedCloses.Update(c.userID, func(n int64, _ bool) int64 { return n + 1 })
+		// 关闭底层连接会让阻塞的写立即返回错误，读循环随之退出并按正常离线清理
+		c.kill()
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 对端从不读取：写超时设得很长，看门狗在写阻塞超过 -write-stall-timeout 后强制关闭连接并计数
+func TestWatchdogKillsStalledWriter(t *testing.T) {
+	setFlag(t, writeTimeout, time.Minute)
+	setFlag(t, writeStallTimeout, 300*time.Millisecond)
+	srv := newSlowReaderServer(t)
+	dialStuck(t, srv, "stalled")
+	var c *client
+	for _, cl := range clients.ByID("stalled") {
+		c = cl
+	}
+
+	padding := strings.Repeat("x", 16<<10)
+	for i := 0; c.writeBlocked(time.Now()) == 0; i++ {
+		if i == 64 {
+			t.Fatal("writes to the stalled connection never blocked")
+		}
+		resp, err := http.Post(srv.URL+"/send", "application/json", strings.NewReader(`{"message":"`+padding+`","from":"flooder"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		time.Sleep(5 * time.Millisecond)
+	}
+	now := time.Now()
+	blockedSince := now.Add(-c.writeBlocked(now))
+
+	before := forcedCloses.Load()
+	userBefore, _ := userForcedCloses.Get("stalled")
+	for forcedCloses.Load() == before {
+		if time.Since(blockedSince) > 2*time.Second {
+			t.Fatal("watchdog never fired")
+		}
+		killStalledWriters()
+		time.Sleep(20 * time.Millisecond)
+	}
+	if fired := time.Since(blockedSince); fired < *writeStallTimeout || fired > *writeStallTimeout+200*time.Millisecond {
+		t.Fatalf("watchdog fired %v after the write blocked, stall timeout %v", fired, *writeStallTimeout)
+	}
+	if n, _ := userForcedCloses.Get("stalled"); n != userBefore+1 {
+		t.Fatalf("forced closes for stalled = %d, want %d", n, userBefore+1)
+	}
+	waitFor(t, "stalled to go offline", func() bool { return !clients.Online("stalled") })
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 每个连接一个写协程：gorilla/websocket 不允许并发写同一连接，
+// 广播、信令转发、init 等所有文本帧都经 send 排队，由 writePump 依次写出；
+// 服务端主动关闭时关闭帧也排在队尾，保证客户端先收到此前的消息。
+// 队列有上限，对端读得太慢而排满时按 -slow-client-policy 处理：
+// drop 丢弃队列中最早的广播帧（定向消息与关闭帧不丢，全是这类帧时断开），
+// disconnect 直接以关闭码 closeSlowConsumer 断开，由客户端重连后补齐
+
+const sendQueueSize = 256 // 每个连接待发送帧的上限
+
+const (
+	SlowClientDrop       = "drop"
+	SlowClientDisconnect = "disconnect"
+)
+
+var (
+	writeTimeout     = flag.Duration("write-timeout", 10*time.Second, "WebSocket 单帧写超时，超时的连接视为失效并断开")
+	slowClientPolicy = flag.String("slow-client-policy", SlowClientDrop, "连接发送队列排满时的处理：drop（丢弃最早的广播帧）| disconnect（以关闭码 4005 断开）")
+)
+
+func validSlowClientPolicy(s string) bool {
+	return s == SlowClientDrop || s == SlowClientDisconnect
+}
+
+// frame 发送队列中的一帧
+type frame struct {
+	typ       int // websocket.TextMessage、BinaryMessage（中继数据，见 relay.go）或 CloseMessage
+	data      []byte
+	droppable bool                       // 广播帧，队列满时可丢弃
+	prepared  *websocket.PreparedMessage // 广播帧预先生成的消息，各连接共享压缩结果，见 fanout.go
+	written   func()                     // 写出（无论成败）后调用，可为 nil
+}
+
+var (
+	errConnClosed    = errors.New("connection closed")
+	errSendQueueFull = errors.New("send queue full")
+)
+
+var (
+	droppedFrames   atomic.Int64 // 因队列满丢弃的帧，供 /info
+	slowDisconnects atomic.Int64 // 因队列满断开的连接
+)
+
+// sendQueue 连接的发送队列，enqueue 可由任意协程调用，只有 writePump 取帧
+type sendQueue struct {
+	mu     sync.Mutex
+	frames []frame
+	wake   chan struct{} // 有新帧时非阻塞地通知 writePump
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{wake: make(chan struct{}, 1)}
+}
+
+// next 取出最早的一帧
+func (q *sendQueue) next() (frame, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.frames) == 0 {
+		return frame{}, false
+	}
+	f := q.frames[0]
+	q.frames[0] = frame{}
+	q.frames = q.frames[1:]
+	return f, true
+}
+
+// len 当前排队的帧数
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames)
+}
+
+// drain 取出全部排队的帧并清空队列，之后 writePump 不会再写出它们
+func (q *sendQueue) drain() []frame {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	frames := q.frames
+	q.frames = nil
+	return frames
+}
+
+// evictDroppable 丢弃最早的一个广播帧，调用方持有 mu
+func (q *sendQueue) evictDroppable() bool {
+	for i, f := range q.frames {
+		if f.droppable {
+			q.frames = append(q.frames[:i], q.frames[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// send 把一帧定向消息放入连接的发送队列，从不阻塞：连接已结束时返回 errConnClosed，
+// 队列已满时按 -slow-client-policy 腾出位置或断开连接
+func (c *client) send(data []byte) error {
+	return c.enqueue(frame{typ: websocket.TextMessage, data: data})
+}
+
+// sendBroadcast 同 send，但队列满时该帧可被丢弃
+func (c *client) sendBroadcast(data []byte) error {
+	return c.enqueue(frame{typ: websocket.TextMessage, data: data, droppable: true})
+}
+
+// sendPrepared 同 sendBroadcast，写出时使用预先生成的 PreparedMessage
+func (c *client) sendPrepared(data []byte, pm *websocket.PreparedMessage) error {
+	return c.enqueue(frame{typ: websocket.TextMessage, data: data, droppable: true, prepared: pm})
+}
+
+// sendClose 在队尾排一个关闭帧；队列已满时直接发送
+func (c *client) sendClose(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if c.enqueue(frame{typ: websocket.CloseMessage, data: msg}) == errSendQueueFull {
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(*writeTimeout))
+	}
+}
+
+func (c *client) enqueue(f frame) error {
+	select {
+	case <-c.done:
+		return errConnClosed
+	default:
+	}
+	q := c.queue
+	q.mu.Lock()
+	if len(q.frames) >= sendQueueSize {
+		switch {
+		case f.typ == websocket.CloseMessage:
+			q.mu.Unlock()
+			return errSendQueueFull
+		case *slowClientPolicy == SlowClientDrop && q.evictDroppable():
+			c.countDrop()
+		case *slowClientPolicy == SlowClientDrop && f.droppable:
+			q.mu.Unlock()
+			c.countDrop()
+			return errSendQueueFull
+		default:
+			q.mu.Unlock()
+			c.disconnectSlow()
+			return errConnClosed
+		}
+	}
+	q.frames = append(q.frames, f)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *client) countDrop() {
+	c.dropped.Add(1)
+	droppedFrames.Add(1)
+}
+
+// disconnectSlow 以 closeSlowConsumer 断开接收过慢的连接，只执行一次；
+// 调用方随即把连接移出在线列表，关闭帧在后台发送，不阻塞 hub
+func (c *client) disconnectSlow() {
+	c.slowOnce.Do(func() {
+		slowDisconnects.Add(1)
+		log.Printf("🐢 用户 %s 接收过慢，发送队列已满（%d 帧），断开连接", c.userID, sendQueueSize)
+		go func() {
+			c.conn.WriteControl(websocket.CloseMessage, closeFrame(closeSlowConsumer), time.Now().Add(time.Second))
+			c.kill()
+		}()
+	})
+}
+
+// kill 标记连接失效并关闭底层连接，可重复调用：之后的 send 都返回 errConnClosed，
+// hub 在下一次投递时把它移出在线列表，读循环随之退出并广播一次离线
+func (c *client) kill() {
+	c.killOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// writePump 串行写出发送队列并定时 ping（见 keepalive.go），写失败时 kill 连接
+func (c *client) writePump() {
+	tick, stop := pingTicker()
+	defer stop()
+	defer c.flushBandwidth()
+	for {
+		select {
+		case <-tick:
+			done := c.writing()
+			err := c.ping()
+			done()
+			if err != nil {
+				log.Printf("发送心跳失败 (%s): %v", c.userID, err)
+				c.kill()
+				return
+			}
+		case <-c.queue.wake:
+			for {
+				f, ok := c.queue.next()
+				if !ok {
+					break
+				}
+				done := c.writing()
+				c.conn.SetWriteDeadline(time.Now().Add(*writeTimeout))
+				err := c.writeCompressed(f)
+				done()
+				if f.written != nil {
+					f.written()
+				}
+				if err != nil {
+					log.Printf("发送失败 (%s): %v", c.userID, err)
+					c.kill()
+					return
+				}
+				c.sent(len(f.data))
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
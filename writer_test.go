@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 在 -race 下运行：/send 持续广播的同时不断有连接加入和断开，写入必须只经各自的 writePump
+func TestBroadcastSpamRace(t *testing.T) {
+	srv := newTestServer(t, map[string]http.HandlerFunc{"/send": sendHandler})
+	listener := dialWS(t, srv, "uid=listener")
+
+	const senders, perSender, churners, churns = 4, 25, 4, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, senders*perSender+churners*churns)
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perSender; i++ {
+				body := fmt.Sprintf(`{"message":"spam %d-%d","from":"spammer%d"}`, s, i, s)
+				resp, err := http.Post(srv.URL+"/send", "application/json", strings.NewReader(body))
+				if err != nil {
+					errs <- err
+					continue
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					errs <- fmt.Errorf("/send: %s", resp.Status)
+				}
+			}
+		}()
+	}
+	for c := 0; c < churners; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < churns; i++ {
+				conn, _, err := websocket.DefaultDialer.Dial(wsURL(srv, fmt.Sprintf("uid=churn%d", c)), nil)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				conn.ReadMessage() // init
+				conn.Close()
+			}
+		}()
+	}
+
+	seen := make(map[string]bool)
+	for len(seen) < senders*perSender {
+		m, err := listener.next()
+		if err != nil {
+			t.Fatalf("listener got %d of %d messages: %v", len(seen), senders*perSender, err)
+		}
+		if text := chatText(m); strings.HasPrefix(text, "spam ") {
+			seen[text] = true
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// dialStuck 连接后读完 init 就再也不读；接收缓冲设得很小，服务端的写很快被阻塞
+func dialStuck(t *testing.T, srv *httptest.Server, uid string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if tc, ok := conn.(*net.TCPConn); ok {
+			tc.SetReadBuffer(4 << 10)
+		}
+		return conn, err
+	}}
+	conn, _, err := dialer.Dial(wsURL(srv, "uid="+uid), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// smallBufferListener 把接受的连接的发送缓冲设得很小，不读的对端很快就让写阻塞
+type smallBufferListener struct{ net.Listener }
+
+func (l smallBufferListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetWriteBuffer(4 << 10)
+	}
+	return conn, err
+}
+
+func newSlowReaderServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewUnstartedServer(testMux(map[string]http.HandlerFunc{"/send": sendHandler}))
+	srv.Listener = smallBufferListener{srv.Listener}
+	startTestServer(t, srv)
+	return srv
+}
+
+// floodBroadcasts 经 /send 广播 n 条 16 KB 的消息，观察者应在 within 内收到每一条
+func floodBroadcasts(t *testing.T, srv *httptest.Server, observer *testConn, n int, within time.Duration) {
+	t.Helper()
+	padding := strings.Repeat("x", 16<<10)
+	for i := range n {
+		text := fmt.Sprintf("flood-%d %s", i, padding)
+		sent := time.Now()
+		resp, err := http.Post(srv.URL+"/send", "application/json", strings.NewReader(`{"message":"`+text+`","from":"flooder"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		observer.expectWhere("message", func(m map[string]interface{}) bool { return chatText(m) == text })
+		if d := time.Since(sent); d > within {
+			t.Fatalf("observer got broadcast %d after %v", i, d)
+		}
+	}
+}
+
+// drop：不读的连接排满后丢弃最早的广播帧，仍保持在线；其他连接照常及时收到广播
+func TestSlowReaderDrop(t *testing.T) {
+	setFlag(t, slowClientPolicy, SlowClientDrop)
+	srv := newSlowReaderServer(t)
+	observer := dialWS(t, srv, "uid=observer")
+	dialStuck(t, srv, "stuck")
+	var stuck *client
+	for _, c := range clients.ByID("stuck") {
+		stuck = c
+	}
+
+	droppedBefore := droppedFrames.Load()
+	floodBroadcasts(t, srv, observer, sendQueueSize+64, 500*time.Millisecond)
+	if stuck.dropped.Load() == 0 || droppedFrames.Load() == droppedBefore {
+		t.Fatalf("stuck connection dropped %d frames, want some", stuck.dropped.Load())
+	}
+	if !clients.Online("stuck") {
+		t.Fatal("stuck connection disconnected under the drop policy")
+	}
+}
+
+// disconnect：不读的连接排满后被断开，关闭码为 closeSlowConsumer；其他连接照常及时收到广播
+func TestSlowReaderDisconnect(t *testing.T) {
+	setFlag(t, slowClientPolicy, SlowClientDisconnect)
+	srv := newSlowReaderServer(t)
+	observer := dialWS(t, srv, "uid=observer")
+	dialStuck(t, srv, "stuck")
+
+	slowBefore := slowDisconnects.Load()
+	floodBroadcasts(t, srv, observer, sendQueueSize+64, 500*time.Millisecond)
+	waitFor(t, "stuck to be disconnected", func() bool { return !clients.Online("stuck") })
+	if slowDisconnects.Load() != slowBefore+1 {
+		t.Fatalf("slow disconnects %d, want %d", slowDisconnects.Load(), slowBefore+1)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 客户端发来的 WebSocket 消息按 type 分发：各功能在 init 中用 registerWSType 登记类型名、
+// data 的解码结构、限速档位、最低协议版本以及该消息是转发给他人还是由服务端消费。
+// 类型名统一为小写，核心类型不带点；派生版本或扩展自定义的类型请加命名空间前缀（如 acme.poll），
+// 重名在启动时直接 panic，不会在运行中互相覆盖。
+// 不是 JSON、未登记、协议版本不够、缺少必填字段、data 解析失败或超出限速的帧只回给该连接一帧 error，
+// 不断开连接；code 是固定的字符串，客户端可据此区分
+
+// wsEnvelope 客户端发来的消息封装
+type wsEnvelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	From uint64          `json:"from"` // resync 的起点
+	Room string          `json:"room"` // 按房间 resync 时的房间
+	ID   string          `json:"id"`   // delivered 回执的消息 ID，见 receipts.go
+}
+
+// wsFrame 交给处理函数的一帧
+type wsFrame struct {
+	ctx     context.Context
+	c       *client
+	raw     json.RawMessage
+	from    uint64
+	room    string
+	id      string
+	payload interface{} // 按 payload 解码后的指针，类型未声明 payload 时为 nil
+}
+
+type wsType struct {
+	name     string
+	payload  func() interface{} // 返回用于解码 data 的指针；nil 表示处理函数自行解析 raw
+	rate     rateClass
+	minProto int      // 低于该协议版本的连接发来时返回 unsupported_type
+	relayed  bool     // data 转发给其他用户（true）还是由服务端消费
+	feature  string   // 登记后出现在 init 与 /api/capabilities 的 features 中，可为空
+	required []string // data 中必须给出且非空的字段，缺少时返回 missing_field
+	handle   func(f *wsFrame)
+}
+
+var wsTypes = make(map[string]*wsType)
+
+var wsTypeName = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-z][a-z0-9_]*)*$`)
+
+const maxWSTypeLen = 64
+
+// registerWSType 登记一类客户端消息，只在 init 中调用
+func registerWSType(t wsType) {
+	if !wsTypeName.MatchString(t.name) || len(t.name) > maxWSTypeLen {
+		panic(fmt.Sprintf("invalid websocket message type %q", t.name))
+	}
+	if _, dup := wsTypes[t.name]; dup {
+		panic(fmt.Sprintf("websocket message type %q registered twice", t.name))
+	}
+	if t.minProto == 0 {
+		t.minProto = 1
+	}
+	wsTypes[t.name] = &t
+}
+
+// normalizeWSType 去掉首尾空白并转为小写，不合法的类型名返回 false
+func normalizeWSType(typ string) (string, bool) {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	return typ, len(typ) <= maxWSTypeLen && wsTypeName.MatchString(typ)
+}
+
+// 限速档位：每个连接每档一个令牌桶，同一档的消息共享额度
+type rateClass int
+
+const (
+	rateNone    rateClass = iota // 不限速（自身已有节流，如 typing）
+	rateRelay                    // 转发给他人的信令，ICE candidate 会成批到达
+	rateChat                     // 聊天消息
+	rateControl                  // 修改自身状态或发起请求
+	rateClasses
+)
+
+var rateLimits = [rateClasses]struct {
+	name      string
+	burst     float64
+	perSecond float64
+}{
+	rateNone:    {name: "none"},
+	rateRelay:   {name: "relay", burst: 200, perSecond: 50},
+	rateChat:    {name: "chat", burst: 20, perSecond: 2},
+	rateControl: {name: "control", burst: 10, perSecond: 1},
+}
+
+// tokenBucket 只由该连接的读循环访问
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(class rateClass, now time.Time) bool {
+	l := rateLimits[class]
+	return b.take(l.burst, l.perSecond, now)
+}
+
+// take 取一个令牌，burst 为 0 表示不限
+func (b *tokenBucket) take(burst, perSecond float64, now time.Time) bool {
+	if burst == 0 {
+		return true
+	}
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sendWSError 回给该连接一帧 {"type":"error","data":{"code","error","type"}}，无法确定类型时省略 type
+func sendWSError(c *client, typ, code, reason string, extra map[string]interface{}) {
+	data := map[string]interface{}{"code": code, "error": reason}
+	if typ != "" {
+		data["type"] = typ
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	c.send(mustMarshal(map[string]interface{}{"type": "error", "data": data}))
+}
+
+// dispatchWS 在读循环中处理一帧
+func dispatchWS(c *client, env wsEnvelope) {
+	name, valid := normalizeWSType(env.Type)
+	t := wsTypes[name]
+	span := "ws.unknown"
+	if t != nil {
+		span = "ws." + name
+	}
+	ctx, sp := tracer.Start(context.Background(), span, trace.WithAttributes(attribute.String("user.id", c.userID)))
+	defer sp.End()
+
+	switch {
+	case strings.TrimSpace(env.Type) == "":
+		sendWSError(c, "", "missing_field", "message type is missing", map[string]interface{}{"field": "type"})
+		return
+	case !valid:
+		sendWSError(c, env.Type, "invalid_type", "message type must be lowercase letters, digits, '_' and '.'", nil)
+		return
+	case t == nil:
+		sendWSError(c, name, "unknown_type", "unknown message type", nil)
+		return
+	case c.proto < t.minProto:
+		sendWSError(c, name, "unsupported_type", "message type requires a newer protocol version", map[string]interface{}{"minProto": t.minProto})
+		return
+	case !c.rates[t.rate].allow(t.rate, time.Now()):
+		sendWSError(c, name, "rate_limited", "too many messages, slow down", map[string]interface{}{"rateClass": rateLimits[t.rate].name})
+		return
+	}
+
+	if field, ok := missingField(env.Data, t.required); !ok {
+		sendWSError(c, name, "invalid_payload", "invalid "+name+" payload", nil)
+		return
+	} else if field != "" {
+		sendWSError(c, name, "missing_field", "required field is missing: "+field, map[string]interface{}{"field": field})
+		return
+	}
+
+	f := &wsFrame{ctx: ctx, c: c, raw: env.Data, from: env.From, room: env.Room, id: env.ID}
+	if t.payload != nil {
+		f.payload = t.payload()
+		if err := json.Unmarshal(env.Data, f.payload); err != nil {
+			sendWSError(c, name, "invalid_payload", "invalid "+name+" payload", nil)
+			return
+		}
+	}
+	t.handle(f)
+}
+
+// missingField 返回 data 中第一个缺少或为空（null、""）的必填字段；data 不是对象时 ok 为 false
+func missingField(data json.RawMessage, required []string) (field string, ok bool) {
+	if len(required) == 0 {
+		return "", true
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", false
+	}
+	for _, name := range required {
+		switch string(fields[name]) {
+		case "", "null", `""`:
+			return name, true
+		}
+	}
+	return "", true
+}
+
+// messageTypeInfo /api/capabilities 中列出的一类客户端消息
+type messageTypeInfo struct {
+	Type      string   `json:"type"`
+	MinProto  int      `json:"minProto"`
+	Relayed   bool     `json:"relayed"`
+	RateClass string   `json:"rateClass"`
+	Required  []string `json:"required,omitempty"`
+}
+
+func messageTypes() []messageTypeInfo {
+	list := make([]messageTypeInfo, 0, len(wsTypes))
+	for _, t := range wsTypes {
+		list = append(list, messageTypeInfo{Type: t.name, MinProto: t.minProto, Relayed: t.relayed, RateClass: rateLimits[t.rate].name, Required: t.required})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Type < list[j].Type })
+	return list
+}
+
+func init() {
+	registerWSType(wsType{
+		name:     "signal",
+		payload:  func() interface{} { return new(SignalMessage) },
+		rate:     rateRelay,
+		relayed:  true,
+		required: []string{"type", "to"},
+		handle:   handleSignal,
+	})
+	registerWSType(wsType{
+		name: "message",
+		rate: rateChat,
+		handle: func(f *wsFrame) {
+			f.c.typing.stop()
+			handleChatMessage(f.ctx, f.c, f.raw)
+		},
+	})
+	registerWSType(wsType{
+		name: "token_refresh",
+		rate: rateControl,
+		handle: func(f *wsFrame) {
+			// 上传令牌绑定当前连接，不发给同一用户的其他连接
+			replyTo(f.c, map[string]interface{}{"type": "upload_token", "data": issueUploadToken(f.c.conn, f.c.userID)})
+		},
+	})
+}
+
+// handleSignal 把 WebRTC 信令原样转发给目标用户
+func handleSignal(f *wsFrame) {
+	s := f.payload.(*SignalMessage)
+	// 添加来源（如前端未填充）
+	if s.From == "" {
+		s.From = f.c.userID
+	}
+	payload := map[string]interface{}{
+		"type": "signal",
+		"data": s,
+	}
+	_, fwd := tracer.Start(f.ctx, "signal.forward", trace.WithAttributes(attribute.String("signal.type", s.Type), attribute.String("signal.to", s.To)))
+	if err := forwardSignal(s.To, payload); err != nil {
+		fwd.RecordError(err)
+		log.Printf("转发信令失败: %v", err)
+	}
+	fwd.End()
+}
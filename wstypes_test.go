@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// expectWSError 跳过其他帧，读到下一帧 error 并检查 code，返回其 data
+func (tc *testConn) expectWSError(code string) map[string]interface{} {
+	tc.t.Helper()
+	m := tc.expect("error")
+	data, _ := m["data"].(map[string]interface{})
+	if data["code"] != code {
+		tc.t.Fatalf("error code = %v, want %s (%v)", data["code"], code, data)
+	}
+	return data
+}
+
+func (tc *testConn) sendRaw(s string) {
+	tc.t.Helper()
+	if err := tc.conn.WriteMessage(websocket.TextMessage, []byte(s)); err != nil {
+		tc.t.Fatalf("write: %v", err)
+	}
+}
+
+func TestWSErrorInvalidJSON(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=errs")
+	tc.sendRaw(`{"type":`)
+	tc.expectWSError("invalid_json")
+}
+
+func TestWSErrorMissingType(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=errs")
+	tc.sendRaw(`{"data":{}}`)
+	if data := tc.expectWSError("missing_field"); data["field"] != "type" {
+		t.Fatalf("field = %v, want type", data["field"])
+	}
+}
+
+func TestWSErrorMissingField(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=errs")
+	tc.sendRaw(`{"type":"signal","data":{"type":"offer"}}`)
+	data := tc.expectWSError("missing_field")
+	if data["field"] != "to" || data["type"] != "signal" {
+		t.Fatalf("data = %v, want field to of signal", data)
+	}
+}
+
+func TestWSErrorInvalidType(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=errs")
+	tc.sendRaw(`{"type":"Bad Type!"}`)
+	tc.expectWSError("invalid_type")
+}
+
+func TestWSErrorUnknownType(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=errs")
+	tc.sendRaw(`{"type":"teleport","data":{}}`)
+	if data := tc.expectWSError("unknown_type"); data["type"] != "teleport" {
+		t.Fatalf("type = %v, want teleport", data["type"])
+	}
+}
+
+func TestWSErrorUnsupportedType(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=errs&proto=1")
+	tc.sendRaw(`{"type":"color","data":{"color":"#ff0000"}}`)
+	if data := tc.expectWSError("unsupported_type"); data["minProto"] != float64(2) {
+		t.Fatalf("minProto = %v, want 2", data["minProto"])
+	}
+}
+
+func TestWSErrorInvalidPayload(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=errs")
+	tc.sendRaw(`{"type":"signal","data":"not an object"}`)
+	tc.expectWSError("invalid_payload")
+}
+
+func TestWSErrorRateLimited(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=errs")
+	burst := int(rateLimits[rateControl].burst)
+	for i := 0; i <= burst; i++ {
+		tc.sendRaw(`{"type":"dnd","data":{"active":false}}`)
+	}
+	if data := tc.expectWSError("rate_limited"); data["rateClass"] != "control" {
+		t.Fatalf("rateClass = %v, want control", data["rateClass"])
+	}
+}
+
+func TestWSErrorMessageTooLarge(t *testing.T) {
+	tc := dialWS(t, newTestServer(t, nil), "uid=errs")
+	tc.sendRaw(`{"type":"message","data":{"text":"` + strings.Repeat("x", int(wsReadLimit)) + `"}}`)
+	if data := tc.expectWSError("message_too_large"); data["maxBytes"] != float64(wsReadLimit) {
+		t.Fatalf("maxBytes = %v, want %d", data["maxBytes"], wsReadLimit)
+	}
+	if code, _ := tc.closeOf(); code != closeMessageTooLarge {
+		t.Fatalf("close code = %d, want %d", code, closeMessageTooLarge)
+	}
+}